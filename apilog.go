@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// LogAPIRequestsEnv, set to "true", logs every request made to the Gandi API.
+const LogAPIRequestsEnv = "LOG_API_REQUESTS"
+
+// loggingTransport logs the method, path, status and duration of the requests
+// sent to host. Headers, which carry the token, query strings and bodies are
+// never logged.
+type loggingTransport struct {
+	next http.RoundTripper
+	host string
+	logf func(format string, args ...interface{})
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if !strings.EqualFold(req.URL.Host, t.host) {
+		return resp, err
+	}
+	duration := time.Since(start).Round(time.Millisecond)
+	path := req.URL.Path
+	// Set by apiLiveDNS.
+	if id := req.Header.Get(CorrelationIDHeader); id != "" {
+		path += " [" + id + "]"
+	}
+	if err != nil {
+		t.logf("gandi api: %s %s failed after %v: %v", req.Method, path, duration, err)
+		return resp, err
+	}
+	t.logf("gandi api: %s %s -> %d in %v", req.Method, path, resp.StatusCode, duration)
+	return resp, err
+}
+
+// installAPIRequestLogging wraps http.DefaultTransport with a loggingTransport
+// for the host of apiURL, the transport of apiHTTPClient.
+func installAPIRequestLogging(apiURL string) error {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = loggingTransport{next: http.DefaultTransport, host: u.Host, logf: klog.Infof}
+	return nil
+}
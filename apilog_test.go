@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLoggingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "secret body"}`)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	var logs []string
+	client := &http.Client{Transport: loggingTransport{
+		next: http.DefaultTransport,
+		host: u.Host,
+		logf: func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+	}}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v5/livedns/domains/example.com/records?token=x", strings.NewReader("secret request"))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(logs) != 1 || !strings.HasPrefix(logs[0], "gandi api: GET /v5/livedns/domains/example.com/records -> 404 in ") {
+		t.Fatalf("unexpected logs %q", logs)
+	}
+	for _, secret := range []string{testToken, "secret", "token=x"} {
+		if strings.Contains(logs[0], secret) {
+			t.Errorf("log %q leaks %q", logs[0], secret)
+		}
+	}
+
+	// Requests to other hosts are not logged.
+	other := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer other.Close()
+	resp, err = client.Get(other.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(logs) != 1 {
+		t.Errorf("requests to other hosts should not be logged, got %q", logs)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/config"
+)
+
+const (
+	// APIURLEnv overrides the scheme and host of the Gandi API, for instance
+	// to reach it through an API gateway.
+	APIURLEnv = "GANDI_API_URL"
+	// APIBasePathEnv is a path prefix inserted before the `/v5/` path of
+	// the API, for gateways exposing it under a sub-path.
+	APIBasePathEnv = "GANDI_API_BASE_PATH"
+)
+
+// apiURLFromEnv returns the Gandi API URL built from APIURLEnv and
+// APIBasePathEnv, see buildAPIURL.
+func apiURLFromEnv() (string, error) {
+	return buildAPIURL(os.Getenv(APIURLEnv), os.Getenv(APIBasePathEnv))
+}
+
+// buildAPIURL joins base, defaulting to the go-gandi default URL, and the
+// optional path prefix basePath into the URL go-gandi appends `/v5/` to.
+// base must be an absolute http(s) URL without path, query or fragment, so
+// that a path prefix is only ever set through basePath.
+func buildAPIURL(base, basePath string) (string, error) {
+	if base == "" {
+		base = config.APIURL
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid Gandi API URL %q: %v", base, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid Gandi API URL %q: scheme must be http or https", base)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid Gandi API URL %q: missing host", base)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid Gandi API URL %q: only a scheme and host are allowed, set a path prefix with %s", base, APIBasePathEnv)
+	}
+
+	basePath = strings.Trim(basePath, "/")
+	if basePath != "" {
+		p, err := url.Parse("/" + basePath)
+		if err != nil || p.Path != "/"+basePath {
+			return "", fmt.Errorf("invalid Gandi API base path %q", basePath)
+		}
+		basePath = "/" + basePath
+	}
+	return u.Scheme + "://" + u.Host + basePath, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// BatchWindowEnv enables batching: operations on the same rrset
+	// submitted within this duration of each other are applied with a single
+	// read and a single write. Batching is disabled when unset or zero.
+	BatchWindowEnv = "BATCH_WINDOW"
+	// BatchWorkersEnv is the number of batches flushed at once, defaults to
+	// DefaultBatchWorkers.
+	BatchWorkersEnv = "BATCH_WORKERS"
+	// DefaultBatchWorkers keeps the write rate to Gandi modest.
+	DefaultBatchWorkers = 4
+)
+
+// errBatchAbandoned stops a batch whose operations all gave up waiting.
+var errBatchAbandoned = errors.New("batch: every operation gave up waiting")
+
+// rrsetOp adds or removes one challenge value.
+type rrsetOp struct {
+	key    string
+	remove bool
+	// gandiClient is the client of the operation, bound to its call; the
+	// batch is read and written with that of its first operation still
+	// waiting at the time.
+	gandiClient liveDNSClient
+	// ctx is done once the operation gave up waiting, it is then left out.
+	ctx context.Context
+	// done receives the result of the operation.
+	done chan error
+}
+
+// rrsetBatch is the set of operations pending on an rrset.
+type rrsetBatch struct {
+	cfg    gandiDNSProviderConfig
+	domain string
+	name   string
+	ops    []rrsetOp
+}
+
+// rrsetBatcher groups the operations submitted on an rrset within a window.
+// Its zero value is ready to use.
+type rrsetBatcher struct {
+	mu      sync.Mutex
+	pending map[string]*rrsetBatch
+
+	// queue feeds the flush workers; batches are flushed in their own
+	// goroutine when it is nil.
+	queue   chan *rrsetBatch
+	stop    <-chan struct{}
+	workers sync.WaitGroup
+}
+
+// startBatchWorkers starts n goroutines flushing the batches, until stop is
+// closed. Batches due afterwards are flushed by their own goroutine, so that
+// no operation is left waiting during shutdown.
+func (c *gandiDNSProviderSolver) startBatchWorkers(n int, stop <-chan struct{}) {
+	b := &c.batches
+	b.queue = make(chan *rrsetBatch)
+	b.stop = stop
+	for i := 0; i < n; i++ {
+		b.workers.Add(1)
+		go func() {
+			defer b.workers.Done()
+			for {
+				select {
+				case batch := <-b.queue:
+					c.flush(batch)
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// dispatch hands batch to a flush worker, or flushes it when there is none.
+func (c *gandiDNSProviderSolver) dispatch(batch *rrsetBatch) {
+	b := &c.batches
+	if b.queue == nil {
+		c.flush(batch)
+		return
+	}
+	select {
+	case b.queue <- batch:
+	case <-b.stop:
+		c.flush(batch)
+	}
+}
+
+// submit queues op on the rrset name of domain and waits for its result,
+// until ctx is done. The first operation of a batch flushes it once window
+// has elapsed. Only operations of the same scope, and so with the same
+// credentials, and with the same write settings share a batch, see
+// batchKey. Once the webhook is shutting down, op is applied right away.
+func (c *gandiDNSProviderSolver) submit(ctx context.Context, window time.Duration, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, scope, domain, name string, op rrsetOp) error {
+	b := &c.batches
+	key := scope + "\x00" + rrsetKey(domain, name) + "\x00" + cfg.batchKey(domain)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	op.gandiClient, op.ctx, op.done = gandiClient, ctx, make(chan error, 1)
+
+	select {
+	case <-c.stopCh:
+		c.flush(&rrsetBatch{cfg: cfg, domain: domain, name: name, ops: []rrsetOp{op}})
+		return <-op.done
+	default:
+	}
+
+	b.mu.Lock()
+	if b.pending == nil {
+		b.pending = map[string]*rrsetBatch{}
+	}
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &rrsetBatch{cfg: cfg, domain: domain, name: name}
+		b.pending[key] = batch
+		time.AfterFunc(window, func() {
+			b.mu.Lock()
+			delete(b.pending, key)
+			b.mu.Unlock()
+			c.dispatch(batch)
+		})
+	} else {
+		klog.FromContext(ctx).V(6).Info("batch: joining the batch", "challengeFQDN", name, "domain", domain)
+	}
+	batch.ops = append(batch.ops, op)
+	b.mu.Unlock()
+
+	select {
+	case err := <-op.done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("batch: gave up waiting for the write of challengeFQDN=%s, domain=%s: %w", name, domain, ctx.Err())
+	}
+}
+
+// batchKey returns the settings of cfg that shape the writes to the rrsets
+// of domain, which the operations of a batch must agree on.
+func (cfg gandiDNSProviderConfig) batchKey(domain string) string {
+	return fmt.Sprintf("ttl=%d preserveTTL=%t quote=%t merge=%t conflicts=%d max=%d names=%s",
+		cfg.ttlFor(domain), cfg.PreserveExistingTTL, cfg.QuoteTXTValues, cfg.MergeOnChange,
+		cfg.conflictRetries(), cfg.maxValues(), cfg.RecordNameForm)
+}
+
+// flush applies the operations of batch still waiting in order, with one
+// read and at most one write, then reports the result to each operation.
+func (c *gandiDNSProviderSolver) flush(batch *rrsetBatch) {
+	var ops []rrsetOp
+	for _, op := range batch.ops {
+		if op.ctx.Err() == nil {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		return
+	}
+	// The batch outlives the calls of its operations, which stop waiting
+	// for it on their own.
+	unlock, err := c.lockRrset(context.Background(), batch.domain, batch.name)
+	if err != nil {
+		for _, op := range ops {
+			op.done <- err
+		}
+		return
+	}
+	defer unlock()
+
+	// The batch goes on when its first operation gives up, for the others.
+	// Its lines carry the correlation ID of that operation, the others
+	// logged theirs when joining it.
+	ctx, cancel := batch.cfg.operationContext(context.WithoutCancel(ops[0].ctx))
+	defer cancel()
+	var errs []error
+	err = retryRrsetFlip(ctx, batch.cfg, batch.domain, batch.name, func() (err error) {
+		errs, err = applyBatch(ctx, batch.cfg, batch.domain, batch.name, ops)
+		return err
+	})
+	for i, op := range ops {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+		op.done <- errs[i]
+	}
+}
+
+// liveClient returns the client of the first of ops still waiting, nil once
+// they all gave up.
+func liveClient(ops []rrsetOp) liveDNSClient {
+	for _, op := range ops {
+		if op.ctx.Err() == nil {
+			return op.gandiClient
+		}
+	}
+	return nil
+}
+
+// applyBatch computes the values left by ops, in order, and writes them
+// like applyChallengeOnce and removeChallengeOnce do, with the client of an
+// operation still waiting. It returns the errors of the operations rejected
+// along the way, which do not affect the others, and the error of the read
+// or write.
+func applyBatch(ctx context.Context, cfg gandiDNSProviderConfig, domain, name string, ops []rrsetOp) ([]error, error) {
+	errs := make([]error, len(ops))
+
+	gandiClient := liveClient(ops)
+	if gandiClient == nil {
+		return errs, errBatchAbandoned
+	}
+	domainRecord, existed, err := getChallengeRrset(ctx, gandiClient, domain, name)
+	if err != nil {
+		return errs, fmt.Errorf("batch: pre: unable to check TXT record: %w", classifyGandiError(err))
+	}
+	klog.FromContext(ctx).V(6).Info("batch: applying operations", "operations", len(ops), "challengeFQDN", name, "domain", domain, "domainRecord", domainRecord)
+
+	values := domainRecord.RrsetValues
+	changed, presenting := false, false
+	added := make([]bool, len(ops))
+	for i, op := range ops {
+		var found bool
+		if op.remove {
+			values, found = removeValue(values, op.key)
+		} else {
+			values, found, errs[i] = addValue(cfg, domain, name, values, op.key)
+			added[i] = found
+			presenting = presenting || errs[i] == nil
+		}
+		changed = changed || found
+	}
+
+	ttl := effectiveTTL(cfg.ttlFor(domain))
+	if existed {
+		ttl = cfg.updateTTL(domainRecord, ttl)
+	}
+	// As applyChallengeOnce, a Present also brings an outdated TTL up to
+	// date.
+	outdated := existed && presenting && len(values) > 0 && domainRecord.RrsetTTL != ttl
+	if !changed && !outdated || !existed && len(values) == 0 {
+		return errs, nil
+	}
+
+	if gandiClient = liveClient(ops); gandiClient == nil {
+		return errs, errBatchAbandoned
+	}
+	if err := writeRrset(ctx, gandiClient, cfg, "batch", domain, name, domainRecord, existed, ttl, values); err != nil {
+		return errs, err
+	}
+	if ttl == GandiMinTtl {
+		for i := range ops {
+			if added[i] {
+				reportClampedTTL(ctx, cfg.ttlFor(domain))
+			}
+		}
+	}
+	return errs, nil
+}
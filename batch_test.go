@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// runBatchScenario presents and cleans up concurrently with the given batching
+// window and returns the final values and the number of writes.
+func runBatchScenario(t *testing.T, window time.Duration) ([]string, int) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other"})
+	solver := newTestSolver(gandiClient)
+	solver.batchWindow = window
+
+	run := func(keys []string, op func(string) error) {
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				if err := op(key); err != nil {
+					t.Error(err)
+				}
+			}(key)
+		}
+		wg.Wait()
+	}
+	present := func(key string) error { return solver.Present(newTestChallenge(key)) }
+	cleanUp := func(key string) error { return solver.CleanUp(newTestChallenge(key)) }
+
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	run(keys, present)
+	run(keys[:5], cleanUp)
+	run([]string{"key-10", "key-5"}, present)
+
+	values := append([]string(nil), gandiClient.values("example.com", "_acme-challenge", "TXT")...)
+	sort.Strings(values)
+	writes := gandiClient.countCalls("create") + gandiClient.countCalls("update") + gandiClient.countCalls("delete")
+	return values, writes
+}
+
+func TestBatchingMatchesIndividualCalls(t *testing.T) {
+	want, individualWrites := runBatchScenario(t, 0)
+	got, batchedWrites := runBatchScenario(t, 50*time.Millisecond)
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("batched final values %v differ from individual ones %v", got, want)
+	}
+	if batchedWrites >= individualWrites {
+		t.Errorf("batching should reduce the writes, got %d batched and %d individual", batchedWrites, individualWrites)
+	}
+}
+
+// waitingOps gives ops the client gandiClient and a context still waiting.
+func waitingOps(gandiClient liveDNSClient, ops []rrsetOp) []rrsetOp {
+	for i := range ops {
+		ops[i].gandiClient, ops[i].ctx = gandiClient, context.Background()
+	}
+	return ops
+}
+
+func TestApplyBatch(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	cfg := gandiDNSProviderConfig{MaxValues: 2}
+
+	// Adding then removing a value on an absent rrset makes no write.
+	errs, err := applyBatch(context.Background(), cfg, "example.com", "_acme-challenge", waitingOps(gandiClient, []rrsetOp{{key: "a"}, {key: "a", remove: true}}))
+	if err != nil || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("applyBatch: %v, %v", errs, err)
+	}
+	if n := gandiClient.countCalls("create") + gandiClient.countCalls("update"); n != 0 {
+		t.Errorf("expected no write, got %d", n)
+	}
+
+	// Operations over the cap fail alone.
+	errs, err = applyBatch(context.Background(), cfg, "example.com", "_acme-challenge", waitingOps(gandiClient, []rrsetOp{{key: "a"}, {key: "b"}, {key: "c"}}))
+	if err != nil || errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Fatalf("expected only the third value to be rejected, got %v, %v", errs, err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "a,b" {
+		t.Errorf("unexpected values %v", got)
+	}
+
+	// Creating the rrset checks its size as well.
+	big := waitingOps(newFakeLiveDNS(), []rrsetOp{{key: strings.Repeat("x", GandiMaxRrsetSize+1)}})
+	if _, err := applyBatch(context.Background(), cfg, "example.com", "_acme-challenge", big); err == nil {
+		t.Error("expected an oversized rrset to be rejected on create")
+	}
+
+	// Removing every value deletes the rrset.
+	if _, err := applyBatch(context.Background(), cfg, "example.com", "_acme-challenge", waitingOps(gandiClient, []rrsetOp{{key: "a", remove: true}, {key: "b", remove: true}})); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("expected the rrset to be deleted, got %v", got)
+	}
+}
+
+// callBoundLiveDNS fails its writes once ctx is done, like the clients bound
+// to a call, and runs read after each read.
+type callBoundLiveDNS struct {
+	liveDNSClient
+	ctx  context.Context
+	read func()
+}
+
+func (c callBoundLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := c.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	if c.read != nil {
+		c.read()
+	}
+	return record, err
+}
+
+func (c callBoundLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	if err := c.ctx.Err(); err != nil {
+		return types.StandardResponse{}, err
+	}
+	return c.liveDNSClient.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+}
+
+func (c callBoundLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	if err := c.ctx.Err(); err != nil {
+		return types.StandardResponse{}, err
+	}
+	return c.liveDNSClient.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+}
+
+func TestBatchOutlivesFirstOperation(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other"})
+	solver := newTestSolver(gandiClient)
+
+	// The first operation gives up while the rrset is being read.
+	first, giveUp := context.WithCancel(context.Background())
+	defer giveUp()
+	ops := []rrsetOp{
+		{key: "a", ctx: first, gandiClient: callBoundLiveDNS{liveDNSClient: gandiClient, ctx: first, read: giveUp}, done: make(chan error, 1)},
+		{key: "b", ctx: context.Background(), gandiClient: callBoundLiveDNS{liveDNSClient: gandiClient, ctx: context.Background()}, done: make(chan error, 1)},
+	}
+	solver.flush(&rrsetBatch{domain: "example.com", name: "_acme-challenge", ops: ops})
+
+	if err := <-ops[1].done; err != nil {
+		t.Fatalf("the second operation failed with the first: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "other,a,b" {
+		t.Errorf("unexpected rrset values %v", got)
+	}
+}
+
+func TestApplyBatchUpdatesTTL(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"a"})
+	cfg := gandiDNSProviderConfig{TTL: 600}
+
+	// As an unbatched Present, a value already there with an outdated TTL is
+	// rewritten.
+	if _, err := applyBatch(context.Background(), cfg, "example.com", "_acme-challenge", waitingOps(gandiClient, []rrsetOp{{key: "a"}})); err != nil {
+		t.Fatal(err)
+	}
+	record, err := gandiClient.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.RrsetTTL != 600 {
+		t.Errorf("expected the TTL to be updated to 600, got %d", record.RrsetTTL)
+	}
+}
+
+func TestApplyBatchCountsClampedTTLPerAddedValue(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"a"})
+	cfg := gandiDNSProviderConfig{TTL: 60}
+
+	before := counterValue(t, ttlClampedTotal)
+	if _, err := applyBatch(context.Background(), cfg, "example.com", "_acme-challenge", waitingOps(gandiClient, []rrsetOp{{key: "a"}, {key: "b"}, {key: "a", remove: true}})); err != nil {
+		t.Fatal(err)
+	}
+	if clamped := counterValue(t, ttlClampedTotal) - before; clamped != 1 {
+		t.Errorf("expected the clamp counter to increase by 1, got %v", clamped)
+	}
+}
+
+func TestBatchWorkersStopOnShutdown(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	solver.batchWindow = 10 * time.Millisecond
+	stopCh := make(chan struct{})
+	solver.stopCh = stopCh
+	solver.startBatchWorkers(2, stopCh)
+
+	if err := solver.Present(newTestChallenge("key-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	close(stopCh)
+	stopped := make(chan struct{})
+	go func() {
+		solver.batches.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the flush workers did not stop")
+	}
+
+	// Batches due after the shutdown are still flushed.
+	if err := solver.Present(newTestChallenge("key-2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key-1,key-2" {
+		t.Errorf("unexpected rrset values %v", got)
+	}
+}
+
+func TestBatchKeepsSettingsApart(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	bare := gandiDNSProviderConfig{}
+	quoted := gandiDNSProviderConfig{QuoteTXTValues: true}
+
+	var wg sync.WaitGroup
+	for key, cfg := range map[string]gandiDNSProviderConfig{"a": bare, "b": quoted} {
+		wg.Add(1)
+		go func(key string, cfg gandiDNSProviderConfig) {
+			defer wg.Done()
+			if err := solver.submit(context.Background(), 20*time.Millisecond, gandiClient, cfg, "scope", "example.com", "_acme-challenge", rrsetOp{key: key}); err != nil {
+				t.Error(err)
+			}
+		}(key, cfg)
+	}
+	wg.Wait()
+	got := gandiClient.values("example.com", "_acme-challenge", "TXT")
+	sort.Strings(got)
+	if strings.Join(got, ",") != `"b",a` {
+		t.Errorf("expected each value written with its own settings, got %v", got)
+	}
+}
+
+func TestBatchSubmitHonorsContext(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := solver.submit(ctx, 50*time.Millisecond, gandiClient, gandiDNSProviderConfig{}, "scope", "example.com", "_acme-challenge", rrsetOp{key: "late"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the submit to give up with its context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("expected the submit to return with its deadline, took %v", elapsed)
+	}
+
+	// The abandoned operation is left out of the batch it was in.
+	if err := solver.submit(context.Background(), 50*time.Millisecond, gandiClient, gandiDNSProviderConfig{}, "scope", "example.com", "_acme-challenge", rrsetOp{key: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key" {
+		t.Errorf("expected only the waiting operation to be written, got %v", got)
+	}
+}
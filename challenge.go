@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// rrsetState is the state of a challenge TXT rrset as left by an operation.
+type rrsetState struct {
+	Domain string
+	Name   string
+	Type   string
+	Class  string
+	TTL    int
+	// Values is empty when the rrset does not exist.
+	Values []string
+}
+
+// newRrsetState returns the state of the absent challenge rrset name of domain.
+func newRrsetState(domain, name string) rrsetState {
+	return rrsetState{Domain: domain, Name: name, Type: ChallengeRecordType, Class: RecordClassIN}
+}
+
+// Exists reports whether the rrset holds any value.
+func (s rrsetState) Exists() bool {
+	return len(s.Values) > 0
+}
+
+// getChallengeRrset reads the challenge rrset name of domain. found is
+// decided from the answer as a whole rather than from its values alone: a
+// record not found error or an entirely empty answer, as sent for some
+// freshly enabled zones, mean the rrset is absent, while any other
+// successful answer means it exists, even without values or with its name
+// left out. Writing to an rrset that exists needs an update rather than a
+// create. A 404 does not tell a missing rrset from a missing zone: the zone
+// is only read when the create that follows fails too, see writeRrset.
+func getChallengeRrset(ctx context.Context, gandiClient liveDNSClient, domain, name string) (record livedns.DomainRecord, found bool, err error) {
+	record, err = gandiClient.GetDomainRecordByNameAndType(domain, name, ChallengeRecordType)
+	if err != nil {
+		if isRecordNotFound(err) {
+			return livedns.DomainRecord{}, false, nil
+		}
+		return livedns.DomainRecord{}, false, err
+	}
+	if record.RrsetName == "" && record.RrsetType == "" && record.RrsetTTL == 0 && len(record.RrsetValues) == 0 {
+		return record, false, nil
+	}
+	// The query matches a single rrset, anything else would be written
+	// back over the wrong one.
+	if (record.RrsetType != "" && !strings.EqualFold(record.RrsetType, ChallengeRecordType)) ||
+		(record.RrsetName != "" && !strings.EqualFold(record.RrsetName, name)) {
+		return livedns.DomainRecord{}, false, fmt.Errorf("gandi answered the query for the %s record %s of %s with the %s record %s, refusing to use it",
+			ChallengeRecordType, name, domain, record.RrsetType, record.RrsetName)
+	}
+	if len(record.RrsetValues) == 0 {
+		klog.FromContext(ctx).Info("TXT record exists without values, treating it as existing", "name", name, "domain", domain)
+	}
+	if values := uniqueValues(record.RrsetValues); len(values) != len(record.RrsetValues) {
+		// Repeated values hint at several rrsets merged into one answer.
+		klog.FromContext(ctx).Info("TXT record holds repeated values, keeping the first occurrence of each",
+			"name", name, "domain", domain, "repeated", len(record.RrsetValues)-len(values))
+		record.RrsetValues = values
+	}
+	return record, true, nil
+}
+
+// cnameTarget returns the name, relative to domain, that the CNAME rrset
+// name of domain points to, or "" when there is no such rrset or it points
+// out of the zone.
+func cnameTarget(gandiClient liveDNSClient, domain, name string) (string, error) {
+	record, err := gandiClient.GetDomainRecordByNameAndType(domain, name, "CNAME")
+	if err != nil {
+		if isRecordNotFound(err) {
+			return "", nil
+		}
+		return "", classifyGandiError(err)
+	}
+	if len(record.RrsetValues) == 0 {
+		return "", nil
+	}
+	// Relative targets are relative to the zone.
+	target := strings.ToLower(record.RrsetValues[0])
+	if !strings.HasSuffix(target, ".") {
+		return target, nil
+	}
+	target = strings.TrimSuffix(target, ".")
+	zone := strings.ToLower(strings.TrimSuffix(domain, "."))
+	switch {
+	case target == zone:
+		return "@", nil
+	case strings.HasSuffix(target, "."+zone):
+		return strings.TrimSuffix(target, "."+zone), nil
+	}
+	return "", nil
+}
+
+// checkDomain reads the zone domain and returns errDomainNotFound when Gandi
+// does not know it. Other failures are left to the calls on its records.
+func checkDomain(gandiClient liveDNSClient, domain string) error {
+	_, err := gandiClient.GetDomain(domain)
+	if err != nil && isRecordNotFound(err) {
+		return domainNotFoundError(err)
+	}
+	return nil
+}
+
+// isRecordNotFound reports whether err tells that the requested record does
+// not exist, as opposed to its zone.
+func isRecordNotFound(err error) bool {
+	var reqErr *types.RequestError
+	if errors.As(err, &reqErr) && reqErr.StatusCode != 0 {
+		return errors.Is(classifyGandiError(err), errRecordNotFound)
+	}
+	return strings.Contains(err.Error(), "404")
+}
+
+// updateTTL returns the TTL to write when updating the existing rrset
+// record: its own with PreserveExistingTTL, ttl otherwise.
+func (cfg gandiDNSProviderConfig) updateTTL(record livedns.DomainRecord, ttl int) int {
+	if cfg.PreserveExistingTTL && record.RrsetTTL > 0 {
+		return record.RrsetTTL
+	}
+	return ttl
+}
+
+// isRrsetFlip reports whether err is a write refused because the rrset was
+// created or deleted between its read and the write, Gandi's backends not
+// always agreeing right away, or abandoned by checkUnchanged.
+func isRrsetFlip(err error) bool {
+	return errors.Is(err, errRrsetExists) || errors.Is(err, errRecordNotFound) || errors.Is(err, errRrsetChanged)
+}
+
+// checkUnchanged reads the rrset name of domain again right before it is
+// replaced or deleted when cfg.MergeOnChange is set, and fails with
+// errRrsetChanged when its values are no longer those of read, so that the
+// caller merges with the new ones instead of dropping them. Gandi has no
+// conditional writes: this narrows the window of a clobbering race with
+// other tools editing the rrset, it does not close it.
+func checkUnchanged(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name string, read livedns.DomainRecord) error {
+	if !cfg.MergeOnChange {
+		return nil
+	}
+	current, _, err := getChallengeRrset(ctx, gandiClient, domain, name)
+	if err != nil {
+		return fmt.Errorf("unable to check TXT record again: %w", classifyGandiError(err))
+	}
+	if !sameValues(current.RrsetValues, read.RrsetValues) {
+		return fmt.Errorf("TXT record %s of %s went from %v to %v: %w", name, domain, read.RrsetValues, current.RrsetValues, errRrsetChanged)
+	}
+	return nil
+}
+
+// sameValues reports whether a and b hold the same values, in any order.
+func sameValues(a, b []string) bool {
+	a, b = uniqueValues(a), uniqueValues(b)
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]struct{}, len(a))
+	for _, v := range a {
+		seen[v] = struct{}{}
+	}
+	for _, v := range b {
+		if _, ok := seen[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// retryRrsetFlip runs apply, which reads the rrset name of domain then
+// writes it, again while it fails with an isRrsetFlip error, so that the
+// next attempt picks create or update from a fresh read. It gives up after
+// cfg.conflictRetries retries.
+func retryRrsetFlip(ctx context.Context, cfg gandiDNSProviderConfig, domain, name string, apply func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := apply()
+		if err == nil || attempt >= cfg.conflictRetries() || !isRrsetFlip(err) {
+			return err
+		}
+		klog.FromContext(ctx).V(6).Info("TXT record changed since it was read, reading it again", "name", name, "domain", domain, "err", err)
+	}
+}
+
+// applyChallenge adds key to the TXT rrset name of domain, keeping the values
+// of other in-flight challenges, and returns the resulting state. A write
+// racing with a change of the rrset is retried, see retryRrsetFlip.
+func applyChallenge(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name, key string) (state rrsetState, err error) {
+	written := false
+	err = retryRrsetFlip(ctx, cfg, domain, name, func() error {
+		var wrote bool
+		state, wrote, err = applyChallengeOnce(ctx, gandiClient, cfg, domain, name, key)
+		written = written || wrote
+		return err
+	})
+	if err == nil && written && state.TTL == GandiMinTtl {
+		reportClampedTTL(ctx, cfg.ttlFor(domain))
+	}
+	return state, err
+}
+
+// applyChallengeOnce reads the rrset and writes it with key, see
+// applyChallenge. It reports whether it wrote the rrset.
+func applyChallengeOnce(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name, key string) (rrsetState, bool, error) {
+	ttl := effectiveTTL(cfg.ttlFor(domain))
+	state := newRrsetState(domain, name)
+	state.TTL = ttl
+
+	domainRecord, exists, err := getChallengeRrset(ctx, gandiClient, domain, name)
+	if err != nil {
+		return state, false, fmt.Errorf("present: pre: unable to check TXT record: %w", classifyGandiError(err))
+	}
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("present: pre", "domainRecord", domainRecord)
+
+	if cfg.ForceReplace && exists {
+		logger.Info("present: forceReplace is set, replacing the existing values",
+			"values", len(domainRecord.RrsetValues), "challengeFQDN", name, "domain", domain)
+		if err := gandiClient.DeleteDomainRecord(domain, name, ChallengeRecordType); err != nil {
+			return state, false, fmt.Errorf("present: unable to remove TXT record: %w", classifyGandiError(err))
+		}
+		domainRecord, exists = livedns.DomainRecord{}, false
+	}
+
+	if exists {
+		ttl = cfg.updateTTL(domainRecord, ttl)
+		state.TTL = ttl
+		// Other challenges for the same name (e.g. a wildcard and its apex)
+		// may be in flight, so add our key next to the existing values.
+		values, added, err := addValue(cfg, domain, name, domainRecord.RrsetValues, key)
+		if err != nil {
+			return state, false, err
+		}
+		if !added && domainRecord.RrsetTTL == ttl {
+			logger.V(6).Info("present: key already present, nothing to do", "challengeFQDN", name, "domain", domain)
+			state.Values = values
+			return state, false, nil
+		}
+		if err := writeRrset(ctx, gandiClient, cfg, "present", domain, name, domainRecord, true, ttl, values); err != nil {
+			return state, false, err
+		}
+		state.Values = values
+	} else {
+		values, _, err := addValue(cfg, domain, name, nil, key)
+		if err != nil {
+			return state, false, err
+		}
+		if err := writeRrset(ctx, gandiClient, cfg, "present", domain, name, livedns.DomainRecord{}, false, ttl, values); err != nil {
+			return state, false, err
+		}
+		state.Values = values
+	}
+
+	return state, true, nil
+}
+
+// removeChallenge removes key from the TXT rrset name of domain, deleting the
+// rrset once no value is left, and returns the resulting state. Like
+// applyChallenge, a write racing with a change of the rrset is retried.
+func removeChallenge(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name, key string) (state rrsetState, err error) {
+	err = retryRrsetFlip(ctx, cfg, domain, name, func() error {
+		state, err = removeChallengeOnce(ctx, gandiClient, cfg, domain, name, key)
+		return err
+	})
+	return state, err
+}
+
+// removeChallengeOnce reads the rrset and writes it without key, see
+// removeChallenge.
+func removeChallengeOnce(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name, key string) (rrsetState, error) {
+	state := newRrsetState(domain, name)
+
+	domainRecord, exists, err := getChallengeRrset(ctx, gandiClient, domain, name)
+	if err != nil {
+		return state, fmt.Errorf("cleanup: pre: unable to check TXT record: %w", classifyGandiError(err))
+	}
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("cleanup: found", "domainRecord", domainRecord)
+
+	if !exists || len(domainRecord.RrsetValues) == 0 {
+		return state, nil
+	}
+	state.TTL = domainRecord.RrsetTTL
+	state.Values = domainRecord.RrsetValues
+
+	remaining, found := removeValue(domainRecord.RrsetValues, key)
+	if !found {
+		logger.V(6).Info("cleanup: key not present", "challengeFQDN", name, "domain", domain)
+		return state, nil
+	}
+
+	if len(remaining) == 0 {
+		logger.V(6).Info("cleanup: deleting", "challengeFQDN", name, "domain", domain)
+		if err := writeRrset(ctx, gandiClient, cfg, "cleanup", domain, name, domainRecord, true, 0, nil); err != nil {
+			return state, err
+		}
+		return newRrsetState(domain, name), nil
+	}
+
+	// Keep the values belonging to other in-flight challenges.
+	logger.V(6).Info("cleanup: keeping the other values", "values", len(remaining), "challengeFQDN", name, "domain", domain)
+	ttl := cfg.updateTTL(domainRecord, effectiveTTL(cfg.ttlFor(domain)))
+	if err := writeRrset(ctx, gandiClient, cfg, "cleanup", domain, name, domainRecord, true, ttl, remaining); err != nil {
+		return state, err
+	}
+
+	state.TTL, state.Values = ttl, remaining
+	return state, nil
+}
+
+// addValue returns values with the value of key added, and whether it was
+// missing. It fails when that would exceed cfg.maxValues.
+func addValue(cfg gandiDNSProviderConfig, domain, name string, values []string, key string) ([]string, bool, error) {
+	next := appendValue(values, key, cfg.QuoteTXTValues)
+	if len(next) == len(values) {
+		return values, false, nil
+	}
+	if limit := cfg.maxValues(); len(next) > limit {
+		return values, false, fmt.Errorf("present: TXT record %s of %s already holds %d values, adding one would exceed the maximum of %d; "+
+			"check for challenges that were not cleaned up", name, domain, len(values), limit)
+	}
+	return next, true, nil
+}
+
+// writeRrset writes values to the rrset name of domain, as read and found
+// to exist or not before: it deletes the rrset when no value is left,
+// creates it when it did not exist and replaces it otherwise. The size of
+// the values and, with mergeOnChange, the state of the rrset are checked
+// first. Errors are prefixed with op.
+func writeRrset(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, op, domain, name string, read livedns.DomainRecord, existed bool, ttl int, values []string) error {
+	if len(values) > 0 {
+		if err := checkRrsetSize(values); err != nil {
+			return fmt.Errorf("%s: unable to write TXT record: %v", op, err)
+		}
+	}
+	if existed {
+		if err := checkUnchanged(ctx, gandiClient, cfg, domain, name, read); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	switch {
+	case len(values) == 0:
+		if err := gandiClient.DeleteDomainRecord(domain, name, ChallengeRecordType); err != nil {
+			return fmt.Errorf("%s: unable to remove TXT record: %w", op, classifyGandiError(err))
+		}
+	case !existed:
+		resp, err := gandiClient.CreateDomainRecord(domain, name, ChallengeRecordType, ttl, values)
+		if err != nil && isRecordNotFound(err) {
+			if zoneErr := checkDomain(gandiClient, domain); zoneErr != nil {
+				err = zoneErr
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: unable to create TXT record: %w", op, classifyGandiError(err))
+		}
+		if err := responseError(resp); err != nil {
+			return fmt.Errorf("%s: %v while trying to create TXT record: %v", op, err, domain)
+		}
+	default:
+		resp, err := gandiClient.UpdateDomainRecordByNameAndType(domain, name, ChallengeRecordType, ttl, values)
+		if err != nil {
+			return fmt.Errorf("%s: unable to change TXT record: %w", op, classifyGandiError(err))
+		}
+		if err := responseError(resp); err != nil {
+			return fmt.Errorf("%s: %v while trying to change TXT record: %v", op, err, domain)
+		}
+	}
+	return nil
+}
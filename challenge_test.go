@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+func TestApplyAndRemoveChallengeState(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	cfg := gandiDNSProviderConfig{}
+
+	state, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Exists() || strings.Join(state.Values, ",") != "key-1" || state.TTL != GandiMinTtl {
+		t.Errorf("unexpected state after create: %+v", state)
+	}
+	if state.Type != "TXT" || state.Class != "IN" {
+		t.Errorf("expected a TXT rrset in the IN class, got %+v", state)
+	}
+
+	state, err = applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(state.Values, ",") != "key-1,key-2" {
+		t.Errorf("unexpected state after update: %+v", state)
+	}
+
+	state, err = removeChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(state.Values, ",") != "key-2" {
+		t.Errorf("unexpected state after partial removal: %+v", state)
+	}
+
+	state, err = removeChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Exists() {
+		t.Errorf("expected the rrset to be gone, got %+v", state)
+	}
+
+	// The reported state must match what the backend holds.
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("backend still holds %v", got)
+	}
+}
+
+// zeroCodeLiveDNS answers writes with a response carrying no code at all.
+type zeroCodeLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (f zeroCodeLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	_, err := f.fakeLiveDNS.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	return types.StandardResponse{}, err
+}
+
+func (f zeroCodeLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	_, err := f.fakeLiveDNS.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+	return types.StandardResponse{}, err
+}
+
+func TestZeroCodeResponsesAreSuccessful(t *testing.T) {
+	gandiClient := zeroCodeLiveDNS{newFakeLiveDNS()}
+	cfg := gandiDNSProviderConfig{}
+
+	for _, key := range []string{"key-1", "key-2"} {
+		if _, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", key); err != nil {
+			t.Fatalf("applyChallenge(context.Background(), %s): %v", key, err)
+		}
+	}
+	if _, err := removeChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key-1"); err != nil {
+		t.Fatalf("removeChallenge: %v", err)
+	}
+}
+
+func TestApplyChallengeMaxValues(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"stale-1", "stale-2"})
+	cfg := gandiDNSProviderConfig{MaxValues: 2}
+
+	if _, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key"); err == nil || !strings.Contains(err.Error(), "maximum of 2") {
+		t.Errorf("expected the cap to be enforced, got %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 2 {
+		t.Errorf("the rrset should be left untouched, got %v", got)
+	}
+
+	// Presenting a value already in the rrset does not grow it.
+	if _, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "stale-1"); err != nil {
+		t.Errorf("re-presenting an existing value should succeed: %v", err)
+	}
+
+	if got := (gandiDNSProviderConfig{}).maxValues(); got != DefaultMaxValues {
+		t.Errorf("default maxValues = %d, want %d", got, DefaultMaxValues)
+	}
+}
+
+// emptyAnswerLiveDNS answers reads of a missing rrset with an empty record
+// and no error, as an empty, freshly enabled zone may.
+type emptyAnswerLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (f emptyAnswerLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := f.fakeLiveDNS.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	if err != nil && strings.Contains(err.Error(), "404") {
+		return livedns.DomainRecord{}, nil
+	}
+	return record, err
+}
+
+func TestPresentAndCleanUpInEmptyZone(t *testing.T) {
+	for name, wrap := range map[string]func(*fakeLiveDNS) liveDNSClient{
+		"not found":    func(f *fakeLiveDNS) liveDNSClient { return f },
+		"empty answer": func(f *fakeLiveDNS) liveDNSClient { return emptyAnswerLiveDNS{f} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The zone holds no record at all.
+			gandiClient := newFakeLiveDNS()
+			solver := newTestSolver(gandiClient)
+			solver.newLiveDNSClient = func(config.Config) liveDNSClient { return wrap(gandiClient) }
+
+			if err := solver.Present(newTestChallenge("key")); err != nil {
+				t.Fatalf("Present: %v", err)
+			}
+			if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key" {
+				t.Errorf("expected the record to be created, got %v", got)
+			}
+			if n := gandiClient.countCalls("create"); n != 1 {
+				t.Errorf("expected one create, got %d", n)
+			}
+
+			if err := solver.CleanUp(newTestChallenge("key")); err != nil {
+				t.Fatalf("CleanUp: %v", err)
+			}
+			if len(gandiClient.records) != 0 {
+				t.Errorf("expected the zone to be empty again, got %v", gandiClient.records)
+			}
+		})
+	}
+}
+
+func TestApplyChallengeSkipsNoOpWrite(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other", "key"})
+	cfg := gandiDNSProviderConfig{}
+
+	state, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := gandiClient.countCalls("update") + gandiClient.countCalls("create"); n != 0 {
+		t.Errorf("expected no write when the rrset already matches, got %d", n)
+	}
+	if strings.Join(state.Values, ",") != "other,key" {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// A different TTL still needs a write.
+	cfg.TTL = 600
+	if _, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if n := gandiClient.countCalls("update"); n != 1 {
+		t.Errorf("expected one update to change the TTL, got %d", n)
+	}
+}
+
+// shapedLiveDNS answers reads with a fixed record and error.
+type shapedLiveDNS struct {
+	*fakeLiveDNS
+	record livedns.DomainRecord
+	err    error
+}
+
+func (f shapedLiveDNS) GetDomainRecordByNameAndType(string, string, string) (livedns.DomainRecord, error) {
+	return f.record, f.err
+}
+
+func TestGetChallengeRrsetAmbiguousAnswers(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		record livedns.DomainRecord
+		err    error
+		found  bool
+		fails  bool
+	}{
+		{name: "not found", err: notFoundError()},
+		{name: "not found without code in message", err: &types.RequestError{StatusCode: 404, Err: errors.New("no such record")}},
+		{name: "empty answer"},
+		{name: "name without values", record: livedns.DomainRecord{RrsetName: "_acme-challenge", RrsetType: "TXT", RrsetTTL: 300}, found: true},
+		{name: "values without name", record: livedns.DomainRecord{RrsetValues: []string{"other"}}, found: true},
+		{name: "server error mentioning 404", err: &types.RequestError{StatusCode: 500, Err: errors.New("upstream returned 404")}, fails: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, found, err := getChallengeRrset(context.Background(), shapedLiveDNS{fakeLiveDNS: newFakeLiveDNS(), record: tc.record, err: tc.err}, "example.com", "_acme-challenge")
+			if (err != nil) != tc.fails {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if found != tc.found {
+				t.Errorf("found = %v, want %v", found, tc.found)
+			}
+		})
+	}
+}
+
+func TestApplyChallengeUpdatesRrsetWithoutValues(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", nil)
+	cfg := gandiDNSProviderConfig{}
+
+	state, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(state.Values, ",") != "key" {
+		t.Errorf("unexpected state %+v", state)
+	}
+	if creates, updates := gandiClient.countCalls("create"), gandiClient.countCalls("update"); creates != 0 || updates != 1 {
+		t.Errorf("an existing rrset should be updated, got %d creates and %d updates", creates, updates)
+	}
+
+	if _, err := removeChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if n := gandiClient.countCalls("delete"); n != 0 {
+		t.Errorf("removing a missing value should not delete the rrset, got %d deletes", n)
+	}
+}
+
+func TestGetChallengeRrsetUnexpectedShapes(t *testing.T) {
+	for name, record := range map[string]livedns.DomainRecord{
+		"other type": {RrsetName: "_acme-challenge", RrsetType: "CNAME", RrsetValues: []string{"target."}},
+		"other name": {RrsetName: "www", RrsetType: "TXT", RrsetValues: []string{"other"}},
+	} {
+		gandiClient := shapedLiveDNS{fakeLiveDNS: newFakeLiveDNS(), record: record}
+		if _, err := applyChallenge(context.Background(), gandiClient, gandiDNSProviderConfig{}, "example.com", "_acme-challenge", "key"); err == nil {
+			t.Errorf("%s: expected the answer to be refused", name)
+		}
+		if n := gandiClient.countCalls(""); n != 0 {
+			t.Errorf("%s: nothing should be written, got %d calls", name, n)
+		}
+	}
+
+	record := livedns.DomainRecord{RrsetName: "_ACME-challenge", RrsetType: "txt", RrsetValues: []string{"a", "b", "a"}}
+	got, found, err := getChallengeRrset(context.Background(), shapedLiveDNS{record: record}, "example.com", "_acme-challenge")
+	if err != nil || !found {
+		t.Fatalf("a case mismatch should be accepted: %v, %v", found, err)
+	}
+	if strings.Join(got.RrsetValues, ",") != "a,b" {
+		t.Errorf("expected repeated values to be dropped, got %v", got.RrsetValues)
+	}
+}
+
+func TestPresentChecksCNAMETarget(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	gandiClient.set("example.com", "_acme-challenge", "CNAME", []string{"_acme-challenge.shared.example.com."})
+	gandiClient.set("example.com", "_acme-challenge.www", "CNAME", []string{"_acme-challenge.shared"})
+	challenge := func(fqdn, key string) *v1alpha1.ChallengeRequest {
+		ch := newTestChallenge(key)
+		ch.ResolvedFQDN = fqdn
+		ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "checkCNAMETarget": true}`)
+		return ch
+	}
+
+	// Each challenge writes its own value at the target.
+	apex, www := challenge(testFQDN, "key"), challenge("_acme-challenge.www.example.com.", "other")
+	for _, ch := range []*v1alpha1.ChallengeRequest{apex, www} {
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("Present: %v", err)
+		}
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge.shared", "TXT"); strings.Join(got, ",") != "key,other" {
+		t.Errorf("expected both values at the CNAME target, got %v", got)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("expected nothing written at the CNAME itself, got %v", got)
+	}
+	if err := solver.CleanUp(www); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge.shared", "TXT"); strings.Join(got, ",") != "key" {
+		t.Errorf("expected only the value of the cleaned up challenge removed, got %v", got)
+	}
+
+	// A value held through two names stays until both are cleaned up.
+	same := challenge("_acme-challenge.www.example.com.", "key")
+	if err := solver.Present(same); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if err := solver.CleanUp(apex); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge.shared", "TXT"); len(got) != 1 {
+		t.Errorf("expected the value still held by another name to stay, got %v", got)
+	}
+	if err := solver.CleanUp(same); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge.shared", "TXT"); got != nil {
+		t.Errorf("expected the CNAME target to be cleaned up, got %v", got)
+	}
+
+	// A target out of the zone is not followed.
+	gandiClient.set("example.com", "_acme-challenge", "CNAME", []string{"_acme-challenge.example.net."})
+	if err := solver.Present(apex); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("expected the value at the challenge name, got %v", got)
+	}
+
+	// Without the option, the CNAME is not looked up.
+	gets := gandiClient.countCalls("get")
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if n := gandiClient.countCalls("get") - gets; n != 1 {
+		t.Errorf("expected a single read without checkCNAMETarget, got %d", n)
+	}
+}
+
+// racingLiveDNS creates the rrset with racing values right before the first
+// create, and deletes it right before the first update, as another writer
+// would.
+type racingLiveDNS struct {
+	*fakeLiveDNS
+	racing  []string
+	created *bool
+	updated *bool
+}
+
+func (r racingLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	if !*r.created {
+		*r.created = true
+		r.set(fqdn, name, recordtype, r.racing)
+	}
+	return r.fakeLiveDNS.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+}
+
+func (r racingLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	if !*r.updated {
+		*r.updated = true
+		r.fakeLiveDNS.DeleteDomainRecord(fqdn, name, recordtype)
+		return types.StandardResponse{}, notFoundError()
+	}
+	return r.fakeLiveDNS.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+}
+
+func TestApplyChallengeRetriesCreateUpdateFlip(t *testing.T) {
+	fake := newFakeLiveDNS()
+	var created, updated bool
+	gandiClient := racingLiveDNS{fakeLiveDNS: fake, racing: []string{"other"}, created: &created, updated: &updated}
+
+	// The create is refused as the rrset appeared: the retry updates it,
+	// keeping the racing value.
+	updated = true
+	if _, err := applyChallenge(context.Background(), gandiClient, gandiDNSProviderConfig{}, "example.com", "_acme-challenge", "key"); err != nil {
+		t.Fatalf("applyChallenge: %v", err)
+	}
+	if got := fake.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "other,key" {
+		t.Errorf("expected the racing value to be kept, got %v", got)
+	}
+
+	// The update is refused as the rrset vanished: the retry creates it.
+	updated = false
+	if _, err := applyChallenge(context.Background(), gandiClient, gandiDNSProviderConfig{}, "example.com", "_acme-challenge", "key-2"); err != nil {
+		t.Fatalf("applyChallenge: %v", err)
+	}
+	if got := fake.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key-2" {
+		t.Errorf("expected the rrset to be created again, got %v", got)
+	}
+	if n := fake.countCalls("get"); n != 4 {
+		t.Errorf("expected a read before each attempt, got %d", n)
+	}
+
+	// Without retries the conflict is returned.
+	fake.records = map[string]livedns.DomainRecord{}
+	created = false
+	zero := 0
+	_, err := applyChallenge(context.Background(), gandiClient, gandiDNSProviderConfig{ConflictRetries: &zero}, "example.com", "_acme-challenge", "key")
+	if !errors.Is(err, errRrsetExists) {
+		t.Errorf("expected the conflict without conflictRetries, got %v", err)
+	}
+}
+
+// editingLiveDNS adds value to the rrset when it is read for the time
+// number edit, as another tool editing the rrset between the read and the
+// write of the webhook would.
+type editingLiveDNS struct {
+	*fakeLiveDNS
+	value string
+	edit  int
+	reads *int
+}
+
+func (e editingLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := e.fakeLiveDNS.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	*e.reads++
+	if *e.reads == e.edit {
+		e.set(fqdn, name, recordtype, append(append([]string{}, record.RrsetValues...), e.value))
+	}
+	return record, err
+}
+
+func TestMergeOnChange(t *testing.T) {
+	for _, merge := range []bool{false, true} {
+		fake := newFakeLiveDNS()
+		fake.set("example.com", "_acme-challenge", "TXT", []string{"first"})
+		reads := 0
+		gandiClient := editingLiveDNS{fakeLiveDNS: fake, value: "external", edit: 1, reads: &reads}
+		cfg := gandiDNSProviderConfig{MergeOnChange: merge}
+
+		if _, err := applyChallenge(context.Background(), gandiClient, cfg, "example.com", "_acme-challenge", "key"); err != nil {
+			t.Fatalf("mergeOnChange=%v: applyChallenge: %v", merge, err)
+		}
+		got := strings.Join(fake.values("example.com", "_acme-challenge", "TXT"), ",")
+		if want := map[bool]string{false: "first,key", true: "first,external,key"}[merge]; got != want {
+			t.Errorf("mergeOnChange=%v: got values %s, want %s", merge, got, want)
+		}
+	}
+
+	// CleanUp does not delete an rrset another tool added a value to.
+	fake := newFakeLiveDNS()
+	fake.set("example.com", "_acme-challenge", "TXT", []string{"key"})
+	reads := 0
+	gandiClient := editingLiveDNS{fakeLiveDNS: fake, value: "external", edit: 1, reads: &reads}
+	if _, err := removeChallenge(context.Background(), gandiClient, gandiDNSProviderConfig{MergeOnChange: true}, "example.com", "_acme-challenge", "key"); err != nil {
+		t.Fatalf("removeChallenge: %v", err)
+	}
+	if got := fake.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "external" {
+		t.Errorf("expected the external value to be kept, got %v", got)
+	}
+	if n := fake.countCalls("delete"); n != 0 {
+		t.Errorf("expected no delete, got %d", n)
+	}
+}
+
+func TestNonFatalCleanup(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+
+	gandiClient.failWith("update", requestError(503))
+	gandiClient.failWith("delete", requestError(503))
+	if err := solver.CleanUp(newTestChallenge("key")); err == nil {
+		t.Fatal("expected CleanUp to fail by default")
+	}
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "nonFatalCleanup": true}`)
+	if err := solver.CleanUp(ch); err != nil {
+		t.Errorf("expected a transient failure to be ignored, got %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("expected the value to be left behind, got %v", got)
+	}
+
+	// Other errors still fail.
+	gandiClient.failWith("delete", requestError(403))
+	if err := solver.CleanUp(ch); err == nil {
+		t.Error("expected a permission error to fail CleanUp")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// CoalesceCleanupsEnv, when true, makes a CleanUp of a challenge value that
+// is already being removed wait for that removal and share its result
+// instead of repeating the read-modify-write.
+const CoalesceCleanupsEnv = "COALESCE_CLEANUPS"
+
+// callGroup deduplicates concurrent calls sharing a key. Its zero value is
+// ready to use.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*groupCall
+}
+
+// groupCall is a call in flight; err is set before done is closed.
+type groupCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Do runs fn unless a call with the same key is in flight, in which case it
+// waits for that call and returns its error. shared reports the latter.
+func (g *callGroup) Do(key string, fn func() error) (err error, shared bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.err, true
+	}
+	if g.calls == nil {
+		g.calls = map[string]*groupCall{}
+	}
+	call := &groupCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+	return call.err, false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gandi/go-gandi/config"
+)
+
+func TestCoalescedCleanUps(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other", "key"})
+	release := make(chan struct{})
+	var blocked atomic.Int32
+	solver := newTestSolver(gandiClient)
+	solver.coalesceCleanups = true
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return blockingLiveDNS{fakeLiveDNS: gandiClient, zone: "example.com", release: release, blocked: &blocked}
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- solver.CleanUp(newTestChallenge("key"))
+		}()
+	}
+	// Let every CleanUp join the first one, blocked on its read.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "other" {
+		t.Errorf("unexpected rrset values %v", got)
+	}
+	if gets, updates := gandiClient.countCalls("get"), gandiClient.countCalls("update"); gets != 1 || updates != 1 {
+		t.Errorf("expected the cleanups to collapse into one read and one write, got %d and %d", gets, updates)
+	}
+
+	solver.cleanups.mu.Lock()
+	defer solver.cleanups.mu.Unlock()
+	if len(solver.cleanups.calls) != 0 {
+		t.Errorf("finished calls should be dropped, got %v", solver.cleanups.calls)
+	}
+}
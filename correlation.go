@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// AuditLogEnv, set to "true", gives each Present and CleanUp call a
+	// correlation ID, logs an audit line at its start and end and sends the
+	// ID to Gandi with each of its requests.
+	AuditLogEnv = "AUDIT_LOG"
+	// CorrelationIDHeader carries the correlation ID of a Gandi request.
+	CorrelationIDHeader = "X-Correlation-ID"
+	// userAgent is sent to Gandi, followed by the correlation ID if any.
+	userAgent = "cert-manager-webhook-gandi"
+)
+
+type correlationIDKey struct{}
+
+// newCorrelationID returns a random correlation ID.
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Only tracing is lost, the call itself goes on.
+		klog.Warningf("unable to generate a correlation ID: %v", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// withCorrelationID returns a copy of ctx carrying the correlation ID id.
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// correlationID returns the correlation ID of ctx, if any.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// withCallLogger returns a copy of ctx carrying the logger of its call, which
+// adds the correlation ID of ctx, if any, to each line.
+func withCallLogger(ctx context.Context) context.Context {
+	id := correlationID(ctx)
+	if id == "" {
+		return ctx
+	}
+	return klog.NewContext(ctx, klog.LoggerWithValues(klog.FromContext(ctx), "correlationID", id))
+}
+
+// auditRecord is the audit trail of a Present or CleanUp call.
+type auditRecord struct {
+	id        string
+	operation string
+	ch        *v1alpha1.ChallengeRequest
+	start     time.Time
+}
+
+// startAudit logs the start of operation on ch when the audit log is
+// enabled, and returns its record; the zero record logs nothing.
+func (c *gandiDNSProviderSolver) startAudit(operation string, ch *v1alpha1.ChallengeRequest) auditRecord {
+	if !c.auditLog {
+		return auditRecord{}
+	}
+	a := auditRecord{id: newCorrelationID(), operation: operation, ch: ch, start: time.Now()}
+	klog.InfoS("audit: started", "correlationID", a.id, "operation", operation,
+		"namespace", ch.ResourceNamespace, "zone", ch.ResolvedZone, "fqdn", ch.ResolvedFQDN)
+	return a
+}
+
+// end logs the outcome of the call.
+func (a auditRecord) end(err error) {
+	if a.ch == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	kv := []any{"correlationID", a.id, "operation", a.operation,
+		"namespace", a.ch.ResourceNamespace, "zone", a.ch.ResolvedZone, "fqdn", a.ch.ResolvedFQDN,
+		"result", result, "duration", time.Since(a.start).Round(time.Millisecond)}
+	if err != nil {
+		// Errors of the Gandi calls are redacted by redactingLiveDNS.
+		kv = append(kv, "err", err)
+	}
+	klog.InfoS("audit: finished", kv...)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+func TestCorrelationIDs(t *testing.T) {
+	var mu sync.Mutex
+	var requests []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Clone())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet && !strings.Contains(r.URL.Path, "/records/") {
+			fmt.Fprint(w, `{"fqdn": "example.com"}`)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": 404, "message": "Can't find the DNS record", "object": "dns-record", "cause": "Not Found"}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"message": "DNS Record Created"}`)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	klog.SetLogger(logr.New(newLogfmtSink(&out)))
+	t.Cleanup(klog.ClearLogger)
+
+	// The real Gandi client, talking to server.
+	solver := newTestSolver(newFakeLiveDNS())
+	solver.newLiveDNSClient = nil
+	solver.apiURL = server.URL
+	solver.auditLog = true
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	klog.Flush()
+
+	started := regexp.MustCompile(`msg="audit: started" correlationID=([0-9a-f]{16}) operation=present namespace=\S+ zone=example\.com\. fqdn=_acme-challenge\.example\.com\.`).FindSubmatch(out.Bytes())
+	if started == nil {
+		t.Fatalf("no audit start line in %q", out.String())
+	}
+	id := string(started[1])
+	if !regexp.MustCompile(`msg="audit: finished" correlationID=` + id + ` operation=present .* result=success duration=`).Match(out.Bytes()) {
+		t.Errorf("no audit end line for %s in %q", id, out.String())
+	}
+	if len(requests) == 0 {
+		t.Fatal("no request reached Gandi")
+	}
+	for _, h := range requests {
+		if got := h.Get(CorrelationIDHeader); got != id {
+			t.Errorf("expected %s %s, got %q", CorrelationIDHeader, id, got)
+		}
+		if got := h.Get("User-Agent"); got != "cert-manager-webhook-gandi correlation-id/"+id {
+			t.Errorf("unexpected User-Agent %q", got)
+		}
+		if got := h.Get("Authorization"); !strings.HasPrefix(got, "Bearer ") {
+			t.Errorf("expected the token to be sent as usual, got Authorization %q", got)
+		}
+	}
+
+	// Each call has its own ID, and none is sent with the audit log off.
+	requests = nil
+	if err := solver.CleanUp(newTestChallenge("key")); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if len(requests) == 0 || requests[0].Get(CorrelationIDHeader) == id || requests[0].Get(CorrelationIDHeader) == "" {
+		t.Errorf("expected a new correlation ID for CleanUp, got %v", requests)
+	}
+	requests = nil
+	solver.auditLog = false
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	for _, h := range requests {
+		if got := h.Get(CorrelationIDHeader); got != "" {
+			t.Errorf("expected no correlation ID with the audit log off, got %q", got)
+		}
+	}
+}
+
+func TestCallLinesCarryCorrelationID(t *testing.T) {
+	var flags flag.FlagSet
+	klog.InitFlags(&flags)
+	flags.Set("v", "6")
+	t.Cleanup(func() { flags.Set("v", "0") })
+	var out bytes.Buffer
+	klog.SetLogger(logr.New(newLogfmtSink(&out)))
+	t.Cleanup(klog.ClearLogger)
+
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other"})
+	solver := newTestSolver(gandiClient)
+	solver.auditLog = true
+	solver.resolver = &fakeResolver{values: []string{"key"}}
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "forceReplace": true,
+		"verification": {"enabled": true, "initialInterval": "1ms", "maxInterval": "1ms"}}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	klog.Flush()
+
+	started := regexp.MustCompile(`msg="audit: started" correlationID=([0-9a-f]{16})`).FindSubmatch(out.Bytes())
+	if started == nil {
+		t.Fatalf("no audit start line in %q", out.String())
+	}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		msg := regexp.MustCompile(`msg="(\w+):`).FindStringSubmatch(line)
+		if msg == nil || msg[1] == "audit" {
+			continue
+		}
+		seen[msg[1]] = true
+		if !strings.Contains(line, "correlationID="+string(started[1])) {
+			t.Errorf("expected the correlation ID in %q", line)
+		}
+	}
+	for _, prefix := range []string{"present", "verify"} {
+		if !seen[prefix] {
+			t.Errorf("no %s line in %q", prefix, out.String())
+		}
+	}
+}
+
+func TestPresentPathLinesCarryCorrelationID(t *testing.T) {
+	var flags flag.FlagSet
+	klog.InitFlags(&flags)
+	flags.Set("v", "6")
+	t.Cleanup(func() { flags.Set("v", "0") })
+	var out bytes.Buffer
+	klog.SetLogger(logr.New(newLogfmtSink(&out)))
+	t.Cleanup(klog.ClearLogger)
+
+	gandiClient := newFakeLiveDNS()
+	failures := 1
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return flakyLiveDNS{fakeLiveDNS: gandiClient, mu: &sync.Mutex{}, failures: &failures, err: requestError(503)}
+	}
+	solver.auditLog = true
+	solver.resolver = &fakeResolver{values: []string{"key"}}
+	solver.nsResolver = fakeNSResolver{err: errors.New("SERVFAIL")}
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "metaMarker": {"enabled": true},
+		"retry": {"maxRetries": 1, "initialInterval": "1ms"},
+		"verification": {"enabled": true, "authoritative": true, "initialInterval": "1ms", "maxInterval": "1ms"}}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	klog.Flush()
+
+	started := regexp.MustCompile(`msg="audit: started" correlationID=([0-9a-f]{16})`).FindSubmatch(out.Bytes())
+	if started == nil {
+		t.Fatalf("no audit start line in %q", out.String())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.Contains(line, "correlationID="+string(started[1])) {
+			t.Errorf("expected the correlation ID in %q", line)
+		}
+	}
+	// The retry, verification and marker paths all logged.
+	for _, msg := range []string{`msg="gandi: call failed, retrying"`, `msg="verify: falling back to the system resolver"`, `msg="wrote marker"`} {
+		if !strings.Contains(out.String(), msg) {
+			t.Errorf("no %s line in %q", msg, out.String())
+		}
+	}
+}
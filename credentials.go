@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CredentialsConfigMapEnv names the environment variable holding the
+	// `<namespace>/<name>` of the optional credentials ConfigMap. Routing
+	// credentials by zone is disabled unless it is set.
+	CredentialsConfigMapEnv = "CREDENTIALS_CONFIGMAP"
+
+	credentialsConfigMapResync = 10 * time.Minute
+)
+
+// zoneCredentials is the value stored under each zone suffix key of the
+// credentials ConfigMap, encoded as JSON.
+type zoneCredentials struct {
+	// Namespace of the Secret, defaults to the namespace of the ConfigMap.
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the Secret holding the Personal Access Token.
+	Name string `json:"name"`
+	// Key of the token in the Secret, defaults to DefaultPATSecretKey.
+	Key string `json:"key,omitempty"`
+	// SharingID is the Gandi organization owning the zone, used instead of
+	// the issuer's sharingID, which goes with the issuer's token.
+	SharingID string `json:"sharingID,omitempty"`
+	// AllowedNamespaces are the namespaces, besides the one of the Secret,
+	// whose challenges may use the entry.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// allows reports whether the challenges of namespace may use the entry.
+func (z *zoneCredentials) allows(namespace string) bool {
+	return namespace == z.Namespace || slices.Contains(z.AllowedNamespaces, namespace)
+}
+
+// parseConfigMapRef splits a `<namespace>/<name>` reference read from the
+// environment variable env.
+func parseConfigMapRef(env, ref string) (string, string, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid %s %q, expected <namespace>/<name>", env, ref)
+	}
+	return namespace, name, nil
+}
+
+// matchZoneSuffix returns the key of entries that is the longest suffix of
+// domain on a label boundary, or "" when none matches.
+func matchZoneSuffix[V any](entries map[string]V, domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	best := ""
+	for suffix := range entries {
+		s := strings.ToLower(strings.TrimSuffix(suffix, "."))
+		if s == "" {
+			continue
+		}
+		if domain != s && !strings.HasSuffix(domain, "."+s) {
+			continue
+		}
+		if len(s) > len(strings.TrimSuffix(best, ".")) {
+			best = suffix
+		}
+	}
+	return best
+}
+
+// newCredentialsConfigMapLister starts an informer watching the credentials
+// ConfigMap name of namespace alone, which is all the webhook may read, and
+// waits for it to sync.
+func newCredentialsConfigMapLister(client kubernetes.Interface, namespace, name string, stopCh <-chan struct{}) (corelisters.ConfigMapNamespaceLister, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, credentialsConfigMapResync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps()
+	lister := informer.Lister().ConfigMaps(namespace)
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced) {
+		return nil, fmt.Errorf("unable to sync the credentials configmap informer cache")
+	}
+	return lister, nil
+}
+
+// lookupZoneCredentials reads the credentials ConfigMap, from the informer
+// cache once started, and returns the entry matching domain, or nil when
+// routing is disabled or no entry matches.
+func (c *gandiDNSProviderSolver) lookupZoneCredentials(ctx context.Context, domain string) (*zoneCredentials, error) {
+	if c.credentialsConfigMap == "" {
+		return nil, nil
+	}
+	namespace, name, err := parseConfigMapRef(CredentialsConfigMapEnv, c.credentialsConfigMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var cm *corev1.ConfigMap
+	if c.zoneRoutes != nil {
+		cm, err = c.zoneRoutes.Get(name)
+	} else {
+		cm, err = c.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get credentials configmap `%s/%s`; %v", namespace, name, err)
+	}
+
+	suffix := matchZoneSuffix(cm.Data, domain)
+	if suffix == "" {
+		klog.FromContext(ctx).V(6).Info("no credentials configmap entry matches the domain", "domain", domain)
+		return nil, nil
+	}
+
+	creds := zoneCredentials{}
+	if err := json.Unmarshal([]byte(cm.Data[suffix]), &creds); err != nil {
+		return nil, fmt.Errorf("error decoding credentials configmap entry %q: %v", suffix, err)
+	}
+	if creds.Name == "" {
+		return nil, fmt.Errorf("credentials configmap entry %q has no secret name", suffix)
+	}
+	if creds.Namespace == "" {
+		creds.Namespace = namespace
+	}
+	klog.FromContext(ctx).V(6).Info("using credentials configmap entry", "entry", suffix, "domain", domain)
+	return &creds, nil
+}
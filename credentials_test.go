@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/go-gandi/go-gandi/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseConfigMapRef(t *testing.T) {
+	namespace, name, err := parseConfigMapRef(CredentialsConfigMapEnv, "cert-manager/gandi-zones")
+	if err != nil || namespace != "cert-manager" || name != "gandi-zones" {
+		t.Errorf("parseConfigMapRef = %q, %q, %v", namespace, name, err)
+	}
+	for _, ref := range []string{"", "gandi-zones", "/gandi-zones", "cert-manager/", "a/b/c"} {
+		if _, _, err := parseConfigMapRef(CredentialsConfigMapEnv, ref); err == nil {
+			t.Errorf("expected an error for %q", ref)
+		}
+	}
+}
+
+func TestMatchZoneSuffix(t *testing.T) {
+	entries := map[string]string{
+		"example.com":     "a",
+		"sub.example.com": "b",
+		"other.org.":      "c",
+	}
+	cases := map[string]string{
+		"example.com":          "example.com",
+		"www.example.com":      "example.com",
+		"sub.example.com.":     "sub.example.com",
+		"deep.sub.example.com": "sub.example.com",
+		"other.org":            "other.org.",
+		"notexample.com":       "",
+		"example.net":          "",
+	}
+	for domain, want := range cases {
+		if got := matchZoneSuffix(entries, domain); got != want {
+			t.Errorf("matchZoneSuffix(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
+
+func TestGetGandiClientCredentialsConfigMap(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-zones", Namespace: testNamespace},
+		Data: map[string]string{
+			"example.com": `{"namespace": "tenant-a", "name": "tenant-a-gandi", "key": "token", "allowedNamespaces": ["` + testNamespace + `"]}`,
+			"example.org": `{"namespace": "tenant-b", "name": "tenant-b-gandi"}`,
+		},
+	}
+	tenantSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-a-gandi", Namespace: "tenant-a"},
+		Data:       map[string][]byte{"token": []byte("tenant-a-token")},
+	}
+
+	var used config.Config
+	solver := newTestSolver(newFakeLiveDNS(), cm, tenantSecret)
+	solver.credentialsConfigMap = testNamespace + "/gandi-zones"
+	solver.newLiveDNSClient = captureConfig(&used, newFakeLiveDNS())
+
+	cfg := gandiDNSProviderConfig{}
+	cfg.PATSecretRef.Name = "gandi-credentials"
+
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "www.example.com", config.Timeout); err != nil {
+		t.Fatal(err)
+	}
+	if used.PersonalAccessToken != "tenant-a-token" {
+		t.Errorf("expected the mapped secret to be used, got token %q", used.PersonalAccessToken)
+	}
+
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.net", config.Timeout); err != nil {
+		t.Fatal(err)
+	}
+	if used.PersonalAccessToken != testToken {
+		t.Errorf("expected the issuer secret for unmapped zones, got token %q", used.PersonalAccessToken)
+	}
+
+	// The Secret of another namespace is not for the challenges of this one.
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.org", config.Timeout); err == nil {
+		t.Error("expected an entry of another namespace to be rejected")
+	}
+}
+
+func TestCredentialsConfigMapInformer(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-zones", Namespace: testNamespace},
+		Data:       map[string]string{"example.com": `{"name": "gandi-credentials", "sharingID": "org"}`},
+	}
+	other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: testNamespace}}
+	solver := newTestSolver(newFakeLiveDNS(), cm, other)
+	solver.credentialsConfigMap = testNamespace + "/gandi-zones"
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	lister, err := newCredentialsConfigMapLister(solver.client, testNamespace, "gandi-zones", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	solver.zoneRoutes = lister
+	clientset := solver.client.(*fake.Clientset)
+	clientset.ClearActions()
+
+	for i := 0; i < 3; i++ {
+		creds, err := solver.lookupZoneCredentials(context.Background(), "www.example.com")
+		if err != nil || creds == nil || creds.SharingID != "org" {
+			t.Fatalf("lookupZoneCredentials = %+v, %v", creds, err)
+		}
+	}
+	for _, a := range clientset.Actions() {
+		if a.GetResource().Resource == "configmaps" {
+			t.Errorf("expected the configmap to be served from the cache, got %s", a.GetVerb())
+		}
+	}
+}
+
+func TestGetGandiClientSharingIDResolution(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-zones", Namespace: testNamespace},
+		Data: map[string]string{
+			"example.com":     `{"name": "gandi-credentials", "sharingID": "org-parent"}`,
+			"sub.example.com": `{"name": "gandi-credentials", "sharingID": "org-child"}`,
+			"example.org":     `{"name": "gandi-credentials"}`,
+		},
+	}
+
+	var used config.Config
+	solver := newTestSolver(newFakeLiveDNS(), cm)
+	solver.credentialsConfigMap = testNamespace + "/gandi-zones"
+	solver.newLiveDNSClient = captureConfig(&used, newFakeLiveDNS())
+
+	cfg := gandiDNSProviderConfig{SharingID: "org-issuer"}
+	cfg.PATSecretRef.Name = "gandi-credentials"
+
+	cases := []struct {
+		domain string
+		want   string
+	}{
+		// the longest zone suffix wins over its parent
+		{"www.sub.example.com", "org-child"},
+		{"www.example.com", "org-parent"},
+		// an entry without a sharing ID does not take the issuer's, which
+		// goes with the issuer's token
+		{"example.org", ""},
+		// unmapped zones use the issuer's
+		{"example.net", "org-issuer"},
+	}
+	for _, tc := range cases {
+		if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, tc.domain, config.Timeout); err != nil {
+			t.Fatalf("%s: %v", tc.domain, err)
+		}
+		if used.SharingID != tc.want {
+			t.Errorf("%s: sharing ID = %q, want %q", tc.domain, used.SharingID, tc.want)
+		}
+	}
+}
+
+func TestCustomCredentialProvider(t *testing.T) {
+	var used config.Config
+	gandiClient := newFakeLiveDNS()
+	// No Secret exists: the credentials can only come from the provider.
+	solver := &gandiDNSProviderSolver{
+		client:           fake.NewSimpleClientset(),
+		credentials:      staticCredentialProvider{PAT: "exchanged-token", SharingID: "org"},
+		newLiveDNSClient: captureConfig(&used, gandiClient),
+	}
+
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if used.PersonalAccessToken != "exchanged-token" || used.SharingID != "org" {
+		t.Errorf("expected the provider credentials to be used, got %+v", used)
+	}
+}
+
+func TestNilConfigUsesGlobalCredentials(t *testing.T) {
+	// The token of the self-test does not serve challenges.
+	t.Setenv(SelfTestPATEnv, "self-test-token")
+	if creds := globalCredentialsFromEnv(); creds != nil {
+		t.Errorf("expected no global credentials from %s, got %+v", SelfTestPATEnv, creds)
+	}
+
+	t.Setenv(GlobalPATEnv, "global-token")
+	t.Setenv(GlobalSharingIDEnv, "org")
+
+	var used config.Config
+	gandiClient := newFakeLiveDNS()
+	// No Secret exists: the credentials can only come from the environment.
+	solver := &gandiDNSProviderSolver{
+		client:            fake.NewSimpleClientset(),
+		globalCredentials: globalCredentialsFromEnv(),
+		newLiveDNSClient:  captureConfig(&used, gandiClient),
+	}
+
+	ch := newTestChallenge("key")
+	ch.Config = nil
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if used.PersonalAccessToken != "global-token" || used.SharingID != "org" {
+		t.Errorf("expected the global credentials to be used, got %+v", used)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 || got[0] != "key" {
+		t.Errorf("expected the challenge to be presented, got %v", gandiClient.records)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+
+	// A config still takes precedence over the global credentials.
+	if err := solver.Present(newTestChallenge("key")); err == nil {
+		t.Error("expected the missing Secret of the config to be an error")
+	}
+
+	solver.globalCredentials = nil
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected a nil config without global credentials to be an error")
+	}
+}
+
+// mapSecretReader is a secretReader serving tokens from memory, keyed by
+// namespace, Secret name and key.
+type mapSecretReader map[string]string
+
+func (m mapSecretReader) GetToken(_ context.Context, namespace string, ref cmmeta.SecretKeySelector) (string, error) {
+	token, ok := m[namespace+"/"+ref.Name+"/"+ref.Key]
+	if !ok {
+		return "", fmt.Errorf("no token for %s/%s/%s", namespace, ref.Name, ref.Key)
+	}
+	return token, nil
+}
+
+func TestCustomSecretReader(t *testing.T) {
+	var used config.Config
+	// No Secret exists: the token can only come from the reader.
+	solver := &gandiDNSProviderSolver{
+		client:           fake.NewSimpleClientset(),
+		tokens:           mapSecretReader{testNamespace + "/gandi-credentials/" + DefaultPATSecretKey: "vault-token"},
+		newLiveDNSClient: captureConfig(&used, newFakeLiveDNS()),
+	}
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if used.PersonalAccessToken != "vault-token" {
+		t.Errorf("expected the reader token to be used, got %q", used.PersonalAccessToken)
+	}
+
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "other-credentials"}}`)
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected the error of the reader to be returned")
+	}
+}
+
+// failingCredentialProvider fails every lookup with err.
+type failingCredentialProvider struct{ err error }
+
+func (p failingCredentialProvider) Credentials(context.Context, gandiDNSProviderConfig, string, string) (gandiCredentials, error) {
+	return gandiCredentials{}, p.err
+}
+
+func TestCredentialErrorsStayInChain(t *testing.T) {
+	errExchange := errors.New("token exchange refused")
+	solver := &gandiDNSProviderSolver{
+		client:      fake.NewSimpleClientset(),
+		credentials: failingCredentialProvider{err: errExchange},
+	}
+	for name, err := range map[string]error{
+		"present": solver.Present(newTestChallenge("key")),
+		"cleanup": solver.CleanUp(newTestChallenge("key")),
+	} {
+		if !errors.Is(err, errExchange) || !strings.HasPrefix(err.Error(), name+": ") {
+			t.Errorf("%s: expected the credential error wrapped under %q, got %v", name, name+":", err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// ResolverProtocolTLS sends the verification lookups over DNS-over-TLS.
+	ResolverProtocolTLS = "tls"
+	// ResolverProtocolHTTPS sends the verification lookups over
+	// DNS-over-HTTPS.
+	ResolverProtocolHTTPS = "https"
+	// defaultDoTPort is the port of a DNS-over-TLS endpoint without one.
+	defaultDoTPort = "853"
+	// dohMessageType is the media type of DNS-over-HTTPS messages.
+	dohMessageType = "application/dns-message"
+	// maxDoHResponseSize bounds the DNS-over-HTTPS answers read.
+	maxDoHResponseSize = 64 * 1024
+)
+
+// resolverConfig is the `resolver` block of a verification config. It
+// replaces the system resolver of the verification, for networks where only
+// encrypted DNS may leave the cluster.
+type resolverConfig struct {
+	// Protocol is ResolverProtocolTLS or ResolverProtocolHTTPS.
+	Protocol string `json:"protocol"`
+	// Endpoint is the `host[:port]` of a DNS-over-TLS server, the port
+	// defaulting to 853, or the https URL of a DNS-over-HTTPS server.
+	Endpoint string `json:"endpoint"`
+	// ServerName is the name checked against the certificate of a
+	// DNS-over-TLS server, defaults to the host of Endpoint.
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// validate rejects resolver configs that cannot be used.
+func (r resolverConfig) validate() error {
+	switch r.Protocol {
+	case ResolverProtocolTLS:
+		if _, _, err := r.dotAddress(); err != nil {
+			return err
+		}
+	case ResolverProtocolHTTPS:
+		u, err := url.Parse(r.Endpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid verification resolver endpoint %q: expected an https URL", r.Endpoint)
+		}
+		if r.ServerName != "" {
+			return fmt.Errorf("verification resolver serverName only applies to the %q protocol", ResolverProtocolTLS)
+		}
+	default:
+		return fmt.Errorf("invalid verification resolver protocol %q: must be %q or %q", r.Protocol, ResolverProtocolTLS, ResolverProtocolHTTPS)
+	}
+	return nil
+}
+
+// dotAddress returns the address to dial and the TLS server name of a
+// DNS-over-TLS endpoint.
+func (r resolverConfig) dotAddress() (address, serverName string, err error) {
+	if r.Endpoint == "" || strings.Contains(r.Endpoint, "/") {
+		return "", "", fmt.Errorf("invalid verification resolver endpoint %q: expected host[:port]", r.Endpoint)
+	}
+	host, port, err := net.SplitHostPort(r.Endpoint)
+	if err != nil {
+		host, port = strings.Trim(r.Endpoint, "[]"), defaultDoTPort
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("invalid verification resolver endpoint %q: expected host[:port]", r.Endpoint)
+	}
+	serverName = r.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	return net.JoinHostPort(host, port), serverName, nil
+}
+
+// txtResolver returns the resolver described by r. tlsConfig is the base
+// TLS config of the connections, nil for the system roots.
+func (r resolverConfig) txtResolver(tlsConfig *tls.Config) txtResolver {
+	if r.Protocol == ResolverProtocolHTTPS {
+		transport := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ForceAttemptHTTP2:   true,
+		}
+		return dohResolver{endpoint: r.Endpoint, client: &http.Client{Transport: transport}}
+	}
+	address, serverName, _ := r.dotAddress()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = serverName
+	return dotResolver(address, tlsConfig)
+}
+
+// dotResolver returns a resolver sending every query to the DNS-over-TLS
+// server at address. The Go resolver frames its queries as over TCP on
+// connections that are not packet oriented, which is what DNS-over-TLS
+// expects.
+func dotResolver(address string, tlsConfig *tls.Config) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			d := tls.Dialer{Config: tlsConfig}
+			return d.DialContext(ctx, "tcp", address)
+		},
+	}
+}
+
+// dohResolver looks TXT records up with DNS-over-HTTPS POST requests, as
+// described by RFC 8484.
+type dohResolver struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (r dohResolver) LookupTXT(ctx context.Context, fqdn string) ([]string, error) {
+	name, err := dnsmessage.NewName(absoluteName(fqdn))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: fqdn}
+	}
+	// The ID is zero as recommended for DNS-over-HTTPS, letting caches
+	// share the answers.
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+	}
+	body, err := query.Pack()
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: fqdn}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMessageType)
+	req.Header.Set("Accept", dohMessageType)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: fqdn, Server: r.endpoint, IsTimeout: ctx.Err() != nil}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &net.DNSError{Err: fmt.Sprintf("unexpected HTTP status %s", resp.Status), Name: fqdn, Server: r.endpoint}
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseSize))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: fqdn, Server: r.endpoint}
+	}
+	return parseTXTAnswer(raw, fqdn, r.endpoint)
+}
+
+// absoluteName returns fqdn with its trailing dot, as DNS messages expect.
+func absoluteName(fqdn string) string {
+	if strings.HasSuffix(fqdn, ".") {
+		return fqdn
+	}
+	return fqdn + "."
+}
+
+// parseTXTAnswer returns the TXT values of the DNS answer raw, the strings
+// of each record joined as by net.Resolver. Errors are *net.DNSError, with
+// the same flags as those of net.Resolver, so that verification tells
+// NXDOMAIN and SERVFAIL apart the same way.
+func parseTXTAnswer(raw []byte, fqdn, server string) ([]string, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(raw)
+	if err != nil {
+		return nil, &net.DNSError{Err: fmt.Sprintf("cannot parse answer: %v", err), Name: fqdn, Server: server}
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: fqdn, Server: server, IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return nil, &net.DNSError{Err: "server misbehaving", Name: fqdn, Server: server, IsTemporary: true}
+	default:
+		return nil, &net.DNSError{Err: fmt.Sprintf("unexpected response code %v", header.RCode), Name: fqdn, Server: server}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, &net.DNSError{Err: fmt.Sprintf("cannot parse answer: %v", err), Name: fqdn, Server: server}
+	}
+
+	var values []string
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, &net.DNSError{Err: fmt.Sprintf("cannot parse answer: %v", err), Name: fqdn, Server: server}
+		}
+		if h.Type != dnsmessage.TypeTXT || h.Class != dnsmessage.ClassINET {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, &net.DNSError{Err: fmt.Sprintf("cannot parse answer: %v", err), Name: fqdn, Server: server}
+			}
+			continue
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			return nil, &net.DNSError{Err: fmt.Sprintf("cannot parse answer: %v", err), Name: fqdn, Server: server}
+		}
+		values = append(values, strings.Join(txt.TXT, ""))
+	}
+	if len(values) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: fqdn, Server: server, IsNotFound: true}
+	}
+	return values, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// txtAnswer answers the DNS query with rcode and a TXT record per value.
+func txtAnswer(t *testing.T, query []byte, rcode dnsmessage.RCode, values ...string) []byte {
+	t.Helper()
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		t.Errorf("unable to parse query: %v", err)
+		return nil
+	}
+	answer := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, RCode: rcode},
+		Questions: q.Questions,
+	}
+	for _, v := range values {
+		answer.Answers = append(answer.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 300},
+			Body:   &dnsmessage.TXTResource{TXT: []string{v}},
+		})
+	}
+	raw, err := answer.Pack()
+	if err != nil {
+		t.Errorf("unable to pack answer: %v", err)
+	}
+	return raw
+}
+
+func TestResolverConfigValidate(t *testing.T) {
+	valid := []resolverConfig{
+		{Protocol: ResolverProtocolTLS, Endpoint: "dns.example.net"},
+		{Protocol: ResolverProtocolTLS, Endpoint: "192.0.2.1:8853", ServerName: "dns.example.net"},
+		{Protocol: ResolverProtocolTLS, Endpoint: "[2001:db8::1]"},
+		{Protocol: ResolverProtocolHTTPS, Endpoint: "https://dns.example.net/dns-query"},
+	}
+	for _, r := range valid {
+		if err := r.validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", r, err)
+		}
+	}
+	invalid := []resolverConfig{
+		{Protocol: "udp", Endpoint: "192.0.2.1"},
+		{Protocol: ResolverProtocolTLS},
+		{Protocol: ResolverProtocolTLS, Endpoint: "https://dns.example.net/dns-query"},
+		{Protocol: ResolverProtocolHTTPS, Endpoint: "http://dns.example.net/dns-query"},
+		{Protocol: ResolverProtocolHTTPS, Endpoint: "https://dns.example.net/dns-query", ServerName: "dns.example.net"},
+	}
+	for _, r := range invalid {
+		if err := r.validate(); err == nil {
+			t.Errorf("%+v: expected an error", r)
+		}
+	}
+
+	v := verificationConfig{Resolver: &resolverConfig{Protocol: "quic", Endpoint: "dns.example.net"}}
+	if err := v.validate(); err == nil {
+		t.Error("expected the resolver to be validated with the verification config")
+	}
+}
+
+func TestDoHResolver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMessageType {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if err := q.Unpack(query); err != nil || len(q.Questions) != 1 {
+			http.Error(w, "unexpected query", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dohMessageType)
+		switch q.Questions[0].Name.String() {
+		case "_acme-challenge.example.com.":
+			w.Write(txtAnswer(t, query, dnsmessage.RCodeSuccess, "key-1", "key-2"))
+		case "_acme-challenge.broken.example.com.":
+			w.Write(txtAnswer(t, query, dnsmessage.RCodeServerFailure))
+		default:
+			w.Write(txtAnswer(t, query, dnsmessage.RCodeNameError))
+		}
+	}))
+	defer srv.Close()
+
+	resolver := dohResolver{endpoint: srv.URL + "/dns-query", client: srv.Client()}
+	values, err := resolver.LookupTXT(context.Background(), "_acme-challenge.example.com")
+	if err != nil {
+		t.Fatalf("LookupTXT: %v", err)
+	}
+	if len(values) != 2 || values[0] != "key-1" || values[1] != "key-2" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if _, err := resolver.LookupTXT(context.Background(), "_acme-challenge.missing.example.com."); !isNXDomain(err) {
+		t.Errorf("expected NXDOMAIN, got %v", err)
+	}
+	if _, err := resolver.LookupTXT(context.Background(), "_acme-challenge.broken.example.com."); !isServfail(err) {
+		t.Errorf("expected SERVFAIL, got %v", err)
+	}
+}
+
+func TestDoTResolver(t *testing.T) {
+	// The httptest server only provides a certificate valid for 127.0.0.1.
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certSrv.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var size uint16
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					query := make([]byte, size)
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					answer := txtAnswer(t, query, dnsmessage.RCodeSuccess, "key")
+					binary.Write(conn, binary.BigEndian, uint16(len(answer)))
+					conn.Write(answer)
+				}
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certSrv.Certificate())
+	r := resolverConfig{Protocol: ResolverProtocolTLS, Endpoint: ln.Addr().String()}
+	values, err := r.txtResolver(&tls.Config{RootCAs: roots}).LookupTXT(context.Background(), "_acme-challenge.example.com.")
+	if err != nil {
+		t.Fatalf("LookupTXT: %v", err)
+	}
+	if len(values) != 1 || values[0] != "key" {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	// An unverifiable certificate fails the lookup.
+	if _, err := r.txtResolver(nil).LookupTXT(context.Background(), "_acme-challenge.example.com."); err == nil {
+		t.Error("expected the untrusted certificate to be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envInt returns the integer value of the environment variable name, or def
+// when it is unset or empty.
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, v, err)
+	}
+	return i, nil
+}
+
+// envFloat returns the float value of the environment variable name, or def
+// when it is unset or empty.
+func envFloat(name string, def float64) (float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, v, err)
+	}
+	return f, nil
+}
+
+// envDuration returns the duration value of the environment variable name,
+// or def when it is unset or empty.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, v, err)
+	}
+	return d, nil
+}
+
+// envBool returns the boolean value of the environment variable name, or
+// def when it is unset or empty.
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %v", name, v, err)
+	}
+	return b, nil
+}
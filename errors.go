@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-gandi/go-gandi/types"
+)
+
+var (
+	// errDomainNotFound is a permanent misconfiguration: the zone does not
+	// exist at Gandi, or is not visible to the token.
+	errDomainNotFound = errors.New("domain not found")
+	// errRecordNotFound is expected when reading a record before creating
+	// it or after removing it.
+	errRecordNotFound = errors.New("record not found")
+	// errOperationTimeout is returned once the operationTimeout of a Present
+	// or CleanUp has elapsed, whatever the call it interrupted.
+	errOperationTimeout = errors.New("the operation deadline was exceeded")
+	// errZoneReadOnly is returned while Gandi runs an operation on the zone,
+	// e.g. a DNSSEC key rollover or a nameserver change, during which its
+	// records cannot be written. It goes away by itself.
+	errZoneReadOnly = errors.New("the zone is temporarily read-only")
+	// errRrsetExists is a create refused because the rrset appeared since
+	// it was read, e.g. written by another replica.
+	errRrsetExists = errors.New("the record already exists")
+	// errRrsetChanged is returned by checkUnchanged when another writer
+	// changed the rrset since it was read.
+	errRrsetChanged = errors.New("the record was changed by another writer")
+)
+
+// gandiError is a classified error returned by the Gandi API.
+type gandiError struct {
+	// StatusCode is the HTTP status code of the response, 0 if unknown.
+	StatusCode int
+	// Retriable tells whether repeating the same call may succeed.
+	Retriable bool
+	// kind is a sentinel the error also matches, if any.
+	kind error
+	msg  string
+	err  error
+}
+
+func (e *gandiError) Error() string {
+	if e.msg == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.msg, e.err)
+}
+
+func (e *gandiError) Unwrap() []error {
+	if e.kind == nil {
+		return []error{e.err}
+	}
+	return []error{e.err, e.kind}
+}
+
+// classifyGandiError wraps an error returned by go-gandi into a *gandiError
+// carrying a user-facing explanation and whether the call may be retried.
+// It returns nil for a nil error.
+func classifyGandiError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ge *gandiError
+	if errors.As(err, &ge) {
+		return err
+	}
+
+	var reqErr *types.RequestError
+	if !errors.As(err, &reqErr) {
+		// Transport failures (timeouts, connection resets) never reached the
+		// API, so trying again is reasonable.
+		return &gandiError{Retriable: true, err: err}
+	}
+
+	switch code := reqErr.StatusCode; {
+	case code == http.StatusUnauthorized:
+		return &gandiError{StatusCode: code, err: err,
+			msg: "the Personal Access Token was rejected by Gandi, check that it is valid and not expired"}
+	case code == http.StatusForbidden:
+		return &gandiError{StatusCode: code, err: err,
+			msg: "the Personal Access Token lacks the required LiveDNS permissions, it needs \"Manage domain name technical configurations\" on this domain"}
+	case code == http.StatusNotFound:
+		return &gandiError{StatusCode: code, kind: errRecordNotFound, err: err}
+	case isZoneReadOnly(reqErr):
+		return &gandiError{StatusCode: code, Retriable: true, kind: errZoneReadOnly, err: err,
+			msg: "the zone is temporarily read-only at Gandi, which happens during operations on the domain, the change will succeed once it is over"}
+	case code == http.StatusConflict:
+		return &gandiError{StatusCode: code, kind: errRrsetExists, err: err}
+	case code == http.StatusTooManyRequests, code >= http.StatusInternalServerError:
+		return &gandiError{StatusCode: code, Retriable: true, err: err}
+	default:
+		return &gandiError{StatusCode: code, err: err}
+	}
+}
+
+// domainNotFoundError returns the error of a read of the zone domain that
+// Gandi answered with a 404. A 404 on a record does not tell whether the
+// record or its zone is missing, so only the zone itself is asked about.
+func domainNotFoundError(err error) error {
+	return &gandiError{StatusCode: http.StatusNotFound, kind: errDomainNotFound, err: err,
+		msg: "the zone is not known to Gandi LiveDNS for this token, check the zone name, the sharingID and that the domain uses LiveDNS"}
+}
+
+// isZoneReadOnly tells whether Gandi refused a write because the zone is
+// locked for the time of another operation, which it answers with a 423.
+// Other statuses are not taken as such whatever their message says.
+func isZoneReadOnly(reqErr *types.RequestError) bool {
+	return reqErr.StatusCode == http.StatusLocked
+}
+
+// responseError inspects a response go-gandi returned together with a nil
+// error. go-gandi already turns non-2xx statuses into errors, and successful
+// LiveDNS responses usually carry no code at all, so the response is only
+// considered a failure when it explicitly says so: an error status code or a
+// list of errors.
+func responseError(resp types.StandardResponse) error {
+	if resp.Code >= http.StatusBadRequest {
+		if resp.Message != "" {
+			return fmt.Errorf("got code %d: %s", resp.Code, resp.Message)
+		}
+		return fmt.Errorf("got code %d", resp.Code)
+	}
+	if len(resp.Errors) > 0 {
+		e := resp.Errors[0]
+		return fmt.Errorf("got %d errors, first: %s: %s", len(resp.Errors), e.Name, e.Description)
+	}
+	return nil
+}
+
+// isRetriable reports whether err is a Gandi error worth retrying.
+func isRetriable(err error) bool {
+	var ge *gandiError
+	return errors.As(classifyGandiError(err), &ge) && ge.Retriable
+}
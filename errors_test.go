@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+func requestError(code int) error {
+	return &types.RequestError{StatusCode: code, Err: fmt.Errorf("%d: error", code)}
+}
+
+func TestClassifyGandiError(t *testing.T) {
+	if classifyGandiError(nil) != nil {
+		t.Error("nil should stay nil")
+	}
+
+	cases := []struct {
+		err       error
+		retriable bool
+	}{
+		{requestError(400), false},
+		{requestError(401), false},
+		{requestError(403), false},
+		{requestError(404), false},
+		{requestError(429), true},
+		{requestError(500), true},
+		{requestError(503), true},
+		{errors.New("Fail to do the request (error 'timeout')"), true},
+	}
+	for _, tc := range cases {
+		if got := isRetriable(tc.err); got != tc.retriable {
+			t.Errorf("isRetriable(%v) = %v, want %v", tc.err, got, tc.retriable)
+		}
+	}
+}
+
+func TestPresentForbiddenUpdate(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other"})
+	gandiClient.failWith("update", requestError(403))
+	solver := newTestSolver(gandiClient)
+
+	err := solver.Present(newTestChallenge("key"))
+	if err == nil {
+		t.Fatal("expected Present to fail")
+	}
+	if !strings.Contains(err.Error(), "lacks the required LiveDNS permissions") {
+		t.Errorf("error should explain the missing permissions, got: %v", err)
+	}
+	if isRetriable(err) {
+		t.Error("a 403 must not be retriable")
+	}
+	if n := gandiClient.countCalls("update"); n != 1 {
+		t.Errorf("expected a single update attempt, got %d", n)
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	success := []types.StandardResponse{
+		{},
+		{Message: "DNS Record Created"},
+		{Code: 200},
+		{Code: 201, Message: "DNS Record Created"},
+	}
+	for _, resp := range success {
+		if err := responseError(resp); err != nil {
+			t.Errorf("responseError(%+v): unexpected error %v", resp, err)
+		}
+	}
+
+	failure := []types.StandardResponse{
+		{Code: 400, Message: "Bad request"},
+		{Code: 500},
+		{Errors: []types.StandardError{{Name: "rrset_values", Description: "invalid"}}},
+	}
+	for _, resp := range failure {
+		if err := responseError(resp); err == nil {
+			t.Errorf("responseError(%+v): expected an error", resp)
+		}
+	}
+}
+
+// Gandi answers a read of a missing record and a read of a missing zone
+// with these bodies, both with a 404.
+const (
+	gandiRecordNotFoundBody = `{"code": 404, "message": "Can't find the DNS record", "object": "dns-record", "cause": "Not Found"}`
+	gandiDomainNotFoundBody = `{"code": 404, "message": "The resource could not be found.", "object": "HTTPNotFound", "cause": "Not Found"}`
+)
+
+func TestClassifyNotFound(t *testing.T) {
+	// A 404 alone does not tell a missing zone from a missing record.
+	recordErr := &types.RequestError{StatusCode: 404, Err: errors.New("404: Can't find the DNS record")}
+	for _, err := range []error{recordErr, notFoundError()} {
+		if got := classifyGandiError(err); !errors.Is(got, errRecordNotFound) || errors.Is(got, errDomainNotFound) {
+			t.Errorf("expected a record not found error, got %v", got)
+		}
+	}
+	if !errors.As(domainNotFoundError(recordErr), new(*types.RequestError)) {
+		t.Error("the go-gandi error should still be reachable")
+	}
+
+	// A missing record is created, a missing zone fails the challenge.
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present with a missing record: %v", err)
+	}
+	if got := gandiClient.countCalls("domain"); got != 0 {
+		t.Errorf("expected the zone not to be read when the create succeeds, got %d reads", got)
+	}
+	gandiClient.failWith("get", recordErr)
+	gandiClient.failWith("create", recordErr)
+	gandiClient.failWith("domain", notFoundError())
+	err := solver.Present(newTestChallenge("other"))
+	if !errors.Is(err, errDomainNotFound) || !strings.Contains(err.Error(), "not known to Gandi LiveDNS") {
+		t.Errorf("expected Present to fail on a missing zone, got %v", err)
+	}
+	if isRetriable(err) {
+		t.Error("a missing zone must not be retriable")
+	}
+
+	// CleanUp has nothing to write, hence no reason to read the zone.
+	reads := gandiClient.countCalls("domain")
+	if err := solver.CleanUp(newTestChallenge("key")); err != nil {
+		t.Errorf("expected CleanUp to find nothing to remove, got %v", err)
+	}
+	if got := gandiClient.countCalls("domain"); got != reads {
+		t.Errorf("expected CleanUp not to read the zone, got %d reads", got-reads)
+	}
+}
+
+func TestDomainNotFoundResponses(t *testing.T) {
+	domainExists := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/records/"):
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, gandiRecordNotFoundBody)
+		case r.Method == http.MethodGet && domainExists:
+			fmt.Fprint(w, `{"fqdn": "example.com"}`)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, gandiDomainNotFoundBody)
+		case !domainExists:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, gandiDomainNotFoundBody)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"message": "DNS Record Created"}`)
+		}
+	}))
+	defer server.Close()
+
+	// The real Gandi client, talking to server.
+	solver := newTestSolver(newFakeLiveDNS())
+	solver.newLiveDNSClient = nil
+	solver.apiURL = server.URL
+	if err := solver.Present(newTestChallenge("key")); !errors.Is(err, errDomainNotFound) {
+		t.Errorf("expected Present to fail on a missing zone, got %v", err)
+	}
+	domainExists = true
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Errorf("expected a missing record to be created: %v", err)
+	}
+}
+
+func TestPresentOnceZoneCreated(t *testing.T) {
+	// An account without LiveDNS domains answers every call about the zone
+	// with a domain not found error.
+	gandiClient := newFakeLiveDNS()
+	gandiClient.failWith("get", notFoundError())
+	gandiClient.failWith("create", notFoundError())
+	gandiClient.failWith("domain", notFoundError())
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "recordNameForm": "auto"}`)
+	if err := solver.Present(ch); !errors.Is(err, errDomainNotFound) {
+		t.Fatalf("expected Present to fail before the zone exists, got %v", err)
+	}
+
+	// Nothing about the missing zone is remembered.
+	gandiClient.failWith("get", nil)
+	gandiClient.failWith("create", nil)
+	gandiClient.failWith("domain", nil)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("expected Present to work once the zone is created: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("expected the challenge record in the new zone, got %v", got)
+	}
+}
+
+// emptyResponseLiveDNS acknowledges writes with an empty response and no
+// error, the closest go-gandi, which returns responses by value, comes to a
+// nil response.
+type emptyResponseLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (f emptyResponseLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	_, err := f.fakeLiveDNS.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	return types.StandardResponse{}, err
+}
+
+func (f emptyResponseLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	_, err := f.fakeLiveDNS.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+	return types.StandardResponse{}, err
+}
+
+func TestEmptyResponseIsSuccess(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient { return emptyResponseLiveDNS{gandiClient} }
+
+	for _, key := range []string{"key-1", "key-2"} {
+		if err := solver.Present(newTestChallenge(key)); err != nil {
+			t.Fatalf("Present(%s): %v", key, err)
+		}
+	}
+	if err := solver.CleanUp(newTestChallenge("key-1")); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key-2" {
+		t.Errorf("unexpected rrset values %v", got)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// apiHTTPClient sends the requests of every apiLiveDNS. It has no transport
+// of its own, so that it uses http.DefaultTransport as wrapped at startup,
+// and no timeout, each request carrying its own.
+var apiHTTPClient = &http.Client{}
+
+// apiLiveDNS is the liveDNSClient talking to the Gandi LiveDNS API. It sends
+// the requests go-gandi would, with its types and errors, but with the
+// context of the call: requests are canceled with it and carry its
+// correlation ID, which go-gandi offers no way to do.
+type apiLiveDNS struct {
+	cfg config.Config
+	ctx context.Context
+}
+
+// newGandiLiveDNSClient is the default liveDNSClient constructor, for calls
+// without a context.
+func newGandiLiveDNSClient(cfg config.Config) liveDNSClient {
+	return newAPILiveDNS(context.Background(), cfg)
+}
+
+// newAPILiveDNS returns the client of the call of ctx using cfg, whose
+// APIURL and Timeout default as in go-gandi.
+func newAPILiveDNS(ctx context.Context, cfg config.Config) apiLiveDNS {
+	if cfg.APIURL == "" {
+		cfg.APIURL = config.APIURL
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = config.Timeout
+	}
+	return apiLiveDNS{cfg: cfg, ctx: ctx}
+}
+
+// do sends a request with body, if any, to path under the LiveDNS API and
+// decodes the answer into recipient, if any. Statuses other than 2xx are
+// returned as a *types.RequestError, as go-gandi does.
+func (a apiLiveDNS) do(method, path string, body, recipient any) error {
+	ctx, cancel := context.WithTimeout(a.ctx, a.cfg.Timeout)
+	defer cancel()
+
+	u := strings.TrimSuffix(a.cfg.APIURL, "/") + "/v5/livedns/" + path
+	if a.cfg.SharingID != "" {
+		u += "?sharing_id=" + url.QueryEscape(a.cfg.SharingID)
+	}
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("unable to encode the request: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return fmt.Errorf("unable to create the request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.cfg.PersonalAccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	if id := correlationID(a.ctx); id != "" {
+		req.Header.Set(CorrelationIDHeader, id)
+		req.Header.Set("User-Agent", userAgent+" correlation-id/"+id)
+	}
+
+	resp, err := apiHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to send the request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read the response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &types.RequestError{StatusCode: resp.StatusCode, Err: responseBodyError(resp, data)}
+	}
+	if recipient == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.Unmarshal(data, recipient)
+}
+
+// responseBodyError returns the error described by the body of a failed
+// response, formatted as go-gandi does.
+func responseBodyError(resp *http.Response, data []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var message types.StandardResponse
+	if mediaType != "application/json" || json.Unmarshal(data, &message) != nil {
+		return fmt.Errorf("%d", resp.StatusCode)
+	}
+	if message.Message != "" {
+		return fmt.Errorf("%d: %s", resp.StatusCode, message.Message)
+	}
+	if len(message.Errors) > 0 {
+		errs := make([]string, 0, len(message.Errors))
+		for _, e := range message.Errors {
+			errs = append(errs, e.Name+": "+e.Description)
+		}
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return fmt.Errorf("%d", resp.StatusCode)
+}
+
+func recordPath(fqdn, name, recordtype string) string {
+	return "domains/" + fqdn + "/records/" + name + "/" + recordtype
+}
+
+func (a apiLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (record livedns.DomainRecord, err error) {
+	err = a.do(http.MethodGet, recordPath(fqdn, name, recordtype), nil, &record)
+	return record, err
+}
+
+func (a apiLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	err = a.do(http.MethodPost, "domains/"+fqdn+"/records", livedns.DomainRecord{
+		RrsetType: recordtype, RrsetTTL: ttl, RrsetName: name, RrsetValues: values,
+	}, &resp)
+	return resp, err
+}
+
+func (a apiLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	err = a.do(http.MethodPut, recordPath(fqdn, name, recordtype), livedns.DomainRecord{
+		RrsetType: recordtype, RrsetTTL: ttl, RrsetValues: values,
+	}, &resp)
+	return resp, err
+}
+
+func (a apiLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	return a.do(http.MethodDelete, recordPath(fqdn, name, recordtype), nil, nil)
+}
+
+func (a apiLiveDNS) GetDomain(fqdn string) (domain livedns.Domain, err error) {
+	err = a.do(http.MethodGet, "domains/"+fqdn, nil, &domain)
+	return domain, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+func TestAPILiveDNSErrors(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		switch r.URL.Path {
+		case "/v5/livedns/domains/example.com/records/message/TXT":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, gandiRecordNotFoundBody)
+		case "/v5/livedns/domains/example.com/records/errors/TXT":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors": [{"location": "body", "name": "rrset_ttl", "description": "too low"}]}`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "<html>Bad Gateway</html>")
+		}
+	}))
+	defer server.Close()
+
+	client := newAPILiveDNS(context.Background(), config.Config{PersonalAccessToken: testToken, SharingID: "org 1", APIURL: server.URL})
+	for name, want := range map[string]string{
+		"message": "StatusCode: 404 ; Err: 404: Can't find the DNS record ",
+		"errors":  "StatusCode: 400 ; Err: rrset_ttl: too low ",
+		"html":    "StatusCode: 502 ; Err: 502 ",
+	} {
+		_, err := client.GetDomainRecordByNameAndType("example.com", name, "TXT")
+		var reqErr *types.RequestError
+		if !errors.As(err, &reqErr) || err.Error() != want {
+			t.Errorf("%s: expected %q, got %v", name, want, err)
+		}
+	}
+	if query != "sharing_id=org+1" {
+		t.Errorf("expected the sharing ID in the query, got %q", query)
+	}
+
+	// Requests end with the context of the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client = newAPILiveDNS(ctx, config.Config{PersonalAccessToken: testToken, APIURL: server.URL})
+	if err := client.DeleteDomainRecord("example.com", "message", "TXT"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the request to be canceled, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+const (
+	// GandiMaxRrsetSize is the largest combined size, in bytes, of the values
+	// we send in a single rrset update. An rrset larger than this cannot be
+	// returned in one DNS message, so Gandi (or the resolvers behind it) would
+	// not serve it reliably anyway.
+	GandiMaxRrsetSize = 65535
+
+	// ChallengeRecordType is the type of the rrsets holding challenge values.
+	ChallengeRecordType = "TXT"
+	// RecordClassIN is the class of the rrsets managed by the solver. LiveDNS
+	// only serves the IN class and its API takes no class parameter, so the
+	// class is carried in rrsetState but never sent.
+	RecordClassIN = "IN"
+)
+
+// liveDNSClient is the subset of the go-gandi LiveDNS API used by the solver.
+// It is satisfied by apiLiveDNS and allows tests to substitute a fake.
+type liveDNSClient interface {
+	GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error)
+	CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error)
+	UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error)
+	DeleteDomainRecord(fqdn, name, recordtype string) error
+	GetDomain(fqdn string) (livedns.Domain, error)
+}
+
+// checkRrsetSize ensures values can be sent to Gandi in a single update call.
+// The LiveDNS API replaces a whole rrset at once, so there is no way to split
+// an update across several calls.
+func checkRrsetSize(values []string) error {
+	size := 0
+	for _, v := range values {
+		size += len(v)
+	}
+	if size > GandiMaxRrsetSize {
+		return fmt.Errorf("rrset holds %d values totalling %d bytes, more than the %d bytes allowed in a single update",
+			len(values), size, GandiMaxRrsetSize)
+	}
+	return nil
+}
+
+// challengeValue returns the TXT value written for the challenge key, the
+// one the ACME server and cert-manager look up. The key is the base64url
+// digest of the key authorization, which needs no escaping, so the value is
+// the key itself, or the key in double quotes, the zone file form, when
+// quoted is set; every write goes through here so that the form applies to
+// Present, verification and CleanUp alike.
+func challengeValue(key string, quoted bool) string {
+	if quoted {
+		return `"` + key + `"`
+	}
+	return key
+}
+
+// isChallengeValue reports whether value, as read back from Gandi or from a
+// resolver, is the TXT value of the challenge key. Gandi may return TXT
+// values in their zone file form, enclosed in double quotes, which resolvers
+// never do. Both forms match whatever form is written.
+func isChallengeValue(value, key string) bool {
+	want := challengeValue(key, false)
+	if value == want {
+		return true
+	}
+	return len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' && value[1:len(value)-1] == want
+}
+
+// appendValue returns values with the value of the challenge key added, in
+// the form given by quoted, unless it is already present in either form.
+func appendValue(values []string, key string, quoted bool) []string {
+	for _, v := range values {
+		if isChallengeValue(v, key) {
+			return values
+		}
+	}
+	return append(append([]string{}, values...), challengeValue(key, quoted))
+}
+
+// uniqueValues returns values without repetitions, in their original order.
+func uniqueValues(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+// removeValue returns values without any occurrence of the value of the
+// challenge key, and whether it was found.
+func removeValue(values []string, key string) ([]string, bool) {
+	remaining := make([]string, 0, len(values))
+	found := false
+	for _, v := range values {
+		if isChallengeValue(v, key) {
+			found = true
+			continue
+		}
+		remaining = append(remaining, v)
+	}
+	return remaining, found
+}
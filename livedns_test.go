@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/issuer/acme/dns/util"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"github.com/miekg/dns"
+)
+
+// fakeLiveDNS is an in-memory liveDNSClient keyed by domain, name and type.
+type fakeLiveDNS struct {
+	mu      sync.Mutex
+	records map[string]livedns.DomainRecord
+	calls   []string
+	// errs makes the named call ("get", "create", "update", "delete",
+	// "domain") fail.
+	errs map[string]error
+}
+
+func newFakeLiveDNS() *fakeLiveDNS {
+	return &fakeLiveDNS{records: map[string]livedns.DomainRecord{}, errs: map[string]error{}}
+}
+
+// failWith makes every subsequent call named call return err.
+func (f *fakeLiveDNS) failWith(call string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs[call] = err
+}
+
+func fakeKey(fqdn, name, recordtype string) string {
+	return fqdn + "/" + name + "/" + recordtype
+}
+
+func notFoundError() error {
+	return &types.RequestError{StatusCode: 404, Err: fmt.Errorf("404: not found")}
+}
+
+// record logs call and returns the error configured for it, if any.
+func (f *fakeLiveDNS) record(call string) error {
+	f.calls = append(f.calls, call)
+	return f.errs[call]
+}
+
+// set stores a record directly, bypassing the call log.
+func (f *fakeLiveDNS) set(fqdn, name, recordtype string, values []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records[fakeKey(fqdn, name, recordtype)] = livedns.DomainRecord{
+		RrsetType: recordtype, RrsetTTL: GandiMinTtl, RrsetName: name, RrsetValues: values,
+	}
+}
+
+// values returns the stored values, or nil when the record does not exist.
+func (f *fakeLiveDNS) values(fqdn, name, recordtype string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.records[fakeKey(fqdn, name, recordtype)].RrsetValues
+}
+
+func (f *fakeLiveDNS) countCalls(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("get"); err != nil {
+		return livedns.DomainRecord{}, err
+	}
+	r, ok := f.records[fakeKey(fqdn, name, recordtype)]
+	if !ok {
+		return livedns.DomainRecord{}, notFoundError()
+	}
+	r.RrsetValues = append([]string{}, r.RrsetValues...)
+	return r, nil
+}
+
+func (f *fakeLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("create"); err != nil {
+		return types.StandardResponse{}, err
+	}
+	k := fakeKey(fqdn, name, recordtype)
+	if _, ok := f.records[k]; ok {
+		return types.StandardResponse{}, &types.RequestError{StatusCode: 409, Err: fmt.Errorf("409: already exists")}
+	}
+	f.records[k] = livedns.DomainRecord{
+		RrsetType: recordtype, RrsetTTL: ttl, RrsetName: name, RrsetValues: append([]string{}, values...),
+	}
+	return types.StandardResponse{Message: "DNS Record Created"}, nil
+}
+
+func (f *fakeLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("update"); err != nil {
+		return types.StandardResponse{}, err
+	}
+	f.records[fakeKey(fqdn, name, recordtype)] = livedns.DomainRecord{
+		RrsetType: recordtype, RrsetTTL: ttl, RrsetName: name, RrsetValues: append([]string{}, values...),
+	}
+	return types.StandardResponse{Message: "DNS Record Created"}, nil
+}
+
+func (f *fakeLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("delete"); err != nil {
+		return err
+	}
+	k := fakeKey(fqdn, name, recordtype)
+	if _, ok := f.records[k]; !ok {
+		return notFoundError()
+	}
+	delete(f.records, k)
+	return nil
+}
+
+func TestCheckRrsetSize(t *testing.T) {
+	if err := checkRrsetSize([]string{"a", "b"}); err != nil {
+		t.Errorf("unexpected error for a small rrset: %v", err)
+	}
+	if err := checkRrsetSize([]string{strings.Repeat("x", GandiMaxRrsetSize+1)}); err == nil {
+		t.Error("expected an error for an oversized rrset")
+	}
+}
+
+func TestChallengeValue(t *testing.T) {
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	if got := challengeValue(key, false); got != key {
+		t.Errorf("challengeValue(%q) = %q, want the key itself", key, got)
+	}
+	if got := challengeValue(key, true); got != `"`+key+`"` {
+		t.Errorf("challengeValue(%q, quoted) = %q, want the key in quotes", key, got)
+	}
+	for _, v := range []string{key, `"` + key + `"`} {
+		if !isChallengeValue(v, key) {
+			t.Errorf("expected %s to be the value of the key", v)
+		}
+	}
+	for _, v := range []string{"", `"`, `""`, `"` + key, key + "x", `"other"`} {
+		if isChallengeValue(v, key) {
+			t.Errorf("expected %s not to be the value of the key", v)
+		}
+	}
+
+	// Present, verification and CleanUp share the matching.
+	values := appendValue([]string{`"` + key + `"`, "other"}, key, false)
+	if len(values) != 2 {
+		t.Errorf("expected the quoted value not to be duplicated, got %v", values)
+	}
+	remaining, found := removeValue(values, key)
+	if !found || strings.Join(remaining, ",") != "other" {
+		t.Errorf("expected the quoted value to be removed, got %v, %v", remaining, found)
+	}
+}
+
+func TestAppendAndRemoveValue(t *testing.T) {
+	values := appendValue([]string{"a"}, "b", false)
+	values = appendValue(values, "b", true)
+	if strings.Join(values, ",") != "a,b" {
+		t.Errorf("appendValue should not duplicate values, got %v", values)
+	}
+
+	remaining, found := removeValue(values, "a")
+	if !found || strings.Join(remaining, ",") != "b" {
+		t.Errorf("removeValue(a) = %v, %v", remaining, found)
+	}
+	if _, found := removeValue(values, "c"); found {
+		t.Error("removeValue should report a missing value as not found")
+	}
+}
+
+func TestQuoteTXTValues(t *testing.T) {
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	// By default the key is sent bare.
+	if err := solver.Present(newTestChallenge(key)); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != key {
+		t.Errorf("expected the bare key to be stored, got %v", got)
+	}
+
+	// With quoteTXTValues, a second key is sent quoted next to the first,
+	// which is recognized as present.
+	quoted := newTestChallenge("other")
+	quoted.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "quoteTXTValues": true}`)
+	if err := solver.Present(quoted); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	again := newTestChallenge(key)
+	again.Config.Raw = quoted.Config.Raw
+	if err := solver.Present(again); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	got := gandiClient.values("example.com", "_acme-challenge", "TXT")
+	if strings.Join(got, ",") != key+`,"other"` {
+		t.Errorf("expected the second key quoted and the first kept as is, got %v", got)
+	}
+	// Both stored forms are published as the bare key, which is what
+	// cert-manager's self-check and the propagation verification look for.
+	addr := serveZone(t, gandiClient, "example.com")
+	for _, k := range []string{key, "other"} {
+		found, err := util.PreCheckDNS(context.Background(), "_acme-challenge.example.com.", k, []string{addr}, false)
+		if err != nil || !found {
+			t.Errorf("cert-manager's self-check does not find %s in %v: %v", k, got, err)
+		}
+	}
+	solver.resolver = newAuthoritativeResolver([]string{addr}, false)
+	verified := newTestChallenge("other")
+	verified.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "quoteTXTValues": true,
+		"verification": {"enabled": true, "timeout": "5s", "initialInterval": "1ms", "maxInterval": "1ms"}}`)
+	if err := solver.Present(verified); err != nil {
+		t.Errorf("the propagation verification does not find the quoted key: %v", err)
+	}
+
+	// CleanUp removes the values whatever their form.
+	if err := solver.CleanUp(newTestChallenge("other")); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if err := solver.CleanUp(again); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if len(gandiClient.records) != 0 {
+		t.Errorf("expected the zone to be empty again, got %v", gandiClient.records)
+	}
+}
+
+func (f *fakeLiveDNS) GetDomain(fqdn string) (livedns.Domain, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("domain"); err != nil {
+		return livedns.Domain{}, err
+	}
+	return livedns.Domain{FQDN: fqdn}, nil
+}
+
+// serveZone serves the TXT rrsets of domain held by gandiClient over DNS on
+// a local UDP address, which it returns. Values are parsed as zone file
+// data, as Gandi publishes them.
+func serveZone(t *testing.T, gandiClient *fakeLiveDNS, domain string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		name := strings.TrimSuffix(strings.TrimSuffix(q.Name, "."), "."+domain)
+		if q.Qtype == dns.TypeTXT {
+			for _, v := range gandiClient.values(domain, name, "TXT") {
+				rr, err := dns.NewRR(q.Name + " 300 IN TXT " + v)
+				if err != nil {
+					t.Errorf("stored value %s is not valid TXT data: %v", v, err)
+					continue
+				}
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// keyedMutex serializes operations sharing a key while letting operations on
+// different keys run concurrently. Its zero value is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+// refMutex is a mutex counting the goroutines holding or waiting for it, so
+// that it can be dropped from the map once unused.
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks key and returns the function unlocking it.
+func (k *keyedMutex) Lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*refMutex{}
+	}
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		m.refs--
+		if m.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// rrsetKey identifies the rrset name of domain; DNS names are case-insensitive.
+func rrsetKey(domain, name string) string {
+	return strings.ToLower(domain) + "/" + strings.ToLower(name)
+}
+
+// ZoneConcurrencyEnv caps the number of operations running at once on the
+// rrsets of a zone, so that a zone with slow API calls only holds up its own
+// challenges. Zero, the default, means no cap.
+const ZoneConcurrencyEnv = "ZONE_CONCURRENCY"
+
+// zoneSemaphores bounds the concurrent operations per zone. Its zero value
+// imposes no bound.
+type zoneSemaphores struct {
+	limit int
+	mu    sync.Mutex
+	// sems only holds the zones with operations running or waiting, like
+	// keyedMutex does.
+	sems map[string]*zoneSemaphore
+}
+
+type zoneSemaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+// Acquire waits for a slot of zone and returns the function releasing it.
+// It gives up with the error of ctx once ctx is done.
+func (z *zoneSemaphores) Acquire(ctx context.Context, zone string) (release func(), err error) {
+	if z.limit <= 0 {
+		return func() {}, nil
+	}
+	zone = strings.ToLower(zone)
+	z.mu.Lock()
+	if z.sems == nil {
+		z.sems = map[string]*zoneSemaphore{}
+	}
+	sem, ok := z.sems[zone]
+	if !ok {
+		sem = &zoneSemaphore{slots: make(chan struct{}, z.limit)}
+		z.sems[zone] = sem
+	}
+	sem.refs++
+	z.mu.Unlock()
+
+	unref := func() {
+		z.mu.Lock()
+		defer z.mu.Unlock()
+		sem.refs--
+		if sem.refs == 0 {
+			delete(z.sems, zone)
+		}
+	}
+	select {
+	case sem.slots <- struct{}{}:
+	case <-ctx.Done():
+		unref()
+		return nil, fmt.Errorf("waiting for a slot of zone %s: %w", zone, ctx.Err())
+	}
+	return func() {
+		<-sem.slots
+		unref()
+	}, nil
+}
+
+// lockRrset locks the rrset name of domain, then takes a slot of the zone
+// unless ctx is done first. Waiting on the rrset first keeps queued
+// operations from holding slots.
+func (c *gandiDNSProviderSolver) lockRrset(ctx context.Context, domain, name string) (unlock func(), err error) {
+	unlockRrset := c.rrsetLocks.Lock(rrsetKey(domain, name))
+	release, err := c.zoneSlots.Acquire(ctx, domain)
+	if err != nil {
+		unlockRrset()
+		return nil, err
+	}
+	return func() {
+		release()
+		unlockRrset()
+	}, nil
+}
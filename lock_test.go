@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+// slowLiveDNS widens the window between reading and writing an rrset, making
+// lost updates likely if operations on it are not serialized.
+type slowLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (s slowLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	rec, err := s.fakeLiveDNS.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	time.Sleep(time.Millisecond)
+	return rec, err
+}
+
+func TestKeyedMutex(t *testing.T) {
+	var k keyedMutex
+	unlockA := k.Lock("a")
+
+	// Another key is not blocked by a.
+	k.Lock("b")()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := k.Lock("a")
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("second Lock(a) should block until the first is released")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlockA()
+	<-locked
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.locks) != 0 {
+		t.Errorf("unused locks should be dropped, got %v", k.locks)
+	}
+}
+
+func TestConcurrentPresentCleanUpConverges(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return slowLiveDNS{gandiClient}
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			errs <- solver.Present(newTestChallenge(key))
+		}(fmt.Sprintf("key-%d", i))
+	}
+	wg.Wait()
+
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != n {
+		t.Fatalf("expected %d values after concurrent Present, got %d: %v", n, len(got), got)
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			errs <- solver.CleanUp(newTestChallenge(key))
+		}(fmt.Sprintf("key-%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("rrset should be deleted after concurrent CleanUp, got %v", got)
+	}
+}
+
+// blockingLiveDNS blocks the reads of zone until release is closed,
+// counting the reads blocked at once.
+type blockingLiveDNS struct {
+	*fakeLiveDNS
+	zone    string
+	release chan struct{}
+	blocked *atomic.Int32
+}
+
+func (b blockingLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	if fqdn == b.zone {
+		b.blocked.Add(1)
+		<-b.release
+		b.blocked.Add(-1)
+	}
+	return b.fakeLiveDNS.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+}
+
+func TestSlowZoneDoesNotBlockOthers(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	release := make(chan struct{})
+	var blocked atomic.Int32
+	solver := newTestSolver(gandiClient)
+	solver.zoneSlots.limit = 1
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return blockingLiveDNS{fakeLiveDNS: gandiClient, zone: "slow.example", release: release, blocked: &blocked}
+	}
+	challenge := func(zone, fqdn, key string) *v1alpha1.ChallengeRequest {
+		ch := newTestChallenge(key)
+		ch.ResolvedZone = zone + "."
+		ch.ResolvedFQDN = fqdn + "."
+		return ch
+	}
+
+	// Two rrsets of the slow zone, which the rrset locks do not serialize.
+	slowDone := make(chan error, 2)
+	for _, name := range []string{"a", "b"} {
+		go func(name string) {
+			slowDone <- solver.Present(challenge("slow.example", "_acme-challenge."+name+".slow.example", name))
+		}(name)
+	}
+
+	fastDone := make(chan error, 1)
+	go func() { fastDone <- solver.Present(challenge("fast.example", "_acme-challenge.fast.example", "fast")) }()
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a slow zone blocked a challenge of another zone")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := blocked.Load(); n != 1 {
+		t.Errorf("expected a single call in flight on the slow zone, got %d", n)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-slowDone; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"_acme-challenge.a", "_acme-challenge.b"} {
+		if got := gandiClient.values("slow.example", name, "TXT"); len(got) != 1 {
+			t.Errorf("expected the slow challenge of %s to complete, got %v", name, got)
+		}
+	}
+}
+
+func TestZoneSemaphoresLimit(t *testing.T) {
+	z := zoneSemaphores{limit: 1}
+	release, err := z.Acquire(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		release, _ := z.Acquire(context.Background(), "EXAMPLE.com")
+		release()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("the second operation on the zone should wait for a slot")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// Other zones have slots of their own.
+	other, err := z.Acquire(context.Background(), "example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	// Waiting for a slot ends with the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := z.Acquire(ctx, "example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to end with the context, got %v", err)
+	}
+	release()
+	<-acquired
+
+	// Idle zones are forgotten.
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if len(z.sems) != 0 {
+		t.Errorf("expected no semaphore left, got %d", len(z.sems))
+	}
+}
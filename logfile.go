@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LogFileEnv names a file receiving the logs in addition to stderr.
+	LogFileEnv = "LOG_FILE"
+	// logFileMaxSize is the size past which the log file is truncated, the
+	// default -log_file_max_size of klog.
+	logFileMaxSize = 1800 * 1024 * 1024
+)
+
+// setupLogFileFromEnv makes klog also write to the file named by LogFileEnv,
+// see setupLogFile. It does nothing when the variable is unset.
+func setupLogFileFromEnv() error {
+	path := os.Getenv(LogFileEnv)
+	if path == "" {
+		return nil
+	}
+	return setupLogFile(path)
+}
+
+// setupLogFile makes klog write to path as well as to stderr. klog appends to
+// an existing file on startup and truncates it once it grows past
+// -log_file_max_size, it does not keep rotated copies.
+func setupLogFile(path string) error {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	for name, value := range map[string]string{
+		"log_file":        path,
+		"logtostderr":     "false",
+		"alsologtostderr": "true",
+	} {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	klog.V(6).Infof("logging to %s", path)
+	return nil
+}
+
+// cappedFile is the log file as klog keeps it, for the loggers klog hands
+// its lines to: appended to on open and truncated once a write takes it past
+// max bytes.
+type cappedFile struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	max  int64
+}
+
+// openCappedFile opens path for appending, truncating it past max bytes.
+func openCappedFile(path string, max int64) (*cappedFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &cappedFile{f: f, size: info.Size(), max: max}, nil
+}
+
+func (c *cappedFile) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size+int64(len(p)) > c.max {
+		if err := c.f.Truncate(0); err != nil {
+			return 0, err
+		}
+		c.size = 0
+	}
+	n, err := c.f.Write(p)
+	c.size += int64(n)
+	return n, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/klog/v2"
+)
+
+func TestSetupLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook.log")
+	t.Cleanup(func() {
+		fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+		klog.InitFlags(fs)
+		_ = fs.Set("logtostderr", "true")
+		_ = fs.Set("alsologtostderr", "false")
+		_ = fs.Set("log_file", "")
+	})
+
+	t.Setenv(LogFileEnv, path)
+	if err := setupLogFileFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	klog.Info("written to the log file")
+	klog.Flush()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "written to the log file") {
+		t.Errorf("the log file does not hold the message: %q", data)
+	}
+}
+
+func TestCappedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openCappedFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.f.Close()
+
+	read := func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	f.Write([]byte("line\n"))
+	if got := read(); got != "old\nline\n" {
+		t.Errorf("expected the file to be appended to, got %q", got)
+	}
+	f.Write([]byte("past\n"))
+	if got := read(); got != "past\n" {
+		t.Errorf("expected the file to be truncated past its size, got %q", got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LogFormatEnv selects the format of the logs, LogFormatText by default.
+	LogFormatEnv = "LOG_FORMAT"
+	// LogFormatText is the klog text format.
+	LogFormatText = "text"
+	// LogFormatLogfmt writes each line as logfmt `key=value` pairs, see
+	// logfmtSink.
+	LogFormatLogfmt = "logfmt"
+)
+
+// setupLogFormatFromEnv switches klog to the format named by LogFormatEnv.
+// klog no longer writes its own files once its output is redirected, so the
+// logfmt lines are written to the LogFileEnv file, if any, kept as klog
+// would, as well as to stderr.
+func setupLogFormatFromEnv() error {
+	switch format := os.Getenv(LogFormatEnv); format {
+	case "", LogFormatText:
+		return nil
+	case LogFormatLogfmt:
+		var out io.Writer = os.Stderr
+		if path := os.Getenv(LogFileEnv); path != "" {
+			f, err := openCappedFile(path, logFileMaxSize)
+			if err != nil {
+				return err
+			}
+			out = io.MultiWriter(os.Stderr, f)
+		}
+		klog.SetLogger(logr.New(newLogfmtSink(out)))
+		return nil
+	default:
+		return fmt.Errorf("invalid %s %q: must be %q or %q", LogFormatEnv, format, LogFormatText, LogFormatLogfmt)
+	}
+}
+
+// logfmtSink writes log entries as logfmt lines: ts, level, v for verbose
+// entries, caller, logger when named, msg, then the key/value pairs of the
+// entry and err for errors. klog hands warnings over as info entries.
+type logfmtSink struct {
+	mu *sync.Mutex
+	w  io.Writer
+	// depth is the number of frames between the sink and the caller.
+	depth  int
+	name   string
+	values []any
+	now    func() time.Time
+}
+
+func newLogfmtSink(w io.Writer) *logfmtSink {
+	return &logfmtSink{mu: &sync.Mutex{}, w: w, now: time.Now}
+}
+
+func (s *logfmtSink) Init(info logr.RuntimeInfo) {
+	s.depth += info.CallDepth
+}
+
+// Enabled always holds: klog filters on its own verbosity before calling the
+// sink.
+func (s *logfmtSink) Enabled(int) bool {
+	return true
+}
+
+func (s *logfmtSink) Info(level int, msg string, keysAndValues ...any) {
+	s.write("info", level, msg, nil, keysAndValues)
+}
+
+func (s *logfmtSink) Error(err error, msg string, keysAndValues ...any) {
+	s.write("error", 0, msg, err, keysAndValues)
+}
+
+func (s *logfmtSink) WithValues(keysAndValues ...any) logr.LogSink {
+	c := *s
+	c.values = append(append([]any(nil), s.values...), keysAndValues...)
+	return &c
+}
+
+func (s *logfmtSink) WithName(name string) logr.LogSink {
+	c := *s
+	if c.name != "" {
+		name = c.name + "/" + name
+	}
+	c.name = name
+	return &c
+}
+
+func (s *logfmtSink) WithCallDepth(depth int) logr.LogSink {
+	c := *s
+	c.depth += depth
+	return &c
+}
+
+func (s *logfmtSink) write(level string, v int, msg string, err error, keysAndValues []any) {
+	var b strings.Builder
+	b.WriteString("ts=" + s.now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(" level=" + level)
+	if v > 0 {
+		b.WriteString(" v=" + strconv.Itoa(v))
+	}
+	// Skip write and the Info or Error method calling it.
+	if _, file, line, ok := runtime.Caller(s.depth + 2); ok {
+		writeLogfmtPair(&b, "caller", filepath.Base(file)+":"+strconv.Itoa(line))
+	}
+	if s.name != "" {
+		writeLogfmtPair(&b, "logger", s.name)
+	}
+	writeLogfmtPair(&b, "msg", msg)
+	for _, kv := range [][]any{s.values, keysAndValues} {
+		for i := 0; i < len(kv); i += 2 {
+			var value any = "(MISSING)"
+			if i+1 < len(kv) {
+				value = kv[i+1]
+			}
+			writeLogfmtPair(&b, fmt.Sprint(kv[i]), value)
+		}
+	}
+	if err != nil {
+		writeLogfmtPair(&b, "err", err)
+	}
+	b.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.w, b.String())
+}
+
+// writeLogfmtPair appends ` key=value` to b, quoting value when it is empty
+// or holds spaces, quotes, `=` or control characters.
+func writeLogfmtPair(b *strings.Builder, key string, value any) {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case error:
+		str = v.Error()
+	case fmt.Stringer:
+		str = v.String()
+	default:
+		str = fmt.Sprint(v)
+	}
+	b.WriteByte(' ')
+	b.WriteString(key)
+	b.WriteByte('=')
+	if str == "" || strings.ContainsFunc(str, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f
+	}) {
+		str = strconv.Quote(str)
+	}
+	b.WriteString(str)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+func TestLogfmtFormat(t *testing.T) {
+	var out bytes.Buffer
+	sink := newLogfmtSink(&out)
+	sink.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	klog.SetLogger(logr.New(sink))
+	t.Cleanup(klog.ClearLogger)
+
+	klog.Infof("present: challengeFQDN=%s, domain=%s", "_acme-challenge", "example.com")
+	klog.InfoS("record written", "zone", "example.com", "values", []string{"a", "b"}, "note", `say "hi"`)
+	klog.ErrorS(errors.New("got code 500"), "unable to write record", "zone", "example.com")
+	klog.Flush()
+
+	lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+	want := []string{
+		`^ts=2024-05-01T12:00:00Z level=info caller=logformat_test\.go:\d+ msg="present: challengeFQDN=_acme-challenge, domain=example\.com"$`,
+		`^ts=2024-05-01T12:00:00Z level=info caller=logformat_test\.go:\d+ msg="record written" zone=example\.com values="\[a b\]" note="say \\"hi\\""$`,
+		`^ts=2024-05-01T12:00:00Z level=error caller=logformat_test\.go:\d+ msg="unable to write record" zone=example\.com err="got code 500"$`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %q", len(want), out.String())
+	}
+	for i, re := range want {
+		if !regexp.MustCompile(re).Match(lines[i]) {
+			t.Errorf("line %d: %s does not match %s", i, lines[i], re)
+		}
+	}
+
+	t.Setenv(LogFormatEnv, "yaml")
+	if err := setupLogFormatFromEnv(); err == nil {
+		t.Error("expected an unknown LOG_FORMAT to be rejected")
+	}
+}
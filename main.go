@@ -2,43 +2,166 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
-	"github.com/go-gandi/go-gandi"
 	"github.com/go-gandi/go-gandi/config"
-	"github.com/go-gandi/go-gandi/livedns"
 	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 )
 
 const (
 	GandiMinTtl = 300 // Gandi reports an error for values < this value
+
+	// DefaultMaxValues is the default cap on the values of a challenge
+	// rrset. Concurrent challenges for one name rarely exceed a handful, so
+	// reaching it points to leftovers of failed cleanups.
+	DefaultMaxValues = 100
+
+	// DefaultConflictRetries is the default number of retries of a write
+	// racing with the creation or deletion of its rrset.
+	DefaultConflictRetries = 2
+
+	// DelayedCleanupShutdownTimeout bounds the wait for the removals delayed
+	// by cleanupDelay on shutdown, within the 30s default grace period of a
+	// pod.
+	DelayedCleanupShutdownTimeout = 20 * time.Second
+
+	// DebugFQDNEnv, set to "true", logs the input and result of every record
+	// name computation without raising the verbosity.
+	DebugFQDNEnv = "DEBUG_FQDN"
+
+	// DefaultPATSecretKey is the Secret key read when PATSecretRef.Key is empty
+	DefaultPATSecretKey = "api-token"
+
+	// KubeAPIQPSEnv and KubeAPIBurstEnv set the client-side rate limit of the
+	// Kubernetes client, which reads a Secret for every challenge operation.
+	KubeAPIQPSEnv       = "KUBE_API_QPS"
+	KubeAPIBurstEnv     = "KUBE_API_BURST"
+	DefaultKubeAPIQPS   = 20
+	DefaultKubeAPIBurst = 40
+
+	// KubeAPICheckEnv makes Initialize check that the Kubernetes API answers,
+	// with one of the KubeAPICheck modes. Building the client does not
+	// connect, so an unreachable API otherwise only shows on the first
+	// challenge.
+	KubeAPICheckEnv = "KUBE_API_CHECK"
+	// KubeAPICheckOff, the default, skips the check.
+	KubeAPICheckOff = "off"
+	// KubeAPICheckWarn logs a warning when the API does not answer.
+	KubeAPICheckWarn = "warn"
+	// KubeAPICheckFail fails Initialize, and so the startup, when the API
+	// does not answer.
+	KubeAPICheckFail = "fail"
 )
 
 var GroupName = os.Getenv("GROUP_NAME")
 
 func main() {
+	if isSelfTest(os.Args[1:]) {
+		if err := runSelfTest(os.Args[1:], os.Stdout, newGandiLiveDNSClient); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if hasFlag(os.Args[1:], ReportChallengesFlag) {
+		if err := runReportChallenges(os.Args[1:], os.Stdout, newGandiLiveDNSClient); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if GroupName == "" {
 		panic("GROUP_NAME must be specified")
 	}
+	if err := setupLogFileFromEnv(); err != nil {
+		panic(fmt.Sprintf("unable to set up %s: %v", LogFileEnv, err))
+	}
+	if err := setupLogFormatFromEnv(); err != nil {
+		panic(fmt.Sprintf("unable to set up %s: %v", LogFormatEnv, err))
+	}
+	if err := validateServingArgs(os.Args[1:]); err != nil {
+		panic(err.Error())
+	}
+	apiURL, err := apiURLFromEnv()
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := installAPITransport(); err != nil {
+		panic(err.Error())
+	}
+	if os.Getenv(LogAPIRequestsEnv) == "true" {
+		if err := installAPIRequestLogging(apiURL); err != nil {
+			panic(err.Error())
+		}
+	}
+	if os.Getenv(CheckAPISchemaEnv) == "true" {
+		if err := installAPISchemaCheck(apiURL); err != nil {
+			panic(err.Error())
+		}
+	}
+	stopTracing, err := setupTracing(context.Background())
+	if err != nil {
+		panic(fmt.Sprintf("unable to set up tracing: %v", err))
+	}
 
 	// This will register our gandi DNS provider with the webhook serving
 	// library, making it available as an API under the provided GroupName.
 	// You can register multiple DNS provider implementations with a single
 	// webhook, where the Name() method will be used to disambiguate between
 	// the different implementations.
-	cmd.RunWebhookServer(GroupName,
-		&gandiDNSProviderSolver{},
-	)
+	solver := &gandiDNSProviderSolver{
+		credentialsConfigMap: os.Getenv(CredentialsConfigMapEnv),
+		globalCredentials:    globalCredentialsFromEnv(),
+		debugFQDN:            os.Getenv(DebugFQDNEnv) == "true",
+		skipLabel:            os.Getenv(SkipNamespaceLabelEnv),
+		auditLog:             os.Getenv(AuditLogEnv) == "true",
+		apiURL:               apiURL,
+		stopTracing:          stopTracing,
+	}
+	solvers := []webhook.Solver{solver}
+	// cert-manager does not define which solver wins when two of them share
+	// a name, so refuse to start instead of silently picking one.
+	if err := checkDuplicateSolverNames(solvers); err != nil {
+		panic(err.Error())
+	}
+	cmd.RunWebhookServer(GroupName, solvers...)
+	// The server closed stopCh on its way out, which runs the removals
+	// delayed by cleanupDelay right away; give them a chance to finish.
+	if !solver.waitDelayedCleanups(DelayedCleanupShutdownTimeout) {
+		klog.Warningf("cleanup: gave up waiting for the delayed removals after %v, some challenge values may be left behind", DelayedCleanupShutdownTimeout)
+	}
+	klog.Flush()
+}
+
+// checkDuplicateSolverNames returns an error naming the first solver Name()
+// that is registered more than once.
+func checkDuplicateSolverNames(solvers []webhook.Solver) error {
+	seen := make(map[string]struct{}, len(solvers))
+	for _, s := range solvers {
+		name := s.Name()
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("solver name %q is registered more than once; each solver must have a unique Name()", name)
+		}
+		seen[name] = struct{}{}
+	}
+	return nil
 }
 
 // gandiDNSProviderSolver implements the provider-specific logic needed to
@@ -46,7 +169,99 @@ func main() {
 // To do so, it must implement the `github.com/cert-manager/cert-manager/pkg/acme/webhook.Solver`
 // interface.
 type gandiDNSProviderSolver struct {
-	client *kubernetes.Clientset
+	client kubernetes.Interface
+	// secrets caches credential Secrets, nil unless SecretInformerEnv is set.
+	secrets *secretCache
+	// zoneRoutes caches the credentials ConfigMap, nil until Initialize
+	// starts its informer.
+	zoneRoutes corelisters.ConfigMapNamespaceLister
+	// credentialsConfigMap is the `<namespace>/<name>` of the ConfigMap routing
+	// zones to credential Secrets, empty when disabled.
+	credentialsConfigMap string
+	// apiURL is the Gandi API URL, the go-gandi default when empty.
+	apiURL string
+	// credentials returns the Gandi credentials of a challenge, it defaults
+	// to secretCredentialProvider.
+	credentials credentialProvider
+	// globalCredentials are read from GlobalPATEnv and used for challenges
+	// without solver config, nil when unset.
+	globalCredentials *gandiCredentials
+	// tokens reads the token of the Secret referenced by an issuer for
+	// secretCredentialProvider, it defaults to kubeSecretReader.
+	tokens secretReader
+	// newLiveDNSClient builds the Gandi client from the decoded credentials.
+	// It defaults to newGandiLiveDNSClient and is replaced in tests.
+	newLiveDNSClient func(config.Config) liveDNSClient
+	// debugFQDN logs every record name computation at info level, set from
+	// DebugFQDNEnv.
+	debugFQDN bool
+	// recordName computes the record name and Gandi domain of a challenge.
+	// It defaults to defaultRecordName and lets tests or advanced setups
+	// (e.g. delegated challenge names) substitute their own logic.
+	recordName func(ch *v1alpha1.ChallengeRequest) (entry, domain string)
+	// resolver is used by the propagation verification, it defaults to
+	// systemResolver and is replaced in tests.
+	resolver txtResolver
+	// nsResolver discovers the nameservers of a zone for the authoritative
+	// verification, it defaults to net.DefaultResolver.
+	nsResolver nsResolver
+	// nameservers caches the nameservers warmed up for verification.
+	nameservers nameserverCache
+	// nameForms caches the record name form detected for each zone.
+	nameForms nameFormCache
+	// rrsetLocks serializes the read-modify-write of a given rrset between
+	// concurrent Present and CleanUp calls of this replica.
+	rrsetLocks keyedMutex
+	// zoneSlots bounds the concurrent operations per zone, as configured by
+	// ZoneConcurrencyEnv.
+	zoneSlots zoneSemaphores
+	// maxFQDNDepth is the maximum number of labels of a challenge FQDN, set
+	// from MaxFQDNDepthEnv by Initialize; zero means DefaultMaxFQDNDepth.
+	maxFQDNDepth int
+	// maxSecretSize is the maximum size of a Personal Access Token, set from
+	// MaxSecretSizeEnv by Initialize; zero means DefaultMaxSecretSize.
+	maxSecretSize int
+	// maxCachedZones is the number of zones each per-zone cache holds, set
+	// from MaxCachedZonesEnv by Initialize; zero means DefaultMaxCachedZones.
+	maxCachedZones int
+	// metricsZoneLabel fills the zone label of the per-operation metrics,
+	// as set by MetricsZoneLabelEnv.
+	metricsZoneLabel bool
+	// auditLog logs an audit line around each Present and CleanUp call and
+	// tags it with a correlation ID, as set by AuditLogEnv.
+	auditLog bool
+	// skipLabel is the namespace label turning solving off, as set by
+	// SkipNamespaceLabelEnv.
+	skipLabel string
+	// retry is the global retry policy read from the environment, see
+	// retryDefaults.
+	retry *retryPolicy
+	// batchWindow is the batching window read from BatchWindowEnv, zero
+	// when batching is disabled.
+	batchWindow time.Duration
+	// batches holds the pending batched operations.
+	batches rrsetBatcher
+	// coalesceCleanups is read from CoalesceCleanupsEnv; cleanups holds the
+	// removals in flight when it is set.
+	coalesceCleanups bool
+	cleanups         callGroup
+	// limiters throttles the calls of each issuer, see IssuerRateLimitEnv.
+	limiters issuerLimiters
+	// stopTracing flushes the spans on shutdown, nil when tracing is off.
+	stopTracing func(context.Context) error
+	// stopCh is the channel passed to Initialize, closed on shutdown.
+	stopCh <-chan struct{}
+	// delayedCleanups tracks the removals deferred by CleanupDelay.
+	delayedCleanups sync.WaitGroup
+	// pendingCleanups holds the deferred removals by value, so that
+	// presenting the value again cancels its removal.
+	pendingMu       sync.Mutex
+	pendingCleanups map[string]*pendingCleanup
+	// cnameHolders holds, by cleanupKey, the challenge names whose value was
+	// written at a CNAME target with CheckCNAMETarget, so that one CleanUp
+	// does not remove a value another challenge still needs.
+	cnameMu      sync.Mutex
+	cnameHolders map[string]map[string]struct{}
 }
 
 // gandiDNSProviderConfig is a structure that is used to decode into when
@@ -66,7 +281,191 @@ type gandiDNSProviderSolver struct {
 type gandiDNSProviderConfig struct {
 	// These fields will be set by users in the
 	// `issuer.spec.acme.dns01.providers.webhook.config` field.
+	// PATSecretRef.Key defaults to DefaultPATSecretKey when omitted.
 	PATSecretRef cmmeta.SecretKeySelector `json:"PATSecretRef"`
+	// PATSecretSelector selects the Secret by labels instead of by
+	// PATSecretRef.Name, exactly one Secret of the namespace must match.
+	// PATSecretRef.Key still names the key holding the token.
+	PATSecretSelector *metav1.LabelSelector `json:"PATSecretSelector,omitempty"`
+	// SharingID is the Gandi organization ID to act on behalf of, needed when
+	// the zone belongs to a (sub-)organization rather than to the token owner.
+	SharingID string `json:"sharingID,omitempty"`
+	// TTL of the challenge TXT record, defaults to GandiMinTtl.
+	// Values below GandiMinTtl are raised to it.
+	TTL int `json:"ttl,omitempty"`
+	// PreserveExistingTTL keeps the TTL of an existing rrset when adding or
+	// removing a value, TTL and ZoneTTLs then only apply to new rrsets.
+	PreserveExistingTTL bool `json:"preserveExistingTTL,omitempty"`
+	// ZoneTTLs overrides TTL for the zones matching one of its suffix keys,
+	// the longest matching suffix winning. Values must be at least GandiMinTtl.
+	ZoneTTLs map[string]int `json:"zoneTTLs,omitempty"`
+	// MaxValues caps the number of values of a challenge rrset: Present
+	// fails rather than add a value past it. Defaults to DefaultMaxValues.
+	MaxValues int `json:"maxValues,omitempty"`
+	// ForceReplace makes Present delete an existing rrset and recreate it with
+	// only the challenge key, dropping the values of any concurrent challenge.
+	// It is meant for recovering from malformed values and defaults to off.
+	ForceReplace bool `json:"forceReplace,omitempty"`
+	// ExpectedDomains, when set, restricts the solver to the zones equal to
+	// or under one of these domains, protecting against an issuer config
+	// copied to the wrong zone.
+	ExpectedDomains []string `json:"expectedDomains,omitempty"`
+	// ZoneNames maps a zone, as resolved by cert-manager, to the name of the
+	// zone at Gandi when the two differ, e.g. an IDN zone registered in its
+	// punycode form. Keys are compared case-insensitively, ignoring trailing
+	// dots; unlisted zones are used as resolved.
+	ZoneNames map[string]string `json:"zoneNames,omitempty"`
+	// CheckCNAMETarget writes the challenge value at the target of the
+	// challenge name when it is a CNAME to another name of the same zone, as
+	// happens when several challenge names are delegated to one record.
+	// CleanUp removes it from there once no other challenge name of this
+	// webhook still holds the same value.
+	CheckCNAMETarget bool `json:"checkCNAMETarget,omitempty"`
+	// RecordNameForm is the form of the record names sent to Gandi:
+	// RecordNameRelative, the default, RecordNameQualified or RecordNameAuto.
+	RecordNameForm string `json:"recordNameForm,omitempty"`
+	// PublicSuffixCheck is what to do when the zone of a challenge is a
+	// public suffix: PublicSuffixWarn, the default, PublicSuffixError or
+	// PublicSuffixOff.
+	PublicSuffixCheck string `json:"publicSuffixCheck,omitempty"`
+	// PreserveNameCase sends the record name as derived from the challenge
+	// FQDN instead of in lowercase.
+	PreserveNameCase bool `json:"preserveNameCase,omitempty"`
+	// ChallengePrefix is the label challenge record names are expected to
+	// start with, defaults to DefaultChallengePrefix. A mismatch is only
+	// logged, as it usually points to a misrouted request.
+	ChallengePrefix string `json:"challengePrefix,omitempty"`
+	// DecodeBase64 applies an extra base64 decoding to the token read from
+	// the Secret, for tokens that were stored already encoded.
+	DecodeBase64 bool `json:"decodeBase64,omitempty"`
+	// Timeout bounds each Gandi and Kubernetes API call, defaults to the
+	// go-gandi default of 5s.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// PresentTimeout and CleanupTimeout override Timeout for the calls made
+	// by Present and CleanUp respectively.
+	PresentTimeout *metav1.Duration `json:"presentTimeout,omitempty"`
+	CleanupTimeout *metav1.Duration `json:"cleanupTimeout,omitempty"`
+	// OperationTimeout bounds a whole Present or CleanUp, retries and
+	// verification included, unlimited when unset. It does not bound the
+	// removal delayed by CleanupDelay, which happens once CleanUp returned.
+	OperationTimeout *metav1.Duration `json:"operationTimeout,omitempty"`
+	// CleanupDelay defers the removal of the challenge value by CleanUp, for
+	// ACME servers re-checking the record after validation. It defaults to
+	// zero, removing the value before CleanUp returns.
+	CleanupDelay *metav1.Duration `json:"cleanupDelay,omitempty"`
+	// Retry overrides the global retry policy of the Gandi API calls.
+	Retry retryConfig `json:"retry,omitempty"`
+	// Verification makes Present wait for the record to be resolvable.
+	Verification verificationConfig `json:"verification,omitempty"`
+	// CleanupVerification makes CleanUp wait for the value to no longer be
+	// resolvable, with the same settings as Verification.
+	CleanupVerification verificationConfig `json:"cleanupVerification,omitempty"`
+	// ConflictRetries is how many times a write is tried again, after
+	// reading the rrset again, when Gandi refuses a create because the rrset
+	// appeared or an update because it vanished since it was read. Defaults
+	// to DefaultConflictRetries.
+	ConflictRetries *int `json:"conflictRetries,omitempty"`
+	// NonFatalCleanup makes CleanUp succeed, with a warning, when the
+	// removal fails with a transient error once the retries are exhausted,
+	// leaving the value behind instead of having cert-manager retry.
+	NonFatalCleanup bool `json:"nonFatalCleanup,omitempty"`
+	// Zones lists the Gandi zones of the issuer. When set, the zone of a
+	// challenge is the longest of them its FQDN is under, instead of the zone
+	// resolved by cert-manager.
+	Zones []string `json:"zones,omitempty"`
+	// MergeOnChange reads the rrset again right before replacing or deleting
+	// it, and merges again when another writer changed it meanwhile.
+	MergeOnChange bool `json:"mergeOnChange,omitempty"`
+	// QuoteTXTValues sends the challenge value in double quotes, the zone
+	// file form, rather than bare. Values are matched in either form.
+	QuoteTXTValues bool `json:"quoteTXTValues,omitempty"`
+	// MetaMarker keeps a companion rrset recording the last update of each
+	// challenge rrset, see metaMarkerConfig.
+	MetaMarker metaMarkerConfig `json:"metaMarker,omitempty"`
+
+	// globalCredentials is set when the challenge has no config, see
+	// gandiDNSProviderSolver.loadConfig.
+	globalCredentials bool
+}
+
+// ttlFor returns the TTL configured for the zone domain, before clamping.
+func (cfg gandiDNSProviderConfig) ttlFor(domain string) int {
+	if suffix := matchZoneSuffix(cfg.ZoneTTLs, domain); suffix != "" {
+		return cfg.ZoneTTLs[suffix]
+	}
+	return cfg.TTL
+}
+
+// recordName returns the record name used for the API calls: name in
+// lowercase, the form Gandi stores names in, unless PreserveNameCase is set.
+func (cfg gandiDNSProviderConfig) recordName(name string) string {
+	if cfg.PreserveNameCase {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// gandiZone returns the Gandi name of the resolved zone domain.
+func (cfg gandiDNSProviderConfig) gandiZone(domain string) string {
+	for zone, name := range cfg.ZoneNames {
+		if strings.EqualFold(strings.TrimSuffix(zone, "."), domain) {
+			return strings.TrimSuffix(name, ".")
+		}
+	}
+	return domain
+}
+
+// challengePrefix returns ChallengePrefix or its default.
+func (cfg gandiDNSProviderConfig) challengePrefix() string {
+	if cfg.ChallengePrefix != "" {
+		return cfg.ChallengePrefix
+	}
+	return DefaultChallengePrefix
+}
+
+// conflictRetries returns ConflictRetries or its default.
+func (cfg gandiDNSProviderConfig) conflictRetries() int {
+	if cfg.ConflictRetries != nil {
+		return *cfg.ConflictRetries
+	}
+	return DefaultConflictRetries
+}
+
+// maxValues returns MaxValues or its default.
+func (cfg gandiDNSProviderConfig) maxValues() int {
+	if cfg.MaxValues > 0 {
+		return cfg.MaxValues
+	}
+	return DefaultMaxValues
+}
+
+// callTimeout returns override when set, Timeout otherwise, falling back to
+// the go-gandi default.
+func (cfg gandiDNSProviderConfig) callTimeout(override *metav1.Duration) time.Duration {
+	if override != nil && override.Duration > 0 {
+		return override.Duration
+	}
+	if cfg.Timeout != nil && cfg.Timeout.Duration > 0 {
+		return cfg.Timeout.Duration
+	}
+	return config.Timeout
+}
+
+// operationContext returns a context bounded by OperationTimeout, if set.
+func (cfg gandiDNSProviderConfig) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cfg.OperationTimeout != nil && cfg.OperationTimeout.Duration > 0 {
+		return context.WithTimeout(ctx, cfg.OperationTimeout.Duration)
+	}
+	return context.WithCancel(ctx)
+}
+
+// deadlineError wraps err with errOperationTimeout when the deadline of the
+// operation context ctx has passed, err being the symptom.
+func deadlineError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, errOperationTimeout) || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errOperationTimeout, err)
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -84,44 +483,177 @@ func (c *gandiDNSProviderSolver) Name() string {
 // This method should tolerate being called multiple times with the same value.
 // cert-manager itself will later perform a self check to ensure that the
 // solver has correctly configured the DNS provider.
-func (c *gandiDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error {
-	klog.V(6).Infof("call function Present: namespace=%s, zone=%s, fqdn=%s",
-		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN)
+func (c *gandiDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) (err error) {
+	defer func() { c.countOperation("present", ch.ResolvedZone, err) }()
+	audit := c.startAudit("present", ch)
+	defer func() { audit.end(err) }()
+	ctx := withCallLogger(withCorrelationID(context.Background(), audit.id))
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("call function Present", "namespace", ch.ResourceNamespace, "zone", ch.ResolvedZone, "fqdn", ch.ResolvedFQDN)
 
-	gandiClient, err := c.getGandiClient(ch.Config, ch.ResourceNamespace)
+	cfg, err := c.loadConfig(ch.Config)
 	if err != nil {
-		return fmt.Errorf("unable to get Gandi client: %v", err)
+		return fmt.Errorf("present: %v", err)
+	}
+	if c.skipSolving(ctx, ch.ResourceNamespace, cfg.callTimeout(cfg.PresentTimeout)) {
+		logger.Info("present: the namespace is labelled to be skipped, not presenting", "namespace", ch.ResourceNamespace, "label", c.skipLabel, "fqdn", ch.ResolvedFQDN)
+		return nil
 	}
 
-	challengeFQDN, domain := c.getDomainAndChallengeFQDN(ch)
-	klog.V(6).Infof("present: for challengeFQDN=%s, domain=%s", challengeFQDN, domain)
+	if err := checkFQDNDepth(ch.ResolvedFQDN, c.fqdnDepthLimit()); err != nil {
+		return fmt.Errorf("present: %v", err)
+	}
 
-	domainRecord, err := gandiClient.GetDomainRecordByNameAndType(domain, challengeFQDN, "TXT")
-	if err != nil && !strings.Contains(err.Error(), "404") {
-		return fmt.Errorf("present: pre: unable to check TXT record: %v", err)
+	challengeFQDN, domain, err := c.getDomainAndChallengeFQDN(ctx, ch, cfg.Zones)
+	if err != nil {
+		return fmt.Errorf("present: %v", err)
+	}
+	domain = cfg.gandiZone(domain)
+	challengeFQDN = cfg.recordName(challengeFQDN)
+	logger.V(6).Info("present: resolved the record", "challengeFQDN", challengeFQDN, "domain", domain)
+	if err := validateRecordName(challengeFQDN); err != nil {
+		return fmt.Errorf("present: %v", err)
+	}
+	if err := checkExpectedDomain(domain, cfg.ExpectedDomains); err != nil {
+		return fmt.Errorf("present: %v", err)
+	}
+	if err := checkPublicSuffix(ctx, domain, cfg.PublicSuffixCheck); err != nil {
+		return fmt.Errorf("present: %v", err)
+	}
+	checkChallengePrefix(ctx, challengeFQDN, domain, cfg.challengePrefix())
+	if !c.limiters.Allow(batchScope(ch)) {
+		rateLimitedTotal.Inc()
+		return fmt.Errorf("present: %w", errRateLimited)
 	}
-	klog.V(6).Infof("present: pre: domainRecord=%v", domainRecord)
 
-	recordVal := [...]string{ch.Key}
+	timeout := cfg.callTimeout(cfg.PresentTimeout)
+	ctx, cancel := cfg.operationContext(ctx)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "Present")
+	recordRrsetAttributes(span, domain, challengeFQDN)
+	defer func() { endSpan(span, err) }()
+	defer func() { err = deadlineError(ctx, err) }()
 
-	if domainRecord.RrsetName != "" && len(domainRecord.RrsetValues) > 0 {
-		resp, err := gandiClient.UpdateDomainRecordByNameAndType(domain, challengeFQDN, "TXT", GandiMinTtl, recordVal[:])
-		if err != nil {
-			return fmt.Errorf("present: unable to change TXT record: %v", err)
-		}
-		if resp.Code != 0 {
-			return fmt.Errorf("present: got code %d while trying to change TXT record: %v", resp.Code, domain)
-		}
-	} else {
-		resp, err := gandiClient.CreateDomainRecord(domain, challengeFQDN, "TXT", GandiMinTtl, recordVal[:])
-		if err != nil {
-			return fmt.Errorf("present: unable to create TXT record: %v", err)
-		}
-		if resp.Code != 0 {
-			return fmt.Errorf("present: got code %d while trying to create TXT record: %v", resp.Code, domain)
+	// A removal delayed by cleanupDelay must not take the value away again.
+	if err := c.cancelDelayedCleanup(ctx, cleanupKey(batchScope(ch), domain, challengeFQDN, ch.Key)); err != nil {
+		return fmt.Errorf("present: %w", err)
+	}
+
+	gandiClient, err := c.getGandiClient(ctx, cfg, ch.ResourceNamespace, domain, timeout)
+	if err != nil {
+		return fmt.Errorf("present: unable to get Gandi client: %w", err)
+	}
+	target, err := challengeTarget(gandiClient, cfg, domain, challengeFQDN)
+	if err != nil {
+		// The challenge record is then written as usual.
+		logger.Info("present: unable to check the CNAME target, writing the key at the name itself", "challengeFQDN", challengeFQDN, "domain", domain, "err", err)
+	} else if target != challengeFQDN {
+		logger.V(6).Info("present: the name is a CNAME, writing the key at its target", "challengeFQDN", challengeFQDN, "domain", domain, "target", target)
+	}
+	if err := c.applyChallenge(ctx, gandiClient, cfg, batchScope(ch), domain, target, ch.Key); err != nil {
+		return err
+	}
+	if target != challengeFQDN {
+		c.holdCNAMETarget(cleanupKey(batchScope(ch), domain, target, ch.Key), challengeFQDN)
+	}
+	c.syncMetaMarker(ctx, gandiClient, cfg, domain, target)
+	lastSuccessTimestamp.SetToCurrentTime()
+
+	if cfg.Verification.Enabled {
+		return c.verifyPropagation(ctx, cfg.Verification, ch.ResolvedZone, ch.ResolvedFQDN, ch.Key)
+	}
+	return nil
+}
+
+// withStop returns a copy of ctx canceled once the webhook is shutting down,
+// so that long waits return promptly.
+func (c *gandiDNSProviderSolver) withStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if c.stopCh == nil {
+		return ctx, cancel
+	}
+	stopCh := c.stopCh
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-done:
 		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel()
+	}
+}
+
+// retryDefaults returns the global retry policy.
+func (c *gandiDNSProviderSolver) retryDefaults() retryPolicy {
+	if c.retry != nil {
+		return *c.retry
+	}
+	return defaultRetryPolicy
+}
+
+// cachedZonesLimit returns the number of zones each per-zone cache holds.
+func (c *gandiDNSProviderSolver) cachedZonesLimit() int {
+	if c.maxCachedZones > 0 {
+		return c.maxCachedZones
 	}
+	return DefaultMaxCachedZones
+}
 
+// fqdnDepthLimit returns the maximum number of labels of a challenge FQDN.
+func (c *gandiDNSProviderSolver) fqdnDepthLimit() int {
+	if c.maxFQDNDepth > 0 {
+		return c.maxFQDNDepth
+	}
+	return DefaultMaxFQDNDepth
+}
+
+// secretSizeLimit returns the maximum size of a Personal Access Token.
+func (c *gandiDNSProviderSolver) secretSizeLimit() int {
+	if c.maxSecretSize > 0 {
+		return c.maxSecretSize
+	}
+	return DefaultMaxSecretSize
+}
+
+// verifyPropagation waits until fqdn of zone resolves to key, as configured
+// by v, within the operation context ctx.
+func (c *gandiDNSProviderSolver) verifyPropagation(ctx context.Context, v verificationConfig, zone, fqdn, key string) error {
+	ctx, cancel := c.withStop(ctx)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, v.timeout())
+	defer cancelTimeout()
+
+	resolver, err := c.verificationResolver(ctx, v, zone)
+	if err != nil {
+		return fmt.Errorf("present: verify: %w: %v", errNotPropagated, err)
+	}
+
+	if err := waitForTXT(ctx, v.withQuorum(resolver, true), fqdn, key, v.schedule(), v.maxServfails()); err != nil {
+		return fmt.Errorf("present: verify: %w: %w", errNotPropagated, err)
+	}
+	return nil
+}
+
+// verifyRemoval waits until key is no longer resolvable at fqdn, as dictated
+// by v, within the operation context ctx.
+func (c *gandiDNSProviderSolver) verifyRemoval(ctx context.Context, v verificationConfig, zone, fqdn, key string) error {
+	ctx, cancel := c.withStop(ctx)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, v.timeout())
+	defer cancelTimeout()
+
+	resolver, err := c.verificationResolver(ctx, v, zone)
+	if err != nil {
+		return fmt.Errorf("cleanup: verify: %w: %v", errNotRemoved, err)
+	}
+
+	if err := waitForTXTGone(ctx, v.withQuorum(resolver, false), fqdn, key, v.schedule(), v.maxServfails()); err != nil {
+		return fmt.Errorf("cleanup: verify: %w: %w", errNotRemoved, err)
+	}
 	return nil
 }
 
@@ -131,32 +663,300 @@ func (c *gandiDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error {
 // value provided on the ChallengeRequest should be cleaned up.
 // This is in order to facilitate multiple DNS validations for the same domain
 // concurrently.
-func (c *gandiDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
-	klog.V(6).Infof("call function CleanUp: namespace=%s, zone=%s, fqdn=%s",
-		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN)
+func (c *gandiDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) (err error) {
+	defer func() { c.countOperation("cleanup", ch.ResolvedZone, err) }()
+	audit := c.startAudit("cleanup", ch)
+	defer func() { audit.end(err) }()
+	ctx := withCallLogger(withCorrelationID(context.Background(), audit.id))
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("call function CleanUp", "namespace", ch.ResourceNamespace, "zone", ch.ResolvedZone, "fqdn", ch.ResolvedFQDN)
 
-	gandiClient, err := c.getGandiClient(ch.Config, ch.ResourceNamespace)
+	cfg, err := c.loadConfig(ch.Config)
 	if err != nil {
-		return fmt.Errorf("cleanup: unable to get Gandi client: %v", err)
+		return fmt.Errorf("cleanup: %v", err)
+	}
+	if c.skipSolving(ctx, ch.ResourceNamespace, cfg.callTimeout(cfg.CleanupTimeout)) {
+		logger.Info("cleanup: the namespace is labelled to be skipped, not cleaning up", "namespace", ch.ResourceNamespace, "label", c.skipLabel, "fqdn", ch.ResolvedFQDN)
+		return nil
 	}
 
-	challengeFQDN, domain := c.getDomainAndChallengeFQDN(ch)
+	if err := checkFQDNDepth(ch.ResolvedFQDN, c.fqdnDepthLimit()); err != nil {
+		return fmt.Errorf("cleanup: %v", err)
+	}
 
-	domainRecord, err := gandiClient.GetDomainRecordByNameAndType(domain, challengeFQDN, "TXT")
-	if err != nil && !strings.Contains(err.Error(), "404") {
-		return fmt.Errorf("cleanup: pre: unable to check TXT record: %v", err)
+	challengeFQDN, domain, err := c.getDomainAndChallengeFQDN(ctx, ch, cfg.Zones)
+	if err != nil {
+		return fmt.Errorf("cleanup: %v", err)
+	}
+	domain = cfg.gandiZone(domain)
+	challengeFQDN = cfg.recordName(challengeFQDN)
+	if err := validateRecordName(challengeFQDN); err != nil {
+		return fmt.Errorf("cleanup: %v", err)
+	}
+	if err := checkExpectedDomain(domain, cfg.ExpectedDomains); err != nil {
+		return fmt.Errorf("cleanup: %v", err)
+	}
+	if err := checkPublicSuffix(ctx, domain, cfg.PublicSuffixCheck); err != nil {
+		return fmt.Errorf("cleanup: %v", err)
+	}
+	checkChallengePrefix(ctx, challengeFQDN, domain, cfg.challengePrefix())
+	if !c.limiters.Allow(batchScope(ch)) {
+		rateLimitedTotal.Inc()
+		return fmt.Errorf("cleanup: %w", errRateLimited)
+	}
+
+	timeout := cfg.callTimeout(cfg.CleanupTimeout)
+	delayed := cfg.CleanupDelay != nil && cfg.CleanupDelay.Duration > 0
+	// The delayed removal outlives CleanUp, and so its deadline.
+	cancel := context.CancelFunc(func() {})
+	if !delayed {
+		ctx, cancel = cfg.operationContext(ctx)
+	}
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "CleanUp")
+	recordRrsetAttributes(span, domain, challengeFQDN)
+	defer func() { endSpan(span, err) }()
+	defer func() { err = deadlineError(ctx, err) }()
+
+	gandiClient, err := c.getGandiClient(ctx, cfg, ch.ResourceNamespace, domain, timeout)
+	if err != nil {
+		return fmt.Errorf("cleanup: unable to get Gandi client: %w", err)
+	}
+
+	target, err := challengeTarget(gandiClient, cfg, domain, challengeFQDN)
+	if err != nil {
+		return fmt.Errorf("cleanup: unable to check the CNAME target of challengeFQDN=%s, domain=%s: %w", challengeFQDN, domain, err)
+	}
+
+	scope := batchScope(ch)
+	remove := func() error {
+		if target != challengeFQDN && c.releaseCNAMETarget(cleanupKey(scope, domain, target, ch.Key), challengeFQDN) {
+			logger.V(6).Info("cleanup: the key at the CNAME target is still used by another challenge, leaving it",
+				"challengeFQDN", challengeFQDN, "domain", domain, "target", target)
+			return nil
+		}
+		if err := c.removeChallenge(ctx, gandiClient, cfg, scope, domain, target, ch.Key); err != nil {
+			if cfg.NonFatalCleanup && (isRetriable(err) || errors.Is(err, errOperationTimeout)) {
+				logger.Info("cleanup: leaving the value behind as nonFatalCleanup is set", "challengeFQDN", target, "domain", domain, "err", err)
+				return nil
+			}
+			return err
+		}
+		c.syncMetaMarker(ctx, gandiClient, cfg, domain, target)
+		lastSuccessTimestamp.SetToCurrentTime()
+		if cfg.CleanupVerification.Enabled {
+			return c.verifyRemoval(ctx, cfg.CleanupVerification, ch.ResolvedZone, ch.ResolvedFQDN, ch.Key)
+		}
+		return nil
+	}
+	if delayed {
+		c.delayCleanup(ctx, cleanupKey(scope, domain, challengeFQDN, ch.Key), cfg.CleanupDelay.Duration, remove)
+		return nil
 	}
-	klog.V(6).Infof("cleanup: going to delete %v", domainRecord)
+	return remove()
+}
+
+// batchScope groups the challenges sharing a namespace and an issuer config,
+// which resolve to the same credentials and settings.
+func batchScope(ch *v1alpha1.ChallengeRequest) string {
+	scope := ch.ResourceNamespace
+	if ch.Config != nil {
+		scope += "\x00" + string(ch.Config.Raw)
+	}
+	return scope
+}
+
+// challengeTarget returns, with CheckCNAMETarget, the name of domain that
+// name is a CNAME to, where the challenge value is written instead, and name
+// otherwise.
+func challengeTarget(gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name string) (string, error) {
+	if !cfg.CheckCNAMETarget {
+		return name, nil
+	}
+	target, err := cnameTarget(gandiClient, domain, name)
+	if err != nil {
+		return name, err
+	}
+	if target == "" || strings.EqualFold(target, name) {
+		return name, nil
+	}
+	return target, nil
+}
 
-	if domainRecord.RrsetName != "" && len(domainRecord.RrsetValues) > 0 {
-		klog.V(6).Infof("cleanup: deleting challengeFQDN=%s, domain=%s", challengeFQDN, domain)
-		err := gandiClient.DeleteDomainRecord(domain, challengeFQDN, "TXT")
+// holdCNAMETarget records that the challenge of name wrote its value at the
+// CNAME target identified by k.
+func (c *gandiDNSProviderSolver) holdCNAMETarget(k, name string) {
+	c.cnameMu.Lock()
+	defer c.cnameMu.Unlock()
+	if c.cnameHolders == nil {
+		c.cnameHolders = map[string]map[string]struct{}{}
+	}
+	if c.cnameHolders[k] == nil {
+		c.cnameHolders[k] = map[string]struct{}{}
+	}
+	c.cnameHolders[k][name] = struct{}{}
+}
+
+// releaseCNAMETarget drops the hold of the challenge of name on k, and
+// reports whether other challenges still hold the value.
+func (c *gandiDNSProviderSolver) releaseCNAMETarget(k, name string) bool {
+	c.cnameMu.Lock()
+	defer c.cnameMu.Unlock()
+	delete(c.cnameHolders[k], name)
+	if len(c.cnameHolders[k]) > 0 {
+		return true
+	}
+	delete(c.cnameHolders, k)
+	return false
+}
+
+// applyChallenge adds key to the rrset, batched with the other operations on
+// it when batching is enabled and holding the lock of the rrset otherwise.
+func (c *gandiDNSProviderSolver) applyChallenge(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, scope, domain, name, key string) error {
+	// forceReplace drops the other values, which does not combine with them.
+	if c.batchWindow > 0 && !cfg.ForceReplace {
+		return c.submit(ctx, c.batchWindow, gandiClient, cfg, scope, domain, name, rrsetOp{key: key})
+	}
+	unlock, err := c.lockRrset(ctx, domain, name)
+	if err != nil {
+		return fmt.Errorf("present: %w", err)
+	}
+	defer unlock()
+	_, err = applyChallenge(ctx, gandiClient, cfg, domain, name, key)
+	return err
+}
+
+// removeChallenge removes key from the rrset, like applyChallenge.
+func (c *gandiDNSProviderSolver) removeChallenge(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, scope, domain, name, key string) error {
+	remove := func() error {
+		if c.batchWindow > 0 {
+			return c.submit(ctx, c.batchWindow, gandiClient, cfg, scope, domain, name, rrsetOp{key: key, remove: true})
+		}
+		unlock, err := c.lockRrset(ctx, domain, name)
 		if err != nil {
-			return fmt.Errorf("cleanup: unable to remove TXT record: %v", err)
+			return fmt.Errorf("cleanup: %w", err)
 		}
+		defer unlock()
+		_, err = removeChallenge(ctx, gandiClient, cfg, domain, name, key)
+		return err
+	}
+	if !c.coalesceCleanups {
+		return remove()
 	}
+	err, shared := c.cleanups.Do(cleanupKey(scope, domain, name, key), remove)
+	if shared {
+		klog.FromContext(ctx).V(6).Info("cleanup: joined the removal in flight of the same value", "challengeFQDN", name, "domain", domain)
+	}
+	return err
+}
 
-	return nil
+// cleanupKey identifies the value of key in the rrset name of domain, for
+// the challenges of scope.
+func cleanupKey(scope, domain, name, key string) string {
+	return scope + "\x00" + rrsetKey(domain, name) + "\x00" + key
+}
+
+// pendingCleanup is a removal deferred by delayCleanup.
+type pendingCleanup struct {
+	// canceled is closed when the value is presented again before the
+	// removal started.
+	canceled chan struct{}
+	// done is closed once the removal is over or canceled.
+	done    chan struct{}
+	started bool
+}
+
+// delayCleanup runs remove in the background once delay has elapsed, or as
+// soon as the webhook is shutting down so that no value is left behind. A
+// Present of the value identified by k in the meantime cancels it, see
+// cancelDelayedCleanup. Errors can only be logged, as CleanUp has already
+// returned.
+func (c *gandiDNSProviderSolver) delayCleanup(ctx context.Context, k string, delay time.Duration, remove func() error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("cleanup: delaying removal", "delay", delay)
+	p := &pendingCleanup{canceled: make(chan struct{}), done: make(chan struct{})}
+	c.pendingMu.Lock()
+	if previous := c.pendingCleanups[k]; previous != nil && !previous.started {
+		// The latest CleanUp sets the delay.
+		close(previous.canceled)
+	}
+	if c.pendingCleanups == nil {
+		c.pendingCleanups = map[string]*pendingCleanup{}
+	}
+	c.pendingCleanups[k] = p
+	c.pendingMu.Unlock()
+
+	c.delayedCleanups.Add(1)
+	go func() {
+		defer c.delayedCleanups.Done()
+		defer close(p.done)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.stopCh:
+			logger.V(6).Info("cleanup: shutting down, removing now")
+		case <-p.canceled:
+		}
+
+		c.pendingMu.Lock()
+		if c.pendingCleanups[k] != p {
+			c.pendingMu.Unlock()
+			return
+		}
+		p.started = true
+		c.pendingMu.Unlock()
+		defer func() {
+			c.pendingMu.Lock()
+			delete(c.pendingCleanups, k)
+			c.pendingMu.Unlock()
+		}()
+		if err := remove(); err != nil {
+			logger.Error(err, "delayed removal failed")
+		}
+	}()
+}
+
+// cancelDelayedCleanup cancels the delayed removal of the value identified
+// by k, if any. A removal already running is waited for, so that the value
+// is written again after it.
+func (c *gandiDNSProviderSolver) cancelDelayedCleanup(ctx context.Context, k string) error {
+	c.pendingMu.Lock()
+	p := c.pendingCleanups[k]
+	if p == nil {
+		c.pendingMu.Unlock()
+		return nil
+	}
+	if !p.started {
+		delete(c.pendingCleanups, k)
+		close(p.canceled)
+		c.pendingMu.Unlock()
+		klog.FromContext(ctx).V(6).Info("present: the value is presented again, canceled its delayed removal")
+		return nil
+	}
+	c.pendingMu.Unlock()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the delayed removal of the value: %w", ctx.Err())
+	}
+}
+
+// waitDelayedCleanups waits up to timeout for the delayed removals to be
+// over, and reports whether they are.
+func (c *gandiDNSProviderSolver) waitDelayedCleanups(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.delayedCleanups.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 // Initialize will be called when the webhook first starts.
@@ -168,52 +968,462 @@ func (c *gandiDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
 // provider accounts.
 // The stopCh can be used to handle early termination of the webhook, in cases
 // where a SIGTERM or similar signal is sent to the webhook process.
-func (c *gandiDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, _ <-chan struct{}) error {
+func (c *gandiDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
 	klog.V(6).Infof("call function Initialize")
-	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	c.stopCh = stopCh
+	if c.stopTracing != nil {
+		go func() {
+			<-stopCh
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := c.stopTracing(ctx); err != nil {
+				klog.Warningf("tracing: unable to flush spans: %v", err)
+			}
+		}()
+	}
+	maxDepth, err := envInt(MaxFQDNDepthEnv, DefaultMaxFQDNDepth)
+	if err != nil {
+		return err
+	}
+	if maxDepth <= 0 {
+		return fmt.Errorf("%s must be positive, got %d", MaxFQDNDepthEnv, maxDepth)
+	}
+	c.maxFQDNDepth = maxDepth
+	maxSecretSize, err := envInt(MaxSecretSizeEnv, DefaultMaxSecretSize)
+	if err != nil {
+		return err
+	}
+	if maxSecretSize <= 0 {
+		return fmt.Errorf("%s must be positive, got %d", MaxSecretSizeEnv, maxSecretSize)
+	}
+	c.maxSecretSize = maxSecretSize
+	maxZones, err := envInt(MaxCachedZonesEnv, DefaultMaxCachedZones)
+	if err != nil {
+		return err
+	}
+	if maxZones <= 0 {
+		return fmt.Errorf("%s must be positive, got %d", MaxCachedZonesEnv, maxZones)
+	}
+	c.maxCachedZones = maxZones
+	c.nameForms.setZoneLimit(maxZones)
+	c.nameservers.setZoneLimit(maxZones)
+	batchWindow, err := envDuration(BatchWindowEnv, 0)
+	if err != nil {
+		return err
+	}
+	if batchWindow < 0 {
+		return fmt.Errorf("%s must not be negative, got %v", BatchWindowEnv, batchWindow)
+	}
+	c.batchWindow = batchWindow
+	batchWorkers, err := envInt(BatchWorkersEnv, DefaultBatchWorkers)
+	if err != nil {
+		return err
+	}
+	if batchWorkers <= 0 {
+		return fmt.Errorf("%s must be positive, got %d", BatchWorkersEnv, batchWorkers)
+	}
+	if batchWindow > 0 {
+		c.startBatchWorkers(batchWorkers, stopCh)
+	}
+	coalesceCleanups, err := envBool(CoalesceCleanupsEnv, false)
+	if err != nil {
+		return err
+	}
+	c.coalesceCleanups = coalesceCleanups
+	if c.metricsZoneLabel, err = envBool(MetricsZoneLabelEnv, false); err != nil {
+		return err
+	}
+	c.limiters.limit, c.limiters.burst, err = issuerLimitersFromEnv()
+	if err != nil {
+		return err
+	}
+	retry, err := retryPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	c.retry = &retry
+	zoneConcurrency, err := envInt(ZoneConcurrencyEnv, 0)
+	if err != nil {
+		return err
+	}
+	if zoneConcurrency < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", ZoneConcurrencyEnv, zoneConcurrency)
+	}
+	c.zoneSlots.limit = zoneConcurrency
+
+	var statusNamespace, statusName string
+	if ref := os.Getenv(ConfigStatusConfigMapEnv); ref != "" {
+		if statusNamespace, statusName, err = parseConfigMapRef(ConfigStatusConfigMapEnv, ref); err != nil {
+			return err
+		}
+	}
+
+	kubeConfig, err := kubeClientConfigFromEnv(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+	// Build the HTTP client once so that every request of the clientset
+	// shares the same transport and connection pool.
+	httpClient, err := rest.HTTPClientFor(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("unable to get k8s HTTP client: %v", err)
+	}
+	cl, err := kubernetes.NewForConfigAndClient(kubeConfig, httpClient)
 	if err != nil {
 		return fmt.Errorf("unable to get k8s client: %v", err)
 	}
 	c.client = cl
+	if err := checkKubeAPIFromEnv(cl); err != nil {
+		return err
+	}
+
+	secrets, err := newSecretCacheFromEnv(cl, stopCh)
+	if err != nil {
+		return err
+	}
+	c.secrets = secrets
+
+	if c.credentialsConfigMap != "" {
+		namespace, name, err := parseConfigMapRef(CredentialsConfigMapEnv, c.credentialsConfigMap)
+		if err != nil {
+			return err
+		}
+		if c.zoneRoutes, err = newCredentialsConfigMapLister(cl, namespace, name, stopCh); err != nil {
+			return err
+		}
+	}
+
+	if statusName != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		c.publishEffectiveSettings(ctx, statusNamespace, statusName)
+	}
 	return nil
 }
 
-// getGandiClient instantiates a go-gandi livedns client
-// This replaces the previous 3 smaller methods, and makes caller functions cleaner
-func (c *gandiDNSProviderSolver) getGandiClient(cfgJSON *extapi.JSON, namespace string) (*livedns.LiveDNS, error) {
+// checkKubeAPIFromEnv checks that the Kubernetes API of client answers, as
+// configured by KubeAPICheckEnv.
+func checkKubeAPIFromEnv(client kubernetes.Interface) error {
+	mode := os.Getenv(KubeAPICheckEnv)
+	switch mode {
+	case "", KubeAPICheckOff:
+		return nil
+	case KubeAPICheckWarn, KubeAPICheckFail:
+	default:
+		return fmt.Errorf("invalid %s %q: must be %q, %q or %q", KubeAPICheckEnv, mode, KubeAPICheckOff, KubeAPICheckWarn, KubeAPICheckFail)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// /version is readable by any authenticated client.
+	err := client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+	if err == nil {
+		klog.V(6).Infof("the Kubernetes API is reachable")
+		return nil
+	}
+	if mode == KubeAPICheckFail {
+		return fmt.Errorf("the Kubernetes API is not reachable: %v", err)
+	}
+	klog.Warningf("the Kubernetes API is not reachable, credential Secrets cannot be read until it is: %v", err)
+	return nil
+}
+
+// kubeClientConfigFromEnv returns a copy of cfg with the client-side rate
+// limits set from KubeAPIQPSEnv and KubeAPIBurstEnv.
+func kubeClientConfigFromEnv(cfg *rest.Config) (*rest.Config, error) {
+	qps, err := envFloat(KubeAPIQPSEnv, DefaultKubeAPIQPS)
+	if err != nil {
+		return nil, err
+	}
+	burst, err := envInt(KubeAPIBurstEnv, DefaultKubeAPIBurst)
+	if err != nil {
+		return nil, err
+	}
+	if qps <= 0 || burst <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive, got %v and %d", KubeAPIQPSEnv, KubeAPIBurstEnv, qps, burst)
+	}
+
+	kubeConfig := rest.CopyConfig(cfg)
+	kubeConfig.QPS = float32(qps)
+	kubeConfig.Burst = burst
+	klog.V(6).Infof("k8s client rate limit: qps=%v, burst=%d", qps, burst)
+	return kubeConfig, nil
+}
+
+// loadConfig decodes the solver configuration from the challenge request
+func loadConfig(cfgJSON *extapi.JSON) (gandiDNSProviderConfig, error) {
 	cfg := gandiDNSProviderConfig{}
 	// handle the 'base case' where no configuration has been provided
 	if cfgJSON == nil {
-		return nil, fmt.Errorf("no configuration provided: %v", cfgJSON)
+		return cfg, fmt.Errorf("no configuration provided and %s is not set", GlobalPATEnv)
 	}
 	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
-		return nil, fmt.Errorf("error decoding solver config: %v", err)
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	cfg.applyDefaults()
+	return cfg, nil
+}
+
+// loadConfig decodes the solver config of a challenge. Without config, the
+// defaults apply and the global credentials are used, when set.
+func (c *gandiDNSProviderSolver) loadConfig(cfgJSON *extapi.JSON) (gandiDNSProviderConfig, error) {
+	if cfgJSON == nil && c.globalCredentials != nil {
+		cfg := gandiDNSProviderConfig{globalCredentials: true}
+		cfg.applyDefaults()
+		return cfg, nil
+	}
+	return loadConfig(cfgJSON)
+}
+
+// Validate rejects configs with values out of their supported ranges or
+// with conflicting fields. It holds every check of the solver config but the
+// retry policy, which can only be checked once merged with the global one.
+func (cfg gandiDNSProviderConfig) Validate() error {
+	for name, d := range map[string]*metav1.Duration{
+		"timeout":          cfg.Timeout,
+		"presentTimeout":   cfg.PresentTimeout,
+		"cleanupTimeout":   cfg.CleanupTimeout,
+		"operationTimeout": cfg.OperationTimeout,
+		"cleanupDelay":     cfg.CleanupDelay,
+	} {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("invalid %s %v: must not be negative", name, d.Duration)
+		}
 	}
+	if cfg.PATSecretSelector != nil {
+		if cfg.PATSecretRef.Name != "" {
+			return fmt.Errorf("PATSecretRef.name and PATSecretSelector are mutually exclusive")
+		}
+		if _, err := metav1.LabelSelectorAsSelector(cfg.PATSecretSelector); err != nil {
+			return fmt.Errorf("invalid PATSecretSelector: %v", err)
+		}
+	}
+	if p := cfg.ChallengePrefix; p != "" && (!strings.HasPrefix(p, "_") || validateRecordName(p) != nil || strings.Contains(p, ".")) {
+		return fmt.Errorf("invalid challengePrefix %q: must be a single label starting with an underscore", p)
+	}
+	if cfg.MaxValues < 0 {
+		return fmt.Errorf("invalid maxValues %d: must not be negative", cfg.MaxValues)
+	}
+	for zone, name := range cfg.ZoneNames {
+		if strings.TrimSuffix(zone, ".") == "" || strings.TrimSuffix(name, ".") == "" {
+			return fmt.Errorf("invalid zoneNames entry %q: %q: zones must not be empty", zone, name)
+		}
+	}
+	for zone, ttl := range cfg.ZoneTTLs {
+		if ttl < GandiMinTtl {
+			return fmt.Errorf("invalid zoneTTLs entry %q: TTL %d is below the Gandi minimum of %d", zone, ttl, GandiMinTtl)
+		}
+	}
+	switch cfg.PublicSuffixCheck {
+	case "", PublicSuffixWarn, PublicSuffixError, PublicSuffixOff:
+	default:
+		return fmt.Errorf("invalid publicSuffixCheck %q: must be %q, %q or %q", cfg.PublicSuffixCheck, PublicSuffixWarn, PublicSuffixError, PublicSuffixOff)
+	}
+	if cfg.ConflictRetries != nil && (*cfg.ConflictRetries < 0 || *cfg.ConflictRetries > MaxRetries) {
+		return fmt.Errorf("invalid conflictRetries %d: must be between 0 and %d", *cfg.ConflictRetries, MaxRetries)
+	}
+	for _, zone := range cfg.Zones {
+		if normalized, err := normalizeFQDN(zone); err != nil || strings.Trim(normalized, ".") == "" {
+			return fmt.Errorf("invalid zone %q in zones", zone)
+		}
+	}
+	if err := cfg.MetaMarker.validate(); err != nil {
+		return err
+	}
+	if err := validateRecordNameForm(cfg.RecordNameForm); err != nil {
+		return err
+	}
+	if err := cfg.Retry.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Verification.validate(); err != nil {
+		return err
+	}
+	if err := cfg.CleanupVerification.validate(); err != nil {
+		return fmt.Errorf("cleanupVerification: %v", err)
+	}
+	return nil
+}
 
-	secretName := cfg.PATSecretRef.LocalObjectReference.Name
+// applyDefaults fills in the defaults of the unset fields, so that the
+// config reads as it is applied. Fields whose default depends on the zone or
+// on the global settings are left unset.
+func (cfg *gandiDNSProviderConfig) applyDefaults() {
+	if cfg.PATSecretRef.Key == "" && cfg.PATSecretRef.Name != "" {
+		cfg.PATSecretRef.Key = DefaultPATSecretKey
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = GandiMinTtl
+	}
+	cfg.MaxValues = cfg.maxValues()
+	cfg.ChallengePrefix = cfg.challengePrefix()
+	if cfg.Timeout == nil || cfg.Timeout.Duration == 0 {
+		cfg.Timeout = &metav1.Duration{Duration: config.Timeout}
+	}
+}
 
-	klog.V(6).Infof("try to load secret `%s` with key `%s`", secretName, cfg.PATSecretRef.Key)
+// getGandiClient instantiates a Gandi livedns client
+// This replaces the previous 3 smaller methods, and makes caller functions cleaner
+// The credentials come from c.credentials, see secretCredentialProvider for
+// the default. Each Kubernetes and Gandi API call is bound to timeout, and
+// all of them, retries included, to the operation context ctx.
+func (c *gandiDNSProviderSolver) getGandiClient(ctx context.Context, cfg gandiDNSProviderConfig, namespace, domain string, timeout time.Duration) (liveDNSClient, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(min(timeout, time.Until(deadline)), time.Millisecond)
+	}
+	provider := c.credentials
+	switch {
+	case cfg.globalCredentials && c.globalCredentials != nil:
+		provider = staticCredentialProvider(*c.globalCredentials)
+	case provider == nil:
+		provider = secretCredentialProvider{solver: c}
+	}
+	client, pat, err := c.newClientFrom(ctx, provider, cfg, namespace, domain, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := provider.(secretCredentialProvider); !ok || c.secrets == nil || !c.secrets.liveFallback {
+		return client, nil
+	}
+	return staleSecretLiveDNS{
+		liveDNSClient: client,
+		pat:           pat,
+		refresh: func() (liveDNSClient, string, error) {
+			return c.newClientFrom(withLiveSecrets(ctx), provider, cfg, namespace, domain, timeout)
+		},
+		state: &staleSecretState{},
+		ctx:   ctx,
+	}, nil
+}
 
-	sec, err := c.client.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+// newClientFrom builds the client of getGandiClient from the credentials of
+// provider, and returns it with the token it uses.
+func (c *gandiDNSProviderSolver) newClientFrom(ctx context.Context, provider credentialProvider, cfg gandiDNSProviderConfig, namespace, domain string, timeout time.Duration) (liveDNSClient, string, error) {
+	credsCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	creds, err := provider.Credentials(credsCtx, cfg, namespace, domain)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get secret `%s`; %v", secretName, err)
+		return nil, "", err
+	}
+
+	// The size is checked first, so that a huge value is not copied around
+	// nor logged.
+	if limit := c.secretSizeLimit(); len(creds.PAT) > limit {
+		return nil, "", fmt.Errorf("the Personal Access Token for domain=%s in namespace %s is %d bytes long, more than the maximum of %d (%s): check that the Secret key holds the token itself",
+			domain, namespace, len(creds.PAT), limit, MaxSecretSizeEnv)
+	}
+	// Secrets written from a shell often end with a newline, which Gandi
+	// would reject as part of the token.
+	pat := strings.TrimSpace(creds.PAT)
+	if len(pat) != len(creds.PAT) {
+		klog.FromContext(ctx).Info("trimmed whitespace characters around the Personal Access Token", "characters", len(creds.PAT)-len(pat), "domain", domain)
+	}
+	secrets := []string{pat}
+	if cfg.DecodeBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(pat)
+		if err != nil {
+			return nil, "", fmt.Errorf("decodeBase64 is set but the Personal Access Token is not valid base64: %v", err)
+		}
+		pat = strings.TrimSpace(string(decoded))
+		secrets = append(secrets, pat)
+	}
+	// An empty token would only be rejected by Gandi with a confusing
+	// authentication error.
+	if pat == "" {
+		return nil, "", fmt.Errorf("credential is empty: the Personal Access Token for domain=%s in namespace %s holds no characters besides whitespace", domain, namespace)
+	}
+	gandiConfig := config.Config{
+		PersonalAccessToken: pat,
+		SharingID:           creds.SharingID,
+		APIURL:              c.apiURL,
+		Timeout:             timeout,
+	}
+
+	retry := cfg.Retry.policy(c.retryDefaults())
+	if err := retry.validate(); err != nil {
+		return nil, "", err
 	}
 
-	secBytes, ok := sec.Data[cfg.PATSecretRef.Key]
-	if !ok {
-		return nil, fmt.Errorf("key %q not found in secret \"%s/%s\"", cfg.PATSecretRef.Key,
-			cfg.PATSecretRef.LocalObjectReference.Name, namespace)
+	newClient := c.newLiveDNSClient
+	if newClient == nil {
+		newClient = func(cfg config.Config) liveDNSClient { return newAPILiveDNS(ctx, cfg) }
 	}
 
-	pat := string(secBytes)
-	gandiConfig := config.Config{PersonalAccessToken: pat}
+	// Spans only ever see redacted errors.
+	return tracingLiveDNS{
+		liveDNSClient: redactingLiveDNS{
+			liveDNSClient: retryingLiveDNS{
+				liveDNSClient: withNameForm(ctx, recoveringLiveDNS{liveDNSClient: newClient(gandiConfig), ctx: ctx}, cfg.RecordNameForm, &c.nameForms, nameFormScope(pat, creds.SharingID)),
+				policy:        retry,
+				stop:          c.stopCh,
+				ctx:           ctx,
+			},
+			secrets: secrets,
+		},
+		ctx: ctx,
+	}, pat, nil
+}
+
+// effectiveTTL returns the TTL to use for the challenge record: GandiMinTtl
+// when unset, and never less than GandiMinTtl, since Gandi rejects lower values.
+func effectiveTTL(ttl int) int {
+	if ttl < GandiMinTtl {
+		return GandiMinTtl
+	}
+	return ttl
+}
 
-	liveDNSClient := gandi.NewLiveDNSClient(gandiConfig)
+// reportClampedTTL logs and counts a configured ttl raised by effectiveTTL.
+// It is called once per challenge written, not for each computation of the
+// TTL of its rrset.
+func reportClampedTTL(ctx context.Context, ttl int) {
+	if ttl == 0 || ttl >= GandiMinTtl {
+		return
+	}
+	klog.FromContext(ctx).Info("configured TTL is below the Gandi minimum, using the minimum instead", "ttl", ttl, "minimum", GandiMinTtl)
+	ttlClampedTotal.Inc()
+}
 
-	return liveDNSClient, nil
+// getDomainAndChallengeFQDN returns the record name relative to its zone and
+// the zone, as computed by c.recordName or defaultRecordName from the
+// resolved names normalized by normalizeFQDN. With a non-empty zones list,
+// the zone is instead the longest of them the FQDN is under, see
+// zoneFromList.
+func (c *gandiDNSProviderSolver) getDomainAndChallengeFQDN(ctx context.Context, ch *v1alpha1.ChallengeRequest, zones []string) (string, string, error) {
+	fqdn, err := normalizeFQDN(ch.ResolvedFQDN)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid resolved FQDN: %v", err)
+	}
+	zone, err := normalizeFQDN(ch.ResolvedZone)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid resolved zone: %v", err)
+	}
+	normalized := *ch
+	normalized.ResolvedFQDN, normalized.ResolvedZone = fqdn, zone
+
+	recordName := c.recordName
+	if recordName == nil {
+		recordName = defaultRecordName
+	}
+	if len(zones) > 0 {
+		recordName = func(ch *v1alpha1.ChallengeRequest) (string, string) {
+			return zoneFromList(ch.ResolvedFQDN, zones)
+		}
+	}
+	entry, domain := recordName(&normalized)
+	if domain == "" && len(zones) > 0 {
+		return "", "", fmt.Errorf("FQDN %q is not under any of the configured zones %v", ch.ResolvedFQDN, zones)
+	}
+	if c.debugFQDN {
+		klog.FromContext(ctx).Info("debug fqdn", "resolvedFQDN", ch.ResolvedFQDN, "resolvedZone", ch.ResolvedZone, "entry", entry, "domain", domain)
+	}
+	return entry, domain, nil
 }
 
-func (c *gandiDNSProviderSolver) getDomainAndChallengeFQDN(ch *v1alpha1.ChallengeRequest) (string, string) {
+// defaultRecordName strips the resolved zone from the resolved FQDN.
+func defaultRecordName(ch *v1alpha1.ChallengeRequest) (string, string) {
 	// Both ch.ResolvedZone and ch.ResolvedFQDN end with a dot: '.'
 	entry := strings.TrimSuffix(ch.ResolvedFQDN, ch.ResolvedZone)
 	entry = strings.TrimSuffix(entry, ".")
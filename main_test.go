@@ -1,10 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	dns "github.com/cert-manager/cert-manager/test/acme"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 )
 
 var zone = os.Getenv("TEST_ZONE_NAME")
@@ -22,3 +38,752 @@ func TestRunsSuite(t *testing.T) {
 
 	fixture.RunConformance(t)
 }
+
+func TestCheckDuplicateSolverNames(t *testing.T) {
+	unique := []webhook.Solver{&gandiDNSProviderSolver{}}
+	if err := checkDuplicateSolverNames(unique); err != nil {
+		t.Fatalf("unexpected error for unique solvers: %v", err)
+	}
+
+	duplicated := []webhook.Solver{&gandiDNSProviderSolver{}, &gandiDNSProviderSolver{}}
+	err := checkDuplicateSolverNames(duplicated)
+	if err == nil {
+		t.Fatal("expected an error for duplicated solver names")
+	}
+	if !strings.Contains(err.Error(), `"gandi"`) {
+		t.Errorf("error should name the duplicated solver, got: %v", err)
+	}
+}
+
+const (
+	testNamespace = "cert-manager"
+	testZone      = "example.com."
+	testFQDN      = "_acme-challenge.example.com."
+	testToken     = "test-personal-access-token"
+)
+
+// newTestSolver returns a solver backed by a fake Kubernetes clientset holding
+// the test credentials and objs, and by the given fake Gandi client.
+func newTestSolver(gandiClient *fakeLiveDNS, objs ...runtime.Object) *gandiDNSProviderSolver {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": []byte(testToken)},
+	}
+	return &gandiDNSProviderSolver{
+		client: fake.NewSimpleClientset(append(objs, secret)...),
+		newLiveDNSClient: func(config.Config) liveDNSClient {
+			return gandiClient
+		},
+	}
+}
+
+// captureConfig returns a newLiveDNSClient serving gandiClient that stores in
+// used the config of the last client built.
+func captureConfig(used *config.Config, gandiClient liveDNSClient) func(config.Config) liveDNSClient {
+	return func(cfg config.Config) liveDNSClient {
+		*used = cfg
+		return gandiClient
+	}
+}
+
+// newTestChallenge returns a challenge for testFQDN using the test credentials.
+func newTestChallenge(key string) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		ResourceNamespace: testNamespace,
+		ResolvedZone:      testZone,
+		ResolvedFQDN:      testFQDN,
+		Key:               key,
+		Config: &extapi.JSON{
+			Raw: []byte(`{"PATSecretRef": {"name": "gandi-credentials", "key": "api-token"}}`),
+		},
+	}
+}
+
+func TestPresentMergesExistingValues(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	for _, key := range []string{"key-1", "key-2", "key-1"} {
+		if err := solver.Present(newTestChallenge(key)); err != nil {
+			t.Fatalf("Present(%s): %v", key, err)
+		}
+	}
+
+	got := gandiClient.values("example.com", "_acme-challenge", "TXT")
+	if strings.Join(got, ",") != "key-1,key-2" {
+		t.Errorf("unexpected rrset values %v", got)
+	}
+}
+
+func TestCleanUpRemovesOnlyOwnKey(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	for _, key := range []string{"key-1", "key-2"} {
+		if err := solver.Present(newTestChallenge(key)); err != nil {
+			t.Fatalf("Present(%s): %v", key, err)
+		}
+	}
+
+	if err := solver.CleanUp(newTestChallenge("key-1")); err != nil {
+		t.Fatalf("CleanUp(key-1): %v", err)
+	}
+	got := gandiClient.values("example.com", "_acme-challenge", "TXT")
+	if strings.Join(got, ",") != "key-2" {
+		t.Errorf("unexpected rrset values after first cleanup %v", got)
+	}
+
+	if err := solver.CleanUp(newTestChallenge("key-2")); err != nil {
+		t.Fatalf("CleanUp(key-2): %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("rrset should be deleted once empty, got %v", got)
+	}
+}
+
+func TestCleanUpLargeRrset(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	// ACME challenge values are 43 characters long.
+	values := make([]string, 1000)
+	for i := range values {
+		values[i] = fmt.Sprintf("%043d", i)
+	}
+	gandiClient.set("example.com", "_acme-challenge", "TXT", values)
+
+	if err := solver.CleanUp(newTestChallenge(values[500])); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+
+	got := gandiClient.values("example.com", "_acme-challenge", "TXT")
+	if len(got) != len(values)-1 {
+		t.Fatalf("expected %d remaining values, got %d", len(values)-1, len(got))
+	}
+	for _, v := range got {
+		if v == values[500] {
+			t.Fatal("cleaned-up value is still present")
+		}
+	}
+	if n := gandiClient.countCalls("update"); n != 1 {
+		t.Errorf("expected a single update call, got %d", n)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "gandi-credentials"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PATSecretRef.Key != DefaultPATSecretKey {
+		t.Errorf("PATSecretRef.key = %q, want %q", cfg.PATSecretRef.Key, DefaultPATSecretKey)
+	}
+	if cfg.TTL != GandiMinTtl {
+		t.Errorf("ttl = %d, want %d", cfg.TTL, GandiMinTtl)
+	}
+	if cfg.MaxValues != DefaultMaxValues {
+		t.Errorf("maxValues = %d, want %d", cfg.MaxValues, DefaultMaxValues)
+	}
+	if cfg.ChallengePrefix != DefaultChallengePrefix {
+		t.Errorf("challengePrefix = %q, want %q", cfg.ChallengePrefix, DefaultChallengePrefix)
+	}
+	if cfg.Timeout == nil || cfg.Timeout.Duration != config.Timeout {
+		t.Errorf("timeout = %v, want %v", cfg.Timeout, config.Timeout)
+	}
+	if cfg.callTimeout(cfg.PresentTimeout) != config.Timeout || cfg.callTimeout(cfg.CleanupTimeout) != config.Timeout {
+		t.Error("presentTimeout and cleanupTimeout should default to timeout")
+	}
+	if cfg.ForceReplace || cfg.DecodeBase64 || cfg.Verification.Enabled || cfg.CleanupDelay != nil {
+		t.Errorf("optional behaviors should be off by default: %+v", cfg)
+	}
+
+	cfg, err = loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "s", "key": "k"}, "ttl": 600, "maxValues": 3, "timeout": "10s"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PATSecretRef.Key != "k" || cfg.TTL != 600 || cfg.MaxValues != 3 || cfg.Timeout.Duration != 10*time.Second {
+		t.Errorf("set fields should be kept: %+v", cfg)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct{ raw, err string }{
+		{`{"timeout": "-1s"}`, "invalid timeout"},
+		{`{"presentTimeout": "-1s"}`, "invalid presentTimeout"},
+		{`{"cleanupTimeout": "-1s"}`, "invalid cleanupTimeout"},
+		{`{"cleanupDelay": "-1s"}`, "invalid cleanupDelay"},
+		{`{"PATSecretRef": {"name": "s"}, "PATSecretSelector": {"matchLabels": {"a": "b"}}}`, "mutually exclusive"},
+		{`{"PATSecretSelector": {"matchExpressions": [{"key": "a", "operator": "Bogus"}]}}`, "invalid PATSecretSelector"},
+		{`{"challengePrefix": "acme"}`, "invalid challengePrefix"},
+		{`{"maxValues": -1}`, "invalid maxValues"},
+		{`{"zoneNames": {"": "example.com"}}`, "invalid zoneNames"},
+		{`{"zoneTTLs": {"example.com": 60}}`, "invalid zoneTTLs"},
+		{`{"retry": {"maxRetries": 11}}`, "invalid retry maxRetries"},
+		{`{"retry": {"initialInterval": "0s"}}`, "invalid retry initialInterval"},
+		{`{"retry": {"maxInterval": "-1s"}}`, "invalid retry maxInterval"},
+		{`{"verification": {"multiplier": 0.5}}`, "invalid verification multiplier"},
+		{`{"verification": {"maxServfails": -1}}`, "invalid verification maxServfails"},
+	} {
+		_, err := loadConfig(&extapi.JSON{Raw: []byte(tc.raw)})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.raw, tc.err, err)
+		}
+	}
+	if err := (gandiDNSProviderConfig{}).Validate(); err != nil {
+		t.Errorf("the empty config should be valid: %v", err)
+	}
+}
+
+func TestGetGandiClientDefaultSecretKey(t *testing.T) {
+	solver := newTestSolver(newFakeLiveDNS())
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "gandi-credentials"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout); err != nil {
+		t.Fatalf("expected the default key %q to be used, got: %v", DefaultPATSecretKey, err)
+	}
+
+	cfg.PATSecretRef.Key = "missing"
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout); err == nil {
+		t.Fatal("expected an error for an explicit key absent from the secret")
+	}
+}
+
+func TestPATSecretSelector(t *testing.T) {
+	labeled := func(name, token string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: labels},
+			Data:       map[string][]byte{"api-token": []byte(token)},
+		}
+	}
+	var used config.Config
+	solver := newTestSolver(newFakeLiveDNS(),
+		labeled("prod", "prod-token", map[string]string{"gandi": "prod"}),
+		labeled("staging-1", "staging-token", map[string]string{"gandi": "staging"}),
+		labeled("staging-2", "staging-token", map[string]string{"gandi": "staging"}),
+	)
+	solver.newLiveDNSClient = captureConfig(&used, newFakeLiveDNS())
+	get := func(raw string) error {
+		cfg, err := loadConfig(&extapi.JSON{Raw: []byte(raw)})
+		if err != nil {
+			return err
+		}
+		_, err = solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout)
+		return err
+	}
+
+	if err := get(`{"PATSecretSelector": {"matchLabels": {"gandi": "prod"}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if used.PersonalAccessToken != "prod-token" {
+		t.Errorf("expected the token of the matching secret, got %q", used.PersonalAccessToken)
+	}
+
+	err := get(`{"PATSecretSelector": {"matchLabels": {"gandi": "staging"}}}`)
+	if err == nil || !strings.Contains(err.Error(), "staging-1 staging-2") {
+		t.Errorf("expected an error naming both matching secrets, got %v", err)
+	}
+	if err := get(`{"PATSecretSelector": {"matchLabels": {"gandi": "dev"}}}`); err == nil {
+		t.Error("expected an error when no secret matches")
+	}
+	if err := get(`{"PATSecretRef": {"name": "gandi-credentials"}, "PATSecretSelector": {"matchLabels": {"gandi": "prod"}}}`); err == nil {
+		t.Error("expected an error when both a name and a selector are set")
+	}
+}
+
+func TestGetGandiClientTrimsToken(t *testing.T) {
+	var used config.Config
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "heredoc-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": []byte(" " + testToken + "\n")},
+	}
+	solver := newTestSolver(newFakeLiveDNS(), secret)
+	solver.newLiveDNSClient = captureConfig(&used, newFakeLiveDNS())
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "heredoc-credentials"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout); err != nil {
+		t.Fatal(err)
+	}
+	if used.PersonalAccessToken != testToken {
+		t.Errorf("expected the token to be trimmed, got %q", used.PersonalAccessToken)
+	}
+}
+
+func TestGetGandiClientEmptyToken(t *testing.T) {
+	for _, value := range []string{"", " \n"} {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty-credentials", Namespace: testNamespace},
+			Data:       map[string][]byte{"api-token": []byte(value)},
+		}
+		gandiClient := newFakeLiveDNS()
+		solver := newTestSolver(gandiClient, secret)
+		cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "empty-credentials"}}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout)
+		if err == nil || !strings.Contains(err.Error(), "credential is empty") {
+			t.Errorf("expected an empty credential error for %q, got %v", value, err)
+		}
+	}
+}
+
+func TestGetGandiClientOversizedToken(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "large-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": bytes.Repeat([]byte("a"), DefaultMaxSecretSize+1)},
+	}
+	solver := newTestSolver(newFakeLiveDNS(), secret)
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "large-credentials"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout)
+	if err == nil || !strings.Contains(err.Error(), "more than the maximum") {
+		t.Errorf("expected an oversized credential error, got %v", err)
+	}
+
+	// The limit is configurable.
+	solver.maxSecretSize = 2 * DefaultMaxSecretSize
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout); err != nil {
+		t.Errorf("expected the token to fit the raised limit: %v", err)
+	}
+}
+
+func TestGetGandiClientDecodeBase64(t *testing.T) {
+	var used config.Config
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "encoded-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": []byte(base64.StdEncoding.EncodeToString([]byte(testToken)) + "\n")},
+	}
+	solver := newTestSolver(newFakeLiveDNS(), secret)
+	solver.newLiveDNSClient = captureConfig(&used, newFakeLiveDNS())
+
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"PATSecretRef": {"name": "encoded-credentials"}, "decodeBase64": true}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout); err != nil {
+		t.Fatal(err)
+	}
+	if used.PersonalAccessToken != testToken {
+		t.Errorf("expected the decoded token, got %q", used.PersonalAccessToken)
+	}
+
+	// The test token is not valid base64.
+	cfg.PATSecretRef.Name = "gandi-credentials"
+	_, err = solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout)
+	if err == nil || !strings.Contains(err.Error(), "not valid base64") {
+		t.Errorf("expected a decoding error, got %v", err)
+	}
+}
+
+func TestEffectiveTTL(t *testing.T) {
+
+	cases := map[int]int{
+		0:               GandiMinTtl,
+		60:              GandiMinTtl,
+		GandiMinTtl:     GandiMinTtl,
+		GandiMinTtl * 2: GandiMinTtl * 2,
+	}
+	for in, want := range cases {
+		if got := effectiveTTL(in); got != want {
+			t.Errorf("effectiveTTL(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestClampedTTLCountedOncePerChallenge(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "ttl": 60}`)
+
+	before := counterValue(t, ttlClampedTotal)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	other := newTestChallenge("other")
+	other.Config.Raw = ch.Config.Raw
+	if err := solver.Present(other); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	// Neither a Present with nothing to write nor the CleanUps count.
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	for _, c := range []*v1alpha1.ChallengeRequest{ch, other} {
+		if err := solver.CleanUp(c); err != nil {
+			t.Fatalf("CleanUp: %v", err)
+		}
+	}
+	if clamped := counterValue(t, ttlClampedTotal) - before; clamped != 2 {
+		t.Errorf("expected the clamp counter to increase by 2, got %v", clamped)
+	}
+}
+
+func TestZoneTTLs(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"ttl": 400, "zoneTTLs": {"example.com": 600, "sub.example.com.": 900}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]int{
+		"example.com":         600,
+		"www.example.com":     600,
+		"sub.example.com":     900,
+		"a.sub.example.com":   900,
+		"EXAMPLE.COM":         600,
+		"example.net":         400,
+		"notexample.com":      400,
+		"example.com.example": 400,
+	}
+	for domain, want := range cases {
+		if got := cfg.ttlFor(domain); got != want {
+			t.Errorf("ttlFor(%s) = %d, want %d", domain, got, want)
+		}
+	}
+
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "zoneTTLs": {"example.com": 600}}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if ttl := gandiClient.records[fakeKey("example.com", "_acme-challenge", "TXT")].RrsetTTL; ttl != 600 {
+		t.Errorf("expected the zone TTL to be used, got %d", ttl)
+	}
+
+	if _, err := loadConfig(&extapi.JSON{Raw: []byte(`{"zoneTTLs": {"example.com": 60}}`)}); err == nil {
+		t.Error("expected an error for a zone TTL below the minimum")
+	}
+}
+
+func TestPreserveExistingTTL(t *testing.T) {
+	ttlOf := func(f *fakeLiveDNS) int {
+		return f.records[fakeKey("example.com", "_acme-challenge", "TXT")].RrsetTTL
+	}
+	raw := []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "ttl": 600, "preserveExistingTTL": true}`)
+
+	// New rrsets get the configured TTL.
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.Config.Raw = raw
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ttlOf(gandiClient); ttl != 600 {
+		t.Errorf("expected the configured TTL on a new rrset, got %d", ttl)
+	}
+
+	// Existing ones keep theirs, on Present and CleanUp alike.
+	gandiClient = newFakeLiveDNS()
+	gandiClient.records[fakeKey("example.com", "_acme-challenge", "TXT")] = livedns.DomainRecord{
+		RrsetType: "TXT", RrsetName: "_acme-challenge", RrsetTTL: 3600, RrsetValues: []string{"other"},
+	}
+	solver = newTestSolver(gandiClient)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ttlOf(gandiClient); ttl != 3600 {
+		t.Errorf("expected the existing TTL to be kept by Present, got %d", ttl)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ttlOf(gandiClient); ttl != 3600 {
+		t.Errorf("expected the existing TTL to be kept by CleanUp, got %d", ttl)
+	}
+
+	// Without the option the configured TTL wins.
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "ttl": 600}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ttlOf(gandiClient); ttl != 600 {
+		t.Errorf("expected the configured TTL without preserveExistingTTL, got %d", ttl)
+	}
+}
+
+func TestZoneNames(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "zoneNames": {"EXAMPLE.com.": "gandi-example.com."}}`)
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.values("gandi-example.com", "_acme-challenge", "TXT"); len(got) != 1 || got[0] != "key" {
+		t.Errorf("expected the value in the translated zone, got %v", got)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 0 {
+		t.Errorf("nothing should be written in the resolved zone, got %v", got)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.values("gandi-example.com", "_acme-challenge", "TXT"); len(got) != 0 {
+		t.Errorf("expected the value to be removed from the translated zone, got %v", got)
+	}
+
+	cfg := gandiDNSProviderConfig{ZoneNames: map[string]string{"example.com": "gandi-example.com"}}
+	if got := cfg.gandiZone("example.org"); got != "example.org" {
+		t.Errorf("unlisted zones should be used as resolved, got %q", got)
+	}
+	if _, err := loadConfig(&extapi.JSON{Raw: []byte(`{"zoneNames": {"example.com": "."}}`)}); err == nil {
+		t.Error("an empty zone name should be rejected")
+	}
+}
+
+func TestPresentForceReplace(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"malformed", "other"})
+	solver := newTestSolver(gandiClient)
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "forceReplace": true}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+
+	got := gandiClient.values("example.com", "_acme-challenge", "TXT")
+	if strings.Join(got, ",") != "key" {
+		t.Errorf("expected only the challenge key to remain, got %v", got)
+	}
+	if n := gandiClient.countCalls("delete"); n != 1 {
+		t.Errorf("expected one delete call, got %d", n)
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	var used config.Config
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = captureConfig(&used, gandiClient)
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "timeout": "20s", "cleanupTimeout": "1m"}`)
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if used.Timeout != 20*time.Second {
+		t.Errorf("Present should fall back to the global timeout, got %v", used.Timeout)
+	}
+
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if used.Timeout != time.Minute {
+		t.Errorf("CleanUp should use cleanupTimeout, got %v", used.Timeout)
+	}
+
+	if got := (gandiDNSProviderConfig{}).callTimeout(nil); got != config.Timeout {
+		t.Errorf("default timeout = %v, want %v", got, config.Timeout)
+	}
+
+	if _, err := loadConfig(&extapi.JSON{Raw: []byte(`{"presentTimeout": "-1s"}`)}); err == nil {
+		t.Error("expected an error for a negative timeout")
+	}
+}
+
+func TestCleanUpDelay(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	stopCh := make(chan struct{})
+	solver.stopCh = stopCh
+
+	ch := newTestChallenge("key")
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "cleanupDelay": "20ms"}`)
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("the value should be kept until the delay elapses, got %v", got)
+	}
+	solver.delayedCleanups.Wait()
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("the value should be removed after the delay, got %v", got)
+	}
+
+	// Shutting down runs the pending removals right away.
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "cleanupDelay": "1h"}`)
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	close(stopCh)
+	if !solver.waitDelayedCleanups(time.Second) {
+		t.Fatal("expected the pending removals to be over")
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("the value should be removed on shutdown, got %v", got)
+	}
+}
+
+func TestPresentCancelsDelayedCleanUp(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "cleanupDelay": "20ms"}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	// The value is presented again while its removal is pending.
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if !solver.waitDelayedCleanups(time.Second) {
+		t.Fatal("expected the canceled removal to be over")
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("expected the value presented again to be kept, got %v", got)
+	}
+	if n := gandiClient.countCalls("delete"); n != 0 {
+		t.Errorf("expected no removal, got %d deletes", n)
+	}
+
+	// Another value of the rrset is still removed.
+	other := newTestChallenge("other")
+	other.Config.Raw = ch.Config.Raw
+	if err := solver.Present(other); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if err := solver.CleanUp(other); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	solver.waitDelayedCleanups(time.Second)
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key" {
+		t.Errorf("expected only the other value to be removed, got %v", got)
+	}
+
+	// Waiting gives up after its timeout.
+	block := make(chan struct{})
+	solver.delayCleanup(context.Background(), "k", 0, func() error { <-block; return nil })
+	if solver.waitDelayedCleanups(10 * time.Millisecond) {
+		t.Error("expected the wait to time out")
+	}
+	close(block)
+}
+
+func TestKubeClientConfigFromEnv(t *testing.T) {
+	base := &rest.Config{Host: "https://kubernetes.default.svc"}
+
+	cfg, err := kubeClientConfigFromEnv(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QPS != DefaultKubeAPIQPS || cfg.Burst != DefaultKubeAPIBurst {
+		t.Errorf("unexpected defaults qps=%v burst=%d", cfg.QPS, cfg.Burst)
+	}
+	if base.QPS != 0 {
+		t.Error("the original config must not be modified")
+	}
+
+	t.Setenv(KubeAPIQPSEnv, "50")
+	t.Setenv(KubeAPIBurstEnv, "100")
+	if cfg, err = kubeClientConfigFromEnv(base); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QPS != 50 || cfg.Burst != 100 {
+		t.Errorf("unexpected values qps=%v burst=%d", cfg.QPS, cfg.Burst)
+	}
+
+	t.Setenv(KubeAPIBurstEnv, "0")
+	if _, err := kubeClientConfigFromEnv(base); err == nil {
+		t.Error("expected an error for a zero burst")
+	}
+	t.Setenv(KubeAPIQPSEnv, "fast")
+	if _, err := kubeClientConfigFromEnv(base); err == nil {
+		t.Error("expected an error for an invalid qps")
+	}
+}
+
+func TestInitializeKubeAPICheck(t *testing.T) {
+	// Nothing listens on the discard port.
+	unreachable := &rest.Config{Host: "http://127.0.0.1:9", Timeout: time.Second}
+
+	t.Setenv(KubeAPICheckEnv, KubeAPICheckFail)
+	err := (&gandiDNSProviderSolver{}).Initialize(unreachable, make(chan struct{}))
+	if err == nil || !strings.Contains(err.Error(), "not reachable") {
+		t.Errorf("expected Initialize to fail on an unreachable API, got %v", err)
+	}
+
+	t.Setenv(KubeAPICheckEnv, KubeAPICheckWarn)
+	if err := (&gandiDNSProviderSolver{}).Initialize(unreachable, make(chan struct{})); err != nil {
+		t.Errorf("expected Initialize to only warn, got %v", err)
+	}
+
+	t.Setenv(KubeAPICheckEnv, "")
+	if err := (&gandiDNSProviderSolver{}).Initialize(unreachable, make(chan struct{})); err != nil {
+		t.Errorf("expected no check by default, got %v", err)
+	}
+
+	t.Setenv(KubeAPICheckEnv, "maybe")
+	if err := (&gandiDNSProviderSolver{}).Initialize(unreachable, make(chan struct{})); err == nil {
+		t.Error("expected an invalid KUBE_API_CHECK to be rejected")
+	}
+}
+
+func TestSkipNamespaceLabel(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   testNamespace,
+		Labels: map[string]string{"example.com/skip-gandi": "true"},
+	}}
+	solver := newTestSolver(gandiClient, namespace)
+
+	// Without the option the label is ignored.
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if n := gandiClient.countCalls(""); n == 0 {
+		t.Fatal("expected Present to call Gandi")
+	}
+
+	solver.skipLabel = "example.com/skip-gandi"
+	calls := gandiClient.countCalls("")
+	if err := solver.CleanUp(newTestChallenge("key")); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if err := solver.Present(newTestChallenge("other")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if n := gandiClient.countCalls("") - calls; n != 0 {
+		t.Errorf("expected no Gandi call in a skipped namespace, got %d", n)
+	}
+
+	// Another value, or a namespace that cannot be read, is solved.
+	namespace.Labels["example.com/skip-gandi"] = "false"
+	solver = newTestSolver(gandiClient, namespace)
+	solver.skipLabel = "example.com/skip-gandi"
+	if err := solver.Present(newTestChallenge("other")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	solver = newTestSolver(gandiClient)
+	solver.skipLabel = "example.com/skip-gandi"
+	if err := solver.Present(newTestChallenge("third")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key,other,third" {
+		t.Errorf("unexpected values %v", got)
+	}
+}
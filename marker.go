@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// metaMarkerLabel prefixes the name of the marker rrset of a challenge
+// rrset, as Gandi has no per-record metadata to store it on.
+const metaMarkerLabel = "_cert-manager-meta"
+
+// metaMarkerConfig is the `metaMarker` block of the solver config. When
+// enabled, each challenge rrset gets a companion TXT rrset telling when the
+// webhook last changed it and from which cluster, so that leftovers can be
+// traced back, e.g. with --report-challenges.
+type metaMarkerConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Cluster names the cluster owning the challenges, recorded as is.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// validate rejects a cluster name that would not fit in a marker value.
+func (m metaMarkerConfig) validate() error {
+	if strings.ContainsAny(m.Cluster, " \t\r\n\"") {
+		return fmt.Errorf("invalid metaMarker cluster %q: must not contain whitespace or quotes", m.Cluster)
+	}
+	return nil
+}
+
+// metaMarkerName returns the name of the marker rrset of the challenge
+// rrset name.
+func metaMarkerName(name string) string {
+	return metaMarkerLabel + "." + name
+}
+
+// metaMarkerValue returns the marker value recording an update at now from
+// cluster.
+func metaMarkerValue(cluster string, now time.Time) string {
+	v := "updated=" + now.UTC().Format(time.RFC3339)
+	if cluster != "" {
+		v += " cluster=" + cluster
+	}
+	return v
+}
+
+// syncMetaMarker brings the marker of the challenge rrset name of domain in
+// line with it: refreshed while the rrset exists, removed with it. It runs
+// under the lock of the rrset so that it sees the outcome of the last
+// change. Failures are only logged, the marker being informational.
+func (c *gandiDNSProviderSolver) syncMetaMarker(ctx context.Context, gandiClient liveDNSClient, cfg gandiDNSProviderConfig, domain, name string) {
+	if !cfg.MetaMarker.Enabled {
+		return
+	}
+	logger := klog.FromContext(ctx)
+	unlock, err := c.lockRrset(ctx, domain, name)
+	if err != nil {
+		logger.Info("unable to update the marker of TXT record", "name", name, "domain", domain, "err", err)
+		return
+	}
+	defer unlock()
+
+	marker := metaMarkerName(name)
+	_, exists, err := getChallengeRrset(ctx, gandiClient, domain, name)
+	if err != nil {
+		logger.Info("unable to check TXT record to update its marker", "name", name, "domain", domain, "err", classifyGandiError(err))
+		return
+	}
+	if !exists {
+		if err := gandiClient.DeleteDomainRecord(domain, marker, ChallengeRecordType); err != nil && !isRecordNotFound(err) {
+			logger.Info("unable to remove marker", "marker", marker, "domain", domain, "err", classifyGandiError(err))
+		}
+		return
+	}
+	// Writing a whole rrset creates it when missing.
+	value := metaMarkerValue(cfg.MetaMarker.Cluster, time.Now())
+	resp, err := gandiClient.UpdateDomainRecordByNameAndType(domain, marker, ChallengeRecordType, GandiMinTtl, []string{value})
+	if err == nil {
+		err = responseError(resp)
+	}
+	if err != nil {
+		logger.Info("unable to write marker", "marker", marker, "domain", domain, "err", classifyGandiError(err))
+		return
+	}
+	logger.V(6).Info("wrote marker", "marker", marker, "domain", domain, "value", value)
+}
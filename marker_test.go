@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+func TestMetaMarker(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	config := []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "metaMarker": {"enabled": true, "cluster": "prod-1"}}`)
+
+	first, second := newTestChallenge("key-1"), newTestChallenge("key-2")
+	first.Config.Raw, second.Config.Raw = config, config
+	for _, ch := range []*v1alpha1.ChallengeRequest{first, second} {
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("Present: %v", err)
+		}
+	}
+	marker := gandiClient.values("example.com", "_cert-manager-meta._acme-challenge", "TXT")
+	if len(marker) != 1 || !strings.HasPrefix(marker[0], "updated=") || !strings.HasSuffix(marker[0], " cluster=prod-1") {
+		t.Fatalf("unexpected marker %v", marker)
+	}
+
+	// The marker stays while values remain, and goes with the rrset.
+	if err := solver.CleanUp(first); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_cert-manager-meta._acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("expected the marker to stay with a value left, got %v", got)
+	}
+	if err := solver.CleanUp(second); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_cert-manager-meta._acme-challenge", "TXT"); len(got) != 0 {
+		t.Errorf("expected the marker to be removed with the rrset, got %v", got)
+	}
+
+	// Without the option, no marker is written.
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_cert-manager-meta._acme-challenge", "TXT"); len(got) != 0 {
+		t.Errorf("expected no marker without metaMarker, got %v", got)
+	}
+
+	if err := (metaMarkerConfig{Enabled: true, Cluster: "my cluster"}).validate(); err == nil {
+		t.Error("expected a cluster name with a space to be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	// metricsNamespace prefixes all metrics exposed by the webhook on
+	// /metrics.
+	metricsNamespace = "gandi_webhook"
+	// MetricsZoneLabelEnv, set to "true", fills the zone label of the
+	// per-operation metrics. It is left empty otherwise, as one series per
+	// zone does not scale to accounts with many zones.
+	MetricsZoneLabelEnv = "METRICS_ZONE_LABEL"
+)
+
+var (
+	ttlClampedTotal = metrics.NewCounter(&metrics.CounterOpts{
+		Namespace:      metricsNamespace,
+		Name:           "ttl_clamped_total",
+		Help:           "Number of challenges whose configured TTL was raised to the Gandi minimum.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	rateLimitedTotal = metrics.NewCounter(&metrics.CounterOpts{
+		Namespace:      metricsNamespace,
+		Name:           "issuer_rate_limited_total",
+		Help:           "Number of Present and CleanUp calls rejected because their issuer exceeded its rate limit.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	operationsTotal = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      metricsNamespace,
+		Name:           "operations_total",
+		Help:           "Number of Present and CleanUp calls by operation, result and, with METRICS_ZONE_LABEL, zone.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"operation", "result", "zone"})
+	lastSuccessTimestamp = metrics.NewGauge(&metrics.GaugeOpts{
+		Namespace:      metricsNamespace,
+		Name:           "last_success_timestamp",
+		Help:           "Unix time in seconds of the last Present or CleanUp whose Gandi API calls succeeded, zero if none did yet.",
+		StabilityLevel: metrics.ALPHA,
+	})
+)
+
+func init() {
+	// The webhook apiserver serves the legacy registry on /metrics.
+	legacyregistry.MustRegister(ttlClampedTotal, rateLimitedTotal, operationsTotal, lastSuccessTimestamp)
+}
+
+// countOperation counts a Present or CleanUp of zone that returned err. The
+// zone label stays empty unless c.metricsZoneLabel is set, an empty label
+// adding no series.
+func (c *gandiDNSProviderSolver) countOperation(operation, zone string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	if !c.metricsZoneLabel {
+		zone = ""
+	}
+	operationsTotal.WithLabelValues(operation, result, strings.TrimSuffix(zone, ".")).Inc()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// counterValue returns the current value of c.
+func counterValue(t *testing.T, c metrics.CounterMetric) float64 {
+	t.Helper()
+	v, err := testutil.GetCounterMetricValue(c)
+	if err != nil {
+		t.Fatalf("unable to read counter: %v", err)
+	}
+	return v
+}
+
+// gaugeValue returns the current value of g.
+func gaugeValue(t *testing.T, g *metrics.Gauge) float64 {
+	t.Helper()
+	v, err := testutil.GetGaugeMetricValue(g)
+	if err != nil {
+		t.Fatalf("unable to read gauge: %v", err)
+	}
+	return v
+}
+
+func TestLastSuccessTimestamp(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	lastSuccessTimestamp.Set(0)
+
+	// A failed call leaves the timestamp alone.
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "missing-credentials"}}`)
+	if err := solver.Present(ch); err == nil {
+		t.Fatal("expected Present to fail without credentials")
+	}
+	if got := gaugeValue(t, lastSuccessTimestamp); got != 0 {
+		t.Errorf("expected no success to be recorded, got %v", got)
+	}
+
+	start := float64(time.Now().Unix())
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gaugeValue(t, lastSuccessTimestamp); got < start {
+		t.Errorf("expected Present to record its success, got %v", got)
+	}
+
+	lastSuccessTimestamp.Set(0)
+	if err := solver.CleanUp(newTestChallenge("key")); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if got := gaugeValue(t, lastSuccessTimestamp); got < start {
+		t.Errorf("expected CleanUp to record its success, got %v", got)
+	}
+}
+
+func TestOperationsTotalZoneLabel(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	// Without METRICS_ZONE_LABEL the zone label stays empty.
+	before := counterValue(t, operationsTotal.WithLabelValues("present", "success", ""))
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := counterValue(t, operationsTotal.WithLabelValues("present", "success", "")) - before; got != 1 {
+		t.Errorf("expected one successful present without zone, got %v", got)
+	}
+
+	solver.metricsZoneLabel = true
+	before = counterValue(t, operationsTotal.WithLabelValues("cleanup", "error", "example.com"))
+	gandiClient.failWith("get", requestError(500))
+	if err := solver.CleanUp(newTestChallenge("key")); err == nil {
+		t.Fatal("expected CleanUp to fail")
+	}
+	if got := counterValue(t, operationsTotal.WithLabelValues("cleanup", "error", "example.com")) - before; got != 1 {
+		t.Errorf("expected one failed cleanup for example.com, got %v", got)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// Forms of the record names sent to Gandi.
+const (
+	// RecordNameRelative sends names relative to the zone, as documented by
+	// LiveDNS, e.g. `_acme-challenge.www`.
+	RecordNameRelative = "relative"
+	// RecordNameQualified sends fully-qualified names, e.g.
+	// `_acme-challenge.www.example.com.`.
+	RecordNameQualified = "qualified"
+	// RecordNameAuto tries the relative form first and falls back to the
+	// qualified one when Gandi rejects it, remembering the form that worked
+	// for each zone.
+	RecordNameAuto = "auto"
+)
+
+// validateRecordNameForm rejects an unknown recordNameForm.
+func validateRecordNameForm(form string) error {
+	switch form {
+	case "", RecordNameRelative, RecordNameQualified, RecordNameAuto:
+		return nil
+	}
+	return fmt.Errorf("invalid recordNameForm %q: must be %q, %q or %q", form, RecordNameRelative, RecordNameQualified, RecordNameAuto)
+}
+
+// qualifiedName returns the fully-qualified form of the name of domain.
+func qualifiedName(domain, name string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	if name == "@" || name == "" {
+		return domain + "."
+	}
+	return name + "." + domain + "."
+}
+
+// relativeName returns the form of name relative to domain, name being
+// either form.
+func relativeName(domain, name string) string {
+	if !strings.HasSuffix(name, ".") {
+		return name
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	name = strings.TrimSuffix(name, ".")
+	if strings.EqualFold(name, domain) {
+		return "@"
+	}
+	if n := len(name) - len(domain); n > 1 && strings.EqualFold(name[n:], domain) && name[n-1] == '.' {
+		return name[:n-1]
+	}
+	return name
+}
+
+// isNameRejected reports whether err tells that Gandi refused the record
+// name itself: a 400 whose validation errors, as go-gandi lists them by
+// field, name the rrset_name field. Other 400s, e.g. for the TTL or the
+// values, do not depend on the name form.
+func isNameRejected(err error) bool {
+	var reqErr *types.RequestError
+	return errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusBadRequest &&
+		reqErr.Err != nil && strings.Contains(reqErr.Err.Error(), "rrset_name")
+}
+
+// nameFormScope identifies the credentials of a client, so that the form
+// learned with the token of an issuer is not applied to others. The token
+// itself is not kept.
+func nameFormScope(pat, sharingID string) string {
+	sum := sha256.Sum256([]byte(pat + "\x00" + sharingID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// nameFormCache remembers the record name form working for each zone and
+// credentials scope in RecordNameAuto mode, for up to its zone limit. Its
+// zero value is ready to use.
+type nameFormCache struct {
+	mu    sync.Mutex
+	forms zoneLRU[string]
+}
+
+func (n *nameFormCache) get(scope, zone string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	form, _ := n.forms.get(scope + "\x00" + zone)
+	return form
+}
+
+// put remembers form for zone, logging a change with the logger of ctx.
+func (n *nameFormCache) put(ctx context.Context, scope, zone, form string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if cached, _ := n.forms.get(scope + "\x00" + zone); cached != form {
+		klog.FromContext(ctx).V(6).Info("gandi: using the record name form for zone", "form", form, "zone", strings.ToLower(zone))
+	}
+	n.forms.put(scope+"\x00"+zone, form)
+}
+
+// setZoneLimit bounds the number of zones remembered, see zoneLRU.
+func (n *nameFormCache) setZoneLimit(limit int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.forms.setLimit(limit)
+}
+
+// nameFormLiveDNS sends the record names received in relative form in the
+// form configured, or detected, for their zone. Records read back always
+// carry the relative form.
+type nameFormLiveDNS struct {
+	liveDNSClient
+	// form is RecordNameQualified or RecordNameAuto.
+	form  string
+	cache *nameFormCache
+	// scope is the nameFormScope of the credentials of the client.
+	scope string
+	// ctx is the context of the call, whose logger reports the forms found.
+	ctx context.Context
+}
+
+// withNameForm returns client, using the credentials of scope, sending
+// names in form, client itself for the default relative form.
+func withNameForm(ctx context.Context, client liveDNSClient, form string, cache *nameFormCache, scope string) liveDNSClient {
+	if form == "" || form == RecordNameRelative {
+		return client
+	}
+	return nameFormLiveDNS{liveDNSClient: client, form: form, cache: cache, scope: scope, ctx: ctx}
+}
+
+// forms returns the forms to try for the names of zone, in order.
+func (c nameFormLiveDNS) forms(zone string) []string {
+	if c.form == RecordNameQualified {
+		return []string{RecordNameQualified}
+	}
+	if form := c.cache.get(c.scope, zone); form != "" {
+		return []string{form}
+	}
+	return []string{RecordNameRelative, RecordNameQualified}
+}
+
+// name returns name of zone in form.
+func (c nameFormLiveDNS) name(zone, name, form string) string {
+	if form == RecordNameQualified {
+		return qualifiedName(zone, name)
+	}
+	return name
+}
+
+// write runs call with each form of name to try until Gandi accepts the
+// name, and remembers the form that worked.
+func (c nameFormLiveDNS) write(zone, name string, call func(name string) error) error {
+	var err error
+	for _, form := range c.forms(zone) {
+		err = call(c.name(zone, name, form))
+		if err == nil {
+			c.cache.put(c.ctx, c.scope, zone, form)
+			return nil
+		}
+		if !isNameRejected(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c nameFormLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	var err error
+	for _, form := range c.forms(fqdn) {
+		var record livedns.DomainRecord
+		record, err = c.liveDNSClient.GetDomainRecordByNameAndType(fqdn, c.name(fqdn, name, form), recordtype)
+		if err == nil {
+			c.cache.put(c.ctx, c.scope, fqdn, form)
+			record.RrsetName = relativeName(fqdn, record.RrsetName)
+			return record, nil
+		}
+		// A missing record does not tell which form is right; reads are
+		// not sent again for it, the next write settles the form.
+		if !isNameRejected(err) {
+			return livedns.DomainRecord{}, err
+		}
+	}
+	return livedns.DomainRecord{}, err
+}
+
+func (c nameFormLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	err = c.write(fqdn, name, func(name string) error {
+		resp, err = c.liveDNSClient.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return resp, err
+}
+
+func (c nameFormLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	err = c.write(fqdn, name, func(name string) error {
+		resp, err = c.liveDNSClient.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return resp, err
+}
+
+func (c nameFormLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	return c.write(fqdn, name, func(name string) error {
+		return c.liveDNSClient.DeleteDomainRecord(fqdn, name, recordtype)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// qualifiedLiveDNS is a fakeLiveDNS rejecting names that are not
+// fully-qualified with a 400, as an API expecting them would.
+type qualifiedLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (f qualifiedLiveDNS) check(name string) error {
+	if !strings.HasSuffix(name, ".") {
+		f.mu.Lock()
+		f.calls = append(f.calls, "rejected")
+		f.mu.Unlock()
+		return &types.RequestError{StatusCode: 400, Err: fmt.Errorf("rrset_name: invalid record name %q", name)}
+	}
+	return nil
+}
+
+func (f qualifiedLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	if err := f.check(name); err != nil {
+		return livedns.DomainRecord{}, err
+	}
+	return f.fakeLiveDNS.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+}
+
+func (f qualifiedLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	if err := f.check(name); err != nil {
+		return types.StandardResponse{}, err
+	}
+	return f.fakeLiveDNS.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+}
+
+func (f qualifiedLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	if err := f.check(name); err != nil {
+		return types.StandardResponse{}, err
+	}
+	return f.fakeLiveDNS.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+}
+
+func (f qualifiedLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	if err := f.check(name); err != nil {
+		return err
+	}
+	return f.fakeLiveDNS.DeleteDomainRecord(fqdn, name, recordtype)
+}
+
+func TestRecordNameForms(t *testing.T) {
+	if got := qualifiedName("example.com.", "_acme-challenge.www"); got != "_acme-challenge.www.example.com." {
+		t.Errorf("qualifiedName = %q", got)
+	}
+	for name, want := range map[string]string{
+		"_acme-challenge.www.example.com.": "_acme-challenge.www",
+		"_acme-challenge.www":              "_acme-challenge.www",
+		"example.com.":                     "@",
+		"_acme-challenge.notexample.com.":  "_acme-challenge.notexample.com",
+	} {
+		if got := relativeName("example.com", name); got != want {
+			t.Errorf("relativeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if err := validateRecordNameForm("absolute"); err == nil {
+		t.Error("expected an unknown form to be rejected")
+	}
+}
+
+func TestRecordNameFormDetection(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		qualified bool
+		form      string
+	}{
+		{name: "relative API, default form", form: ""},
+		{name: "relative API, auto", form: RecordNameAuto},
+		{name: "qualified API, configured", qualified: true, form: RecordNameQualified},
+		{name: "qualified API, auto", qualified: true, form: RecordNameAuto},
+	} {
+		fake := newFakeLiveDNS()
+		var client liveDNSClient = fake
+		if tc.qualified {
+			client = qualifiedLiveDNS{fakeLiveDNS: fake}
+		}
+		solver := newTestSolver(fake)
+		solver.newLiveDNSClient = func(config.Config) liveDNSClient { return client }
+
+		ch := newTestChallenge("key-1")
+		ch.Config.Raw = []byte(fmt.Sprintf(`{"PATSecretRef": {"name": "gandi-credentials"}, "recordNameForm": %q}`, tc.form))
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("%s: Present: %v", tc.name, err)
+		}
+		name := "_acme-challenge"
+		if tc.qualified {
+			name = "_acme-challenge.example.com."
+		}
+		if got := fake.values("example.com", name, "TXT"); len(got) != 1 {
+			t.Fatalf("%s: expected the record under %q, got %v", tc.name, name, fake.records)
+		}
+
+		// Once detected, the form is used straight away.
+		rejected := fake.countCalls("rejected")
+		ch.Key = "key-2"
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("%s: Present: %v", tc.name, err)
+		}
+		if n := fake.countCalls("rejected") - rejected; n != 0 {
+			t.Errorf("%s: expected the detected form to be reused, got %d rejected calls", tc.name, n)
+		}
+		if got := fake.values("example.com", name, "TXT"); len(got) != 2 {
+			t.Errorf("%s: expected both values in one rrset, got %v", tc.name, fake.records)
+		}
+
+		for _, key := range []string{"key-1", "key-2"} {
+			ch.Key = key
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatalf("%s: CleanUp: %v", tc.name, err)
+			}
+		}
+		if len(fake.records) != 0 {
+			t.Errorf("%s: expected the record to be cleaned up, got %v", tc.name, fake.records)
+		}
+	}
+}
+
+func TestRecordNameFormFallbackLimits(t *testing.T) {
+	// Only a rejection of the name itself is retried in the other form.
+	fake := newFakeLiveDNS()
+	fake.failWith("create", &types.RequestError{StatusCode: 400, Err: fmt.Errorf("rrset_values: invalid value")})
+	var cache nameFormCache
+	client := withNameForm(context.Background(), fake, RecordNameAuto, &cache, "scope-a")
+	if _, err := client.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"v"}); err == nil {
+		t.Fatal("expected the create to fail")
+	}
+	if n := fake.countCalls("create"); n != 1 {
+		t.Errorf("expected a single create for an unrelated 400, got %d", n)
+	}
+
+	// A record that does not exist is read once.
+	if _, err := client.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT"); err == nil {
+		t.Fatal("expected the record not to be found")
+	}
+	if n := fake.countCalls("get"); n != 1 {
+		t.Errorf("expected a single get for a 404, got %d", n)
+	}
+
+	// A detected form is only reused with the same credentials.
+	cache.put(context.Background(), "scope-a", "example.com", RecordNameQualified)
+	if got := cache.get("scope-b", "example.com"); got != "" {
+		t.Errorf("expected no form cached for other credentials, got %q", got)
+	}
+	if nameFormScope("pat-1", "") == nameFormScope("pat-2", "") || nameFormScope("pat", "a") == nameFormScope("pat", "b") {
+		t.Error("expected distinct scopes for distinct credentials")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// MaxFQDNDepthEnv overrides DefaultMaxFQDNDepth.
+	MaxFQDNDepthEnv = "MAX_FQDN_DEPTH"
+	// DefaultMaxFQDNDepth is the default maximum number of labels of a
+	// challenge FQDN. Real challenges stay far below it, while the DNS allows
+	// up to 127 labels.
+	DefaultMaxFQDNDepth = 32
+	// DefaultChallengePrefix is the label of ACME DNS-01 challenge records.
+	DefaultChallengePrefix = "_acme-challenge"
+)
+
+// checkFQDNDepth rejects fqdn when it has more than max labels, the trailing
+// dot of a fully qualified name not counting as an empty label.
+func checkFQDNDepth(fqdn string, max int) error {
+	labels := strings.Count(strings.TrimSuffix(fqdn, "."), ".") + 1
+	if labels > max {
+		return fmt.Errorf("FQDN %q has %d labels, more than the maximum of %d", fqdn, labels, max)
+	}
+	return nil
+}
+
+// normalizeFQDN collapses the dots ending name, as left by tooling appending
+// a dot to an already qualified name, into a single one. It rejects a name
+// with an empty label elsewhere, such as `a..b.example.com.` or
+// `.example.com.`, which no trimming can turn into the intended record.
+func normalizeFQDN(name string) (string, error) {
+	trimmed := strings.TrimRight(name, ".")
+	if strings.HasPrefix(trimmed, ".") || strings.Contains(trimmed, "..") {
+		return "", fmt.Errorf("%q has an empty label", name)
+	}
+	if trimmed != name {
+		trimmed += "."
+	}
+	return trimmed, nil
+}
+
+// zoneFromList returns the name of fqdn relative to the longest of zones it
+// is under, on a label boundary, and that zone without its trailing dot. It
+// returns two empty strings when fqdn is under none of them.
+func zoneFromList(fqdn string, zones []string) (string, string) {
+	entries := make(map[string]struct{}, len(zones))
+	for _, z := range zones {
+		entries[z] = struct{}{}
+	}
+	zone := strings.TrimSuffix(matchZoneSuffix(entries, fqdn), ".")
+	if zone == "" {
+		return "", ""
+	}
+	name := strings.TrimSuffix(fqdn, ".")
+	name = strings.TrimSuffix(name[:len(name)-len(zone)], ".")
+	return name, strings.ToLower(zone)
+}
+
+// validateRecordName checks that name, relative to its zone, only holds
+// characters Gandi stores verbatim: letters, digits, hyphens and underscores,
+// the latter anywhere in a label as in `_acme-challenge` or `my_host`, so that
+// escaped or percent-encoded forms produced by other DNS tooling
+// (`\095acme-challenge`, `%5Facme-challenge`) are rejected instead of being
+// written under a mangled name.
+func validateRecordName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty record name")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("record name %q has an empty label", name)
+		}
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			default:
+				return fmt.Errorf("record name %q has an unexpected character %q in label %q", name, r, label)
+			}
+		}
+	}
+	return nil
+}
+
+// checkExpectedDomain rejects the zone domain unless expected is empty or
+// domain is one of its domains or a subdomain of one. Names are compared
+// case-insensitively, ignoring trailing dots.
+func checkExpectedDomain(domain string, expected []string) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	entries := make(map[string]struct{}, len(expected))
+	for _, e := range expected {
+		entries[e] = struct{}{}
+	}
+	if matchZoneSuffix(entries, domain) == "" {
+		return fmt.Errorf("zone %q is not under any of the expected domains %v", domain, expected)
+	}
+	return nil
+}
+
+// Handling of a zone that is a public suffix, see checkPublicSuffix.
+const (
+	PublicSuffixWarn  = "warn"
+	PublicSuffixError = "error"
+	PublicSuffixOff   = "off"
+)
+
+// checkPublicSuffix looks domain up in the public suffix list: a zone such
+// as `co.uk` is shared by many registrants and writing records there is
+// almost certainly a misconfiguration. It warns, or errors when mode is
+// PublicSuffixError, and does nothing when mode is PublicSuffixOff.
+func checkPublicSuffix(ctx context.Context, domain, mode string) error {
+	if mode == PublicSuffixOff {
+		return nil
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if suffix, _ := publicsuffix.PublicSuffix(domain); suffix != domain {
+		return nil
+	}
+	if mode == PublicSuffixError {
+		return fmt.Errorf("zone %q is a public suffix, check the zone of the challenge", domain)
+	}
+	klog.FromContext(ctx).Info("zone is a public suffix, check the zone of the challenge", "zone", domain)
+	return nil
+}
+
+// checkChallengePrefix warns when the record name, relative to domain, does
+// not start with the prefix label: the derived name is then unlikely to be
+// the one the ACME server looks up.
+func checkChallengePrefix(ctx context.Context, name, domain, prefix string) bool {
+	first, _, _ := strings.Cut(name, ".")
+	if strings.EqualFold(first, prefix) {
+		return true
+	}
+	klog.FromContext(ctx).Info("record name does not start with the expected challenge label, check the issuer and zone of the challenge",
+		"name", name, "zone", domain, "prefix", prefix)
+	return false
+}
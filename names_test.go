@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestValidateRecordName(t *testing.T) {
+	for _, name := range []string{"_acme-challenge", "_acme-challenge.www", "_acme-challenge.sub-1.a", "acme_challenge", "_acme-challenge.my_host"} {
+		if err := validateRecordName(name); err != nil {
+			t.Errorf("validateRecordName(%q): unexpected error %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "\\095acme-challenge", "%5Facme-challenge", "_acme-challenge..www", "_acme challenge"} {
+		if err := validateRecordName(name); err == nil {
+			t.Errorf("validateRecordName(%q): expected an error", name)
+		}
+	}
+}
+
+func TestPresentKeepsUnderscoreLabel(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); strings.Join(got, ",") != "key" {
+		t.Errorf("expected the record under the exact _acme-challenge name, got %v", gandiClient.records)
+	}
+
+	ch := newTestChallenge("key")
+	ch.ResolvedFQDN = "\\095acme-challenge.example.com."
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected Present to reject a mangled name")
+	}
+}
+
+func TestDefaultRecordName(t *testing.T) {
+	cases := []struct {
+		fqdn, zone    string
+		entry, domain string
+	}{
+		{"_acme-challenge.example.com.", "example.com.", "_acme-challenge", "example.com"},
+		{"_acme-challenge.www.example.com.", "example.com.", "_acme-challenge.www", "example.com"},
+		{"_acme-challenge.sub.example.co.uk.", "sub.example.co.uk.", "_acme-challenge", "sub.example.co.uk"},
+		{"_acme-challenge.example.com..", "example.com.", "_acme-challenge", "example.com"},
+		{"_acme-challenge.www.example.com.", "example.com...", "_acme-challenge.www", "example.com"},
+	}
+	solver := &gandiDNSProviderSolver{}
+	for _, tc := range cases {
+		ch := &v1alpha1.ChallengeRequest{ResolvedFQDN: tc.fqdn, ResolvedZone: tc.zone}
+		entry, domain, err := solver.getDomainAndChallengeFQDN(context.Background(), ch, nil)
+		if err != nil {
+			t.Errorf("getDomainAndChallengeFQDN(%s, %s): %v", tc.fqdn, tc.zone, err)
+		}
+		if entry != tc.entry || domain != tc.domain {
+			t.Errorf("getDomainAndChallengeFQDN(%s, %s) = %q, %q, want %q, %q",
+				tc.fqdn, tc.zone, entry, domain, tc.entry, tc.domain)
+		}
+	}
+}
+
+func TestCustomRecordName(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	solver.recordName = func(*v1alpha1.ChallengeRequest) (string, string) {
+		return "_acme-challenge.delegated", "example.net"
+	}
+
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.net", "_acme-challenge.delegated", "TXT"); strings.Join(got, ",") != "key" {
+		t.Errorf("expected the record at the custom name, got %v", gandiClient.records)
+	}
+}
+
+func TestCheckFQDNDepth(t *testing.T) {
+	if err := checkFQDNDepth("_acme-challenge.example.com.", 3); err != nil {
+		t.Errorf("a 3 labels FQDN should be accepted with a limit of 3: %v", err)
+	}
+	if err := checkFQDNDepth("_acme-challenge.www.example.com.", 3); err == nil {
+		t.Error("a 4 labels FQDN should be rejected with a limit of 3")
+	}
+
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.ResolvedFQDN = "_acme-challenge." + strings.Repeat("a.", DefaultMaxFQDNDepth) + testZone
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected Present to reject a deep FQDN")
+	}
+	if err := solver.CleanUp(ch); err == nil {
+		t.Error("expected CleanUp to reject a deep FQDN")
+	}
+	if n := gandiClient.countCalls(""); n != 0 {
+		t.Errorf("no API call should be made for a rejected FQDN, got %d", n)
+	}
+}
+
+func FuzzGetDomainAndChallengeFQDN(f *testing.F) {
+	f.Add("_acme-challenge", "example.com.")
+	f.Add("_acme-challenge.www", "example.com.")
+	f.Add("", "example.com.")
+	f.Add("_acme-challenge", ".")
+	f.Add("a..b", "example..com")
+	f.Add("_acme-challenge.", "")
+
+	solver := &gandiDNSProviderSolver{}
+	f.Fuzz(func(t *testing.T, name, zone string) {
+		// Arbitrary inputs must never panic.
+		solver.getDomainAndChallengeFQDN(context.Background(), &v1alpha1.ChallengeRequest{ResolvedFQDN: name, ResolvedZone: zone}, nil)
+
+		// A well-formed challenge is a name under a non-empty zone, both
+		// fully qualified.
+		zone = strings.Trim(zone, ".") + "."
+		name = strings.Trim(name, ".")
+		if zone == "." || name == "" {
+			return
+		}
+		fqdn := name + "." + zone
+		entry, domain, err := solver.getDomainAndChallengeFQDN(context.Background(), &v1alpha1.ChallengeRequest{ResolvedFQDN: fqdn, ResolvedZone: zone}, nil)
+		if err != nil {
+			if strings.Contains(fqdn, "..") {
+				return
+			}
+			t.Fatalf("unexpected error for %q: %v", fqdn, err)
+		}
+		if domain == "" || !strings.HasSuffix(strings.TrimSuffix(fqdn, "."), domain) {
+			t.Fatalf("domain %q is not a non-empty suffix of %q", domain, fqdn)
+		}
+		if entry+"."+domain+"." != fqdn {
+			t.Fatalf("entry %q and domain %q do not rebuild %q", entry, domain, fqdn)
+		}
+	})
+}
+
+func TestZoneFromList(t *testing.T) {
+	zones := []string{"example.com", "sub.example.com.", "ample.com", "example.co.uk"}
+	cases := []struct {
+		fqdn          string
+		entry, domain string
+	}{
+		{"_acme-challenge.example.com.", "_acme-challenge", "example.com"},
+		{"_acme-challenge.www.example.com.", "_acme-challenge.www", "example.com"},
+		{"_acme-challenge.sub.example.com.", "_acme-challenge", "sub.example.com"},
+		{"_acme-challenge.a.sub.example.com.", "_acme-challenge.a", "sub.example.com"},
+		{"_acme-challenge.Sub.Example.COM.", "_acme-challenge", "sub.example.com"},
+		{"_acme-challenge.example.co.uk.", "_acme-challenge", "example.co.uk"},
+		{"_acme-challenge.example.net.", "", ""},
+		{"_acme-challenge.notexample.com.", "", ""},
+	}
+	for _, tc := range cases {
+		entry, domain := zoneFromList(tc.fqdn, zones)
+		if entry != tc.entry || domain != tc.domain {
+			t.Errorf("zoneFromList(%s) = %q, %q, want %q, %q", tc.fqdn, entry, domain, tc.entry, tc.domain)
+		}
+	}
+
+	// The list wins over the zone resolved by cert-manager, and a FQDN out
+	// of it is rejected.
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.ResolvedFQDN = "_acme-challenge.www.sub.example.com."
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "zones": ["example.com", "sub.example.com"]}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("sub.example.com", "_acme-challenge.www", "TXT"); len(got) != 1 {
+		t.Errorf("expected the record in sub.example.com, got %v", gandiClient.records)
+	}
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "zones": ["example.net"]}`)
+	if err := solver.Present(ch); err == nil || !strings.Contains(err.Error(), "not under any of the configured zones") {
+		t.Errorf("expected a FQDN out of the zones to be rejected, got %v", err)
+	}
+	if _, err := loadConfig(&extapi.JSON{Raw: []byte(`{"zones": ["a..b"]}`)}); err == nil {
+		t.Error("expected an invalid zone to be rejected")
+	}
+}
+
+func TestEmptyInteriorLabelsRejected(t *testing.T) {
+	solver := &gandiDNSProviderSolver{}
+	for _, tc := range []struct{ fqdn, zone string }{
+		{"_acme-challenge.a..b.example.com.", "example.com."},
+		{"_acme-challenge..example.com.", "example.com."},
+		{".example.com.", "example.com."},
+		{"_acme-challenge.example..com.", "example..com."},
+	} {
+		ch := &v1alpha1.ChallengeRequest{ResolvedFQDN: tc.fqdn, ResolvedZone: tc.zone}
+		if _, _, err := solver.getDomainAndChallengeFQDN(context.Background(), ch, nil); err == nil || !strings.Contains(err.Error(), "empty label") {
+			t.Errorf("getDomainAndChallengeFQDN(%s, %s): expected an empty label error, got %v", tc.fqdn, tc.zone, err)
+		}
+	}
+
+	gandiClient := newFakeLiveDNS()
+	ch := newTestChallenge("key")
+	ch.ResolvedFQDN = "_acme-challenge.a..b.example.com."
+	if err := newTestSolver(gandiClient).Present(ch); err == nil {
+		t.Error("expected Present to reject an empty interior label")
+	}
+	if n := gandiClient.countCalls(""); n != 0 {
+		t.Errorf("no API call should be made for a malformed FQDN, got %d", n)
+	}
+}
+
+func TestCheckExpectedDomain(t *testing.T) {
+	expected := []string{"example.com", "example.org."}
+	for _, domain := range []string{"example.com", "sub.example.com", "EXAMPLE.ORG"} {
+		if err := checkExpectedDomain(domain, expected); err != nil {
+			t.Errorf("%s should be accepted: %v", domain, err)
+		}
+	}
+	for _, domain := range []string{"example.net", "notexample.com", "com"} {
+		if err := checkExpectedDomain(domain, expected); err == nil {
+			t.Errorf("%s should be rejected", domain)
+		}
+	}
+	if err := checkExpectedDomain("example.net", nil); err != nil {
+		t.Errorf("the guard should be off without expected domains: %v", err)
+	}
+
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "expectedDomains": ["example.net"]}`)
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected Present to reject an unexpected zone")
+	}
+	if err := solver.CleanUp(ch); err == nil {
+		t.Error("expected CleanUp to reject an unexpected zone")
+	}
+	if n := gandiClient.countCalls(""); n != 0 {
+		t.Errorf("no API call should be made for an unexpected zone, got %d", n)
+	}
+}
+
+func TestDebugFQDNKeepsResult(t *testing.T) {
+	solver := &gandiDNSProviderSolver{debugFQDN: true}
+	ch := &v1alpha1.ChallengeRequest{ResolvedFQDN: testFQDN, ResolvedZone: testZone}
+	entry, domain, _ := solver.getDomainAndChallengeFQDN(context.Background(), ch, nil)
+	wantEntry, wantDomain := defaultRecordName(ch)
+	if entry != wantEntry || domain != wantDomain {
+		t.Errorf("DEBUG_FQDN changed the result: %q, %q, want %q, %q", entry, domain, wantEntry, wantDomain)
+	}
+}
+
+func TestCheckChallengePrefix(t *testing.T) {
+	for _, name := range []string{"_acme-challenge", "_ACME-Challenge.sub", "_acme-challenge.a.b"} {
+		if !checkChallengePrefix(context.Background(), name, "example.com", DefaultChallengePrefix) {
+			t.Errorf("%s should match the default prefix", name)
+		}
+	}
+	for _, name := range []string{"www", "sub._acme-challenge", "_acme-challenge2"} {
+		if checkChallengePrefix(context.Background(), name, "example.com", DefaultChallengePrefix) {
+			t.Errorf("%s should not match the default prefix", name)
+		}
+	}
+	if !checkChallengePrefix(context.Background(), "_custom-challenge.sub", "example.com", "_custom-challenge") {
+		t.Error("a configured prefix should be honoured")
+	}
+
+	for _, prefix := range []string{"acme", "_a.b", "_a b"} {
+		raw := []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "challengePrefix": "` + prefix + `"}`)
+		if _, err := loadConfig(&extapi.JSON{Raw: raw}); err == nil {
+			t.Errorf("challengePrefix %q should be rejected", prefix)
+		}
+	}
+}
+
+func TestRecordNameLowercased(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.ResolvedFQDN = "_ACME-Challenge.WWW.example.com."
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge.www", "TXT"); strings.Join(got, ",") != "key" {
+		t.Fatalf("expected the record under its lowercase name, got %v", gandiClient.records)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if len(gandiClient.records) != 0 {
+		t.Errorf("expected the record to be cleaned up, got %v", gandiClient.records)
+	}
+
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "preserveNameCase": true}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	if got := gandiClient.values("example.com", "_ACME-Challenge.WWW", "TXT"); len(got) != 1 {
+		t.Errorf("expected the name case to be preserved, got %v", gandiClient.records)
+	}
+}
+
+func TestCheckPublicSuffix(t *testing.T) {
+	for _, domain := range []string{"example.com", "example.co.uk.", "sub.example.com"} {
+		if err := checkPublicSuffix(context.Background(), domain, PublicSuffixError); err != nil {
+			t.Errorf("%s: unexpected error: %v", domain, err)
+		}
+	}
+	for _, domain := range []string{"co.uk", "COM.", "github.io"} {
+		if err := checkPublicSuffix(context.Background(), domain, PublicSuffixError); err == nil {
+			t.Errorf("%s: expected a public suffix error", domain)
+		}
+		if err := checkPublicSuffix(context.Background(), domain, ""); err != nil {
+			t.Errorf("%s: expected only a warning by default, got %v", domain, err)
+		}
+		if err := checkPublicSuffix(context.Background(), domain, PublicSuffixOff); err != nil {
+			t.Errorf("%s: expected no check when off, got %v", domain, err)
+		}
+	}
+
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallenge("key")
+	ch.ResolvedZone, ch.ResolvedFQDN = "co.uk.", "_acme-challenge.co.uk."
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "publicSuffixCheck": "error"}`)
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected Present to refuse a public suffix zone")
+	}
+	if n := gandiClient.countCalls(""); n != 0 {
+		t.Errorf("expected no Gandi call, got %d", n)
+	}
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "publicSuffixCheck": "deny"}`)
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected an unknown publicSuffixCheck to be rejected")
+	}
+}
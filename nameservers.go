@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// nameserverCacheTTL is how long the warmed-up nameservers of a zone are
+	// reused before being discovered again.
+	nameserverCacheTTL = 10 * time.Minute
+	// maxIdleNameserverConns bounds the sockets a warmed-up resolver keeps
+	// open to each nameserver between lookups.
+	maxIdleNameserverConns = 2
+)
+
+// nsResolver looks up the NS records of a zone and the addresses of its
+// nameservers.
+type nsResolver interface {
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// discoverNameservers returns the `host:53` addresses of the nameservers
+// of zone, as published in the DNS.
+func discoverNameservers(ctx context.Context, resolver nsResolver, zone string) ([]string, error) {
+	records, err := resolver.LookupNS(ctx, zone)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up the nameservers of %s: %v", zone, err)
+	}
+	servers := make([]string, 0, len(records))
+	for _, ns := range records {
+		host := strings.TrimSuffix(ns.Host, ".")
+		if host == "" {
+			continue
+		}
+		servers = append(servers, net.JoinHostPort(host, "53"))
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameserver found for %s", zone)
+	}
+	return servers, nil
+}
+
+// resolveNameservers replaces the host of each `host:port` server by its
+// addresses, so that lookups dial them directly instead of resolving the
+// nameserver names again. Servers whose host cannot be resolved are kept as
+// they are. It also returns, for each address, the server it belongs to.
+func resolveNameservers(ctx context.Context, resolver nsResolver, servers []string) (resolved, hosts []string) {
+	for _, server := range servers {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			resolved, hosts = append(resolved, server), append(hosts, server)
+			continue
+		}
+		addrs, err := resolver.LookupHost(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			klog.FromContext(ctx).V(6).Info("verify: unable to resolve nameserver, keeping its name", "nameserver", host, "err", err)
+			resolved, hosts = append(resolved, server), append(hosts, server)
+			continue
+		}
+		for _, addr := range addrs {
+			resolved, hosts = append(resolved, net.JoinHostPort(addr, port)), append(hosts, server)
+		}
+	}
+	return resolved, hosts
+}
+
+// warmUpNameservers returns a resolver querying the addresses of servers,
+// each server keeping a single vote in a quorum, with their sockets reused.
+func warmUpNameservers(ctx context.Context, resolver nsResolver, servers []string) authoritativeResolver {
+	resolved, hosts := resolveNameservers(ctx, resolver, servers)
+	r := newAuthoritativeResolver(resolved, true)
+	r.hosts = hosts
+	return r
+}
+
+// nameserverCache holds the warmed-up nameservers of each zone, for up to
+// its zone limit. Its zero value is ready to use.
+type nameserverCache struct {
+	mu      sync.Mutex
+	entries zoneLRU[nameserverEntry]
+}
+
+type nameserverEntry struct {
+	resolver authoritativeResolver
+	expires  time.Time
+}
+
+// get returns the resolver cached for zone, if it has not expired.
+func (n *nameserverCache) get(zone string, now time.Time) (authoritativeResolver, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	e, ok := n.entries.get(zone)
+	if !ok || now.After(e.expires) {
+		return authoritativeResolver{}, false
+	}
+	return e.resolver, true
+}
+
+// put caches resolver for zone until now plus nameserverCacheTTL.
+func (n *nameserverCache) put(zone string, resolver authoritativeResolver, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries.put(zone, nameserverEntry{resolver: resolver, expires: now.Add(nameserverCacheTTL)})
+}
+
+// setZoneLimit bounds the number of zones cached, see zoneLRU.
+func (n *nameserverCache) setZoneLimit(limit int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries.setLimit(limit)
+}
+
+// authoritativeResolver queries the given nameservers directly, bypassing
+// the caches of recursive resolvers. Servers are tried in order until one
+// answers.
+type authoritativeResolver struct {
+	servers []string
+	// resolvers holds the resolver of each server, reused across lookups.
+	resolvers []txtResolver
+	// hosts holds the nameserver each server is an address of, when warmed
+	// up; nil when each server is a nameserver of its own.
+	hosts []string
+}
+
+// host returns the nameserver servers[i] is an address of.
+func (r authoritativeResolver) host(i int) string {
+	if r.hosts == nil {
+		return r.servers[i]
+	}
+	return r.hosts[i]
+}
+
+// nameserverCount returns the number of nameservers behind the servers.
+func (r authoritativeResolver) nameserverCount() int {
+	seen := map[string]bool{}
+	for i := range r.servers {
+		seen[r.host(i)] = true
+	}
+	return len(seen)
+}
+
+// newAuthoritativeResolver returns an authoritativeResolver for servers. With
+// reuse, the UDP sockets of each server are kept open between lookups.
+func newAuthoritativeResolver(servers []string, reuse bool) authoritativeResolver {
+	r := authoritativeResolver{servers: servers, resolvers: make([]txtResolver, len(servers))}
+	for i, server := range servers {
+		var conns *serverConns
+		if reuse {
+			conns = &serverConns{idle: make(chan *net.UDPConn, maxIdleNameserverConns)}
+		}
+		r.resolvers[i] = serverResolver(server, conns)
+	}
+	return r
+}
+
+// serverResolver returns a resolver sending every query to server, over the
+// sockets of conns when not nil.
+func serverResolver(server string, conns *serverConns) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if conns != nil {
+				return conns.dial(ctx, network, server)
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// serverConns keeps the UDP sockets of a nameserver open between lookups, so
+// that the lookups of a warmed-up resolver do not each open their own. A
+// socket serves one lookup at a time, and the resolver skips the late
+// answers of a previous one by their ID. TCP connections, used for truncated
+// answers, are not kept. The sockets of an expired resolver are closed once
+// garbage collected.
+type serverConns struct {
+	idle chan *net.UDPConn
+}
+
+// dial returns an idle UDP socket to server, or a new connection.
+func (s *serverConns) dial(ctx context.Context, network, server string) (net.Conn, error) {
+	if strings.HasPrefix(network, "udp") {
+		select {
+		case c := <-s.idle:
+			return &pooledConn{UDPConn: c, conns: s}, nil
+		default:
+		}
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	if udp, ok := c.(*net.UDPConn); ok {
+		return &pooledConn{UDPConn: udp, conns: s}, nil
+	}
+	return c, nil
+}
+
+// pooledConn is a UDP socket of serverConns, put back with the idle ones
+// when the lookup closes it.
+type pooledConn struct {
+	*net.UDPConn
+	conns *serverConns
+}
+
+func (c *pooledConn) Close() error {
+	select {
+	case c.conns.idle <- c.UDPConn:
+		return nil
+	default:
+		return c.UDPConn.Close()
+	}
+}
+
+func (r authoritativeResolver) LookupTXT(ctx context.Context, fqdn string) ([]string, error) {
+	logger := klog.FromContext(ctx)
+	var lastErr error
+	for i, server := range r.servers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		values, err := r.resolvers[i].LookupTXT(ctx, fqdn)
+		if err == nil {
+			return values, nil
+		}
+		logger.V(6).Info("verify: lookup failed", "fqdn", fqdn, "server", server, "err", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// quorumResolver queries every nameserver of an authoritativeResolver and
+// only returns the values a quorum of them agrees on, so that a lookup
+// settles once need nameservers confirm the change: when waiting for a value
+// to be present, the values held by at least need nameservers, and when
+// waiting for it to be gone, the values held by all but fewer than need
+// nameservers. A nameserver has one vote however many addresses it has, the
+// first of them to answer casting it.
+type quorumResolver struct {
+	authoritativeResolver
+	need    int
+	present bool
+}
+
+func (r quorumResolver) LookupTXT(ctx context.Context, fqdn string) ([]string, error) {
+	logger := klog.FromContext(ctx)
+	holders := map[string]int{}
+	var order []string
+	answered := map[string]bool{}
+	var lastErr error
+	for i, server := range r.servers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		host := r.host(i)
+		if answered[host] {
+			continue
+		}
+		values, err := r.resolvers[i].LookupTXT(ctx, fqdn)
+		if err != nil && !isNXDomain(err) {
+			logger.V(6).Info("verify: lookup failed", "fqdn", fqdn, "server", server, "err", err)
+			lastErr = err
+			continue
+		}
+		answered[host] = true
+		for _, v := range uniqueValues(values) {
+			if holders[v] == 0 {
+				order = append(order, v)
+			}
+			holders[v]++
+		}
+	}
+	if len(answered) == 0 {
+		return nil, lastErr
+	}
+
+	var agreed []string
+	for _, v := range order {
+		if r.present && holders[v] >= r.need || !r.present && len(answered)-holders[v] < r.need {
+			agreed = append(agreed, v)
+		}
+	}
+	logger.V(6).Info("verify: nameservers answered", "fqdn", fqdn, "answered", len(answered), "nameservers", r.nameserverCount(), "need", r.need, "holders", holders)
+	return agreed, nil
+}
+
+// withQuorum returns resolver requiring the quorum of v, when it queries
+// the nameservers of the zone and the quorum is more than one of them.
+// present tells whether the lookups wait for a value to be present or gone.
+func (v verificationConfig) withQuorum(resolver txtResolver, present bool) txtResolver {
+	auth, ok := resolver.(authoritativeResolver)
+	if !ok {
+		return resolver
+	}
+	need := v.quorumSize(auth.nameserverCount())
+	if need <= 1 {
+		return resolver
+	}
+	return quorumResolver{authoritativeResolver: auth, need: need, present: present}
+}
+
+// verificationResolver returns the resolver used to verify the propagation
+// of a record of zone. With v.Authoritative, the nameservers of the zone are
+// queried directly; when they cannot be discovered, the system resolver, or
+// the one of v.Resolver, is used instead unless v.SystemFallback is disabled.
+func (c *gandiDNSProviderSolver) verificationResolver(ctx context.Context, v verificationConfig, zone string) (txtResolver, error) {
+	var system txtResolver = systemResolver{}
+	switch {
+	case c.resolver != nil:
+		system = c.resolver
+	case v.Resolver != nil:
+		system = v.Resolver.txtResolver(nil)
+	}
+	if !v.Authoritative {
+		return system, nil
+	}
+
+	logger := klog.FromContext(ctx)
+	if v.WarmUp {
+		if r, ok := c.nameservers.get(zone, time.Now()); ok {
+			logger.V(6).Info("verify: reusing the warmed-up nameservers", "zone", zone, "servers", r.servers)
+			return r, nil
+		}
+	}
+
+	var nsLookup nsResolver = net.DefaultResolver
+	if c.nsResolver != nil {
+		nsLookup = c.nsResolver
+	}
+	servers, err := discoverNameservers(ctx, nsLookup, zone)
+	if err != nil {
+		if !v.systemFallback() {
+			return nil, err
+		}
+		logger.Info("verify: falling back to the system resolver", "err", err)
+		return system, nil
+	}
+	if !v.WarmUp {
+		logger.V(6).Info("verify: querying the nameservers", "zone", zone, "servers", servers)
+		return newAuthoritativeResolver(servers, false), nil
+	}
+	r := warmUpNameservers(ctx, nsLookup, servers)
+	c.nameservers.put(zone, r, time.Now())
+	logger.V(6).Info("verify: warmed up the nameservers", "zone", zone, "servers", r.servers)
+	return r, nil
+}
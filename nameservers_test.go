@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeNSResolver returns ns, or err when set, and the addresses of hosts.
+type fakeNSResolver struct {
+	ns    []*net.NS
+	err   error
+	hosts map[string][]string
+	// lookups counts the LookupNS calls.
+	lookups *int
+}
+
+func (r fakeNSResolver) LookupNS(context.Context, string) ([]*net.NS, error) {
+	if r.lookups != nil {
+		*r.lookups++
+	}
+	return r.ns, r.err
+}
+
+func (r fakeNSResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func TestDiscoverNameservers(t *testing.T) {
+	resolver := fakeNSResolver{ns: []*net.NS{{Host: "ns-1.gandi.net."}, {Host: "ns-2.gandi.net."}}}
+	servers, err := discoverNameservers(context.Background(), resolver, "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ns-1.gandi.net:53", "ns-2.gandi.net:53"}; !reflect.DeepEqual(servers, want) {
+		t.Errorf("discoverNameservers = %v, want %v", servers, want)
+	}
+
+	if _, err := discoverNameservers(context.Background(), fakeNSResolver{}, "example.com."); err == nil {
+		t.Error("expected an error without any NS record")
+	}
+}
+
+func TestVerificationResolver(t *testing.T) {
+	system := &fakeResolver{}
+	solver := &gandiDNSProviderSolver{resolver: system}
+	ctx := context.Background()
+
+	if r, err := solver.verificationResolver(ctx, verificationConfig{}, "example.com."); err != nil || r != system {
+		t.Errorf("expected the system resolver by default, got %v, %v", r, err)
+	}
+
+	solver.nsResolver = fakeNSResolver{ns: []*net.NS{{Host: "ns-1.gandi.net."}}}
+	r, err := solver.verificationResolver(ctx, verificationConfig{Authoritative: true}, "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth, ok := r.(authoritativeResolver); !ok || !reflect.DeepEqual(auth.servers, []string{"ns-1.gandi.net:53"}) {
+		t.Errorf("expected the zone nameservers to be queried, got %#v", r)
+	}
+
+	solver.nsResolver = fakeNSResolver{err: errors.New("SERVFAIL")}
+	if r, err := solver.verificationResolver(ctx, verificationConfig{Authoritative: true}, "example.com."); err != nil || r != system {
+		t.Errorf("expected a fallback to the system resolver, got %v, %v", r, err)
+	}
+	noFallback := false
+	if _, err := solver.verificationResolver(ctx, verificationConfig{Authoritative: true, SystemFallback: &noFallback}, "example.com."); err == nil {
+		t.Error("expected an error with the fallback disabled")
+	}
+}
+
+func TestVerificationResolverWarmUp(t *testing.T) {
+	lookups := 0
+	solver := &gandiDNSProviderSolver{nsResolver: fakeNSResolver{
+		ns:      []*net.NS{{Host: "ns-1.gandi.net."}, {Host: "ns-2.gandi.net."}},
+		hosts:   map[string][]string{"ns-1.gandi.net": {"192.0.2.1", "2001:db8::1"}},
+		lookups: &lookups,
+	}}
+	v := verificationConfig{Authoritative: true, WarmUp: true}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		r, err := solver.verificationResolver(ctx, v, "Example.com.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth := r.(authoritativeResolver)
+		want := []string{"192.0.2.1:53", "[2001:db8::1]:53", "ns-2.gandi.net:53"}
+		if !reflect.DeepEqual(auth.servers, want) {
+			t.Fatalf("expected the resolved nameservers %v, got %v", want, auth.servers)
+		}
+		if len(auth.resolvers) != len(auth.servers) {
+			t.Errorf("expected a resolver per server, got %d", len(auth.resolvers))
+		}
+		if hosts := []string{"ns-1.gandi.net:53", "ns-1.gandi.net:53", "ns-2.gandi.net:53"}; !reflect.DeepEqual(auth.hosts, hosts) {
+			t.Errorf("expected the addresses to be grouped as %v, got %v", hosts, auth.hosts)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("expected the nameservers to be discovered once, got %d lookups", lookups)
+	}
+
+	// Expired entries are discovered again.
+	if _, ok := solver.nameservers.get("example.com.", time.Now().Add(nameserverCacheTTL+time.Second)); ok {
+		t.Error("expected the entry to expire")
+	}
+}
+
+// serveTXT answers the TXT queries received on conn with value, recording
+// the address each one came from.
+func serveTXT(conn net.PacketConn, value string, mu *sync.Mutex, sources map[string]int) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+			continue
+		}
+		mu.Lock()
+		sources[addr.String()]++
+		mu.Unlock()
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+			Questions: query.Questions,
+		}
+		if q := query.Questions[0]; q.Type == dnsmessage.TypeTXT {
+			answer.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+				Body:   &dnsmessage.TXTResource{TXT: []string{value}},
+			}}
+		}
+		packed, err := answer.Pack()
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(packed, addr)
+	}
+}
+
+func TestWarmedUpResolverReusesSockets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var mu sync.Mutex
+	sources := map[string]int{}
+	go serveTXT(conn, "key", &mu, sources)
+
+	ctx := context.Background()
+	for _, reuse := range []bool{false, true} {
+		mu.Lock()
+		clear(sources)
+		mu.Unlock()
+		r := newAuthoritativeResolver([]string{conn.LocalAddr().String()}, reuse)
+		for i := 0; i < 3; i++ {
+			values, err := r.LookupTXT(ctx, "_acme-challenge.example.com.")
+			if err != nil || !reflect.DeepEqual(values, []string{"key"}) {
+				t.Fatalf("reuse %v: LookupTXT = %v, %v", reuse, values, err)
+			}
+		}
+		mu.Lock()
+		got := len(sources)
+		mu.Unlock()
+		if want := map[bool]int{false: 3, true: 1}[reuse]; got != want {
+			t.Errorf("reuse %v: expected the queries to come from %d sockets, got %d", reuse, want, got)
+		}
+	}
+}
+
+func TestWarmedUpQuorumVotesPerNameserver(t *testing.T) {
+	var mu sync.Mutex
+	sources := map[string]int{}
+	var servers []string
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		go serveTXT(conn, "key", &mu, sources)
+		_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+		servers = append(servers, net.JoinHostPort(fmt.Sprintf("ns-%d.gandi.net", i+1), port))
+	}
+	// The first address of ns-1 never answers, as the IPv6 addresses of an
+	// IPv4-only pod.
+	r := warmUpNameservers(context.Background(), fakeNSResolver{hosts: map[string][]string{
+		"ns-1.gandi.net": {"127.0.0.2", "127.0.0.1"},
+		"ns-2.gandi.net": {"127.0.0.1"},
+	}}, servers)
+	schedule := backoffSchedule{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond}
+
+	v := verificationConfig{Quorum: QuorumAll}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := pollTXT(ctx, v.withQuorum(r, true), "_acme-challenge.example.com.", "key", true, schedule, 0); err != nil {
+		t.Errorf("expected both nameservers to confirm the value: %v", err)
+	}
+	// A value neither nameserver holds is gone for both.
+	if err := pollTXT(ctx, v.withQuorum(r, false), "_acme-challenge.example.com.", "other", false, schedule, 0); err != nil {
+		t.Errorf("expected both nameservers to confirm the removal: %v", err)
+	}
+	if q := v.withQuorum(r, true).(quorumResolver); q.need != 2 {
+		t.Errorf("expected the quorum to be sized on the 2 nameservers, got %d", q.need)
+	}
+}
+
+// staticTXT answers every lookup with values, or err when set.
+type staticTXT struct {
+	values []string
+	err    error
+}
+
+func (r staticTXT) LookupTXT(context.Context, string) ([]string, error) {
+	return r.values, r.err
+}
+
+func TestVerificationQuorum(t *testing.T) {
+	nxdomain := &net.DNSError{Err: "no such host", IsNotFound: true}
+	servfail := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+	// The first nameserver has the value, the second lags behind, the
+	// third fails and the fourth has it too.
+	mixed := authoritativeResolver{
+		servers:   []string{"ns1:53", "ns2:53", "ns3:53", "ns4:53"},
+		resolvers: []txtResolver{staticTXT{values: []string{"key"}}, staticTXT{err: nxdomain}, staticTXT{err: servfail}, staticTXT{values: []string{"other", "key"}}},
+	}
+	schedule := backoffSchedule{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond}
+
+	cases := []struct {
+		quorum  string
+		present bool
+		settles bool
+	}{
+		{quorum: "", present: true, settles: true},
+		{quorum: QuorumMajority, present: true, settles: false},
+		{quorum: QuorumAll, present: true, settles: false},
+		{quorum: "", present: false, settles: false},
+		{quorum: QuorumMajority, present: false, settles: false},
+	}
+	for _, tc := range cases {
+		v := verificationConfig{Quorum: tc.quorum}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := pollTXT(ctx, v.withQuorum(mixed, tc.present), "_acme-challenge.example.com.", "key", tc.present, schedule, 0)
+		cancel()
+		if settled := err == nil; settled != tc.settles {
+			t.Errorf("quorum %q, present %v: settled = %v, want %v (%v)", tc.quorum, tc.present, settled, tc.settles, err)
+		}
+	}
+
+	// Three answers holding the value out of four are a majority.
+	mixed.resolvers[1] = staticTXT{values: []string{"key"}}
+	values, err := verificationConfig{Quorum: QuorumMajority}.withQuorum(mixed, true).LookupTXT(context.Background(), "_acme-challenge.example.com.")
+	if err != nil || !reflect.DeepEqual(values, []string{"key"}) {
+		t.Errorf("expected the majority to agree on the value only, got %v, %v", values, err)
+	}
+
+	// Once removed from three nameservers, the value is gone for a
+	// majority of them but not for all.
+	mixed.resolvers[0] = staticTXT{err: nxdomain}
+	mixed.resolvers[1] = staticTXT{values: []string{"other"}}
+	mixed.resolvers[2] = staticTXT{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	v := verificationConfig{Quorum: QuorumMajority}
+	if err := pollTXT(ctx, v.withQuorum(mixed, false), "_acme-challenge.example.com.", "key", false, schedule, 0); err != nil {
+		t.Errorf("expected the removal to be confirmed by a majority: %v", err)
+	}
+	if err := pollTXT(ctx, verificationConfig{Quorum: QuorumAll}.withQuorum(mixed, false), "_acme-challenge.example.com.", "key", false, schedule, 0); err == nil {
+		t.Error("expected the lagging nameserver to prevent a unanimous removal")
+	}
+
+	// Without authoritative nameservers the quorum does not apply.
+	if _, ok := v.withQuorum(systemResolver{}, true).(systemResolver); !ok {
+		t.Error("expected the system resolver to be used as is")
+	}
+	if err := (verificationConfig{Quorum: "most"}).validate(); err == nil {
+		t.Error("expected an unknown quorum to be rejected")
+	}
+}
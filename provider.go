@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// GlobalPATEnv holds the Personal Access Token used for the challenges
+	// issued without solver config, for single-tenant deployments. It is not
+	// SelfTestPATEnv, so that a token set for the self-test does not serve
+	// the challenges of issuers missing their config.
+	GlobalPATEnv = "GANDI_GLOBAL_PAT"
+	// GlobalSharingIDEnv holds the Gandi organization going with GlobalPATEnv.
+	GlobalSharingIDEnv = "GANDI_SHARING_ID"
+	// MaxSecretSizeEnv overrides DefaultMaxSecretSize.
+	MaxSecretSizeEnv = "MAX_SECRET_SIZE"
+	// DefaultMaxSecretSize is the default maximum size, in bytes, of a
+	// Personal Access Token. Gandi tokens are a few dozen characters, a
+	// larger value is most likely the wrong key or file.
+	DefaultMaxSecretSize = 4096
+)
+
+// gandiCredentials authenticate the calls made to Gandi for a zone.
+type gandiCredentials struct {
+	// PAT is the Personal Access Token.
+	PAT string
+	// SharingID is the Gandi organization to act on behalf of, may be empty.
+	SharingID string
+}
+
+// credentialProvider returns the Gandi credentials used for the zone domain
+// of a challenge issued in namespace with the solver config cfg.
+// Alternative sources, such as a token exchange against a projected service
+// account token, can be plugged in by setting the solver's credentials field.
+type credentialProvider interface {
+	Credentials(ctx context.Context, cfg gandiDNSProviderConfig, namespace, domain string) (gandiCredentials, error)
+}
+
+// globalCredentialsFromEnv returns the credentials configured by GlobalPATEnv
+// and GlobalSharingIDEnv, or nil when no token is set.
+func globalCredentialsFromEnv() *gandiCredentials {
+	pat := os.Getenv(GlobalPATEnv)
+	if strings.TrimSpace(pat) == "" {
+		return nil
+	}
+	return &gandiCredentials{PAT: pat, SharingID: os.Getenv(GlobalSharingIDEnv)}
+}
+
+// staticCredentialProvider returns the same credentials for every zone.
+type staticCredentialProvider gandiCredentials
+
+func (p staticCredentialProvider) Credentials(context.Context, gandiDNSProviderConfig, string, string) (gandiCredentials, error) {
+	return gandiCredentials(p), nil
+}
+
+// secretCredentialProvider is the default credentialProvider. It reads the
+// token from the Secret referenced by PATSecretRef or matched by
+// PATSecretSelector, or from the one routed to the zone by the credentials
+// ConfigMap.
+type secretCredentialProvider struct {
+	solver *gandiDNSProviderSolver
+}
+
+func (p secretCredentialProvider) Credentials(ctx context.Context, cfg gandiDNSProviderConfig, namespace, domain string) (gandiCredentials, error) {
+	secretName := cfg.PATSecretRef.LocalObjectReference.Name
+	secretKey := cfg.PATSecretRef.Key
+	sharingID := cfg.SharingID
+	selector := cfg.PATSecretSelector
+
+	zoneCreds, err := p.solver.lookupZoneCredentials(ctx, domain)
+	if err != nil {
+		return gandiCredentials{}, err
+	}
+	if zoneCreds != nil {
+		if !zoneCreds.allows(namespace) {
+			return gandiCredentials{}, fmt.Errorf("the credentials configmap entry of domain %s may not be used by challenges of namespace %s", domain, namespace)
+		}
+		namespace, secretName, secretKey = zoneCreds.Namespace, zoneCreds.Name, zoneCreds.Key
+		selector = nil
+		// The issuer's sharingID goes with the issuer's token.
+		sharingID = zoneCreds.SharingID
+	}
+
+	if secretKey == "" {
+		secretKey = DefaultPATSecretKey
+	}
+
+	if selector != nil {
+		sec, err := p.selectSecret(ctx, namespace, selector)
+		if err != nil {
+			return gandiCredentials{}, err
+		}
+		token, err := secretToken(sec, secretKey)
+		if err != nil {
+			return gandiCredentials{}, err
+		}
+		return gandiCredentials{PAT: token, SharingID: sharingID}, nil
+	}
+
+	ref := cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: secretName}, Key: secretKey}
+	token, err := p.solver.secretReader().GetToken(ctx, namespace, ref)
+	if err != nil {
+		return gandiCredentials{}, err
+	}
+	return gandiCredentials{PAT: token, SharingID: sharingID}, nil
+}
+
+// secretReader reads the token stored under ref.Key of the Secret ref.Name
+// of namespace. Sources other than Kubernetes Secrets, such as files or a
+// vault, can be plugged in by setting the solver's tokens field.
+type secretReader interface {
+	GetToken(ctx context.Context, namespace string, ref cmmeta.SecretKeySelector) (string, error)
+}
+
+// kubeSecretReader is the default secretReader, reading Secrets from the
+// informer cache of the solver when enabled or from the Kubernetes API.
+type kubeSecretReader struct {
+	solver *gandiDNSProviderSolver
+}
+
+func (r kubeSecretReader) GetToken(ctx context.Context, namespace string, ref cmmeta.SecretKeySelector) (string, error) {
+	klog.FromContext(ctx).V(6).Info("try to load secret", "name", ref.Name, "key", ref.Key)
+
+	ctx, span := tracer.Start(ctx, "getSecret")
+	span.SetAttributes(attribute.String("k8s.namespace.name", namespace), attribute.String("k8s.secret.name", ref.Name))
+	sec, err := r.solver.getSecret(ctx, namespace, ref.Name)
+	endSpan(span, err)
+	if err != nil {
+		return "", fmt.Errorf("unable to get secret `%s`; %v", ref.Name, err)
+	}
+	return secretToken(sec, ref.Key)
+}
+
+// secretToken returns the value of key in sec.
+func secretToken(sec *corev1.Secret, key string) (string, error) {
+	secBytes, ok := sec.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret \"%s/%s\"", key, sec.Namespace, sec.Name)
+	}
+	return string(secBytes), nil
+}
+
+// secretReader returns the tokens field of c, or the default reader.
+func (c *gandiDNSProviderSolver) secretReader() secretReader {
+	if c.tokens != nil {
+		return c.tokens
+	}
+	return kubeSecretReader{solver: c}
+}
+
+// selectSecret returns the only Secret of namespace matching selector.
+func (p secretCredentialProvider) selectSecret(ctx context.Context, namespace string, selector *metav1.LabelSelector) (*corev1.Secret, error) {
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PATSecretSelector: %v", err)
+	}
+	klog.FromContext(ctx).V(6).Info("try to find the secret matching the selector", "selector", sel.String(), "namespace", namespace)
+
+	ctx, span := tracer.Start(ctx, "listSecrets")
+	span.SetAttributes(attribute.String("k8s.namespace.name", namespace), attribute.String("k8s.secret.selector", sel.String()))
+	secrets, err := p.solver.listSecrets(ctx, namespace, sel)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list secrets matching `%s`; %v", sel, err)
+	}
+
+	switch len(secrets) {
+	case 0:
+		return nil, fmt.Errorf("no secret matches `%s` in namespace %s", sel, namespace)
+	case 1:
+		return secrets[0], nil
+	default:
+		names := make([]string, len(secrets))
+		for i, s := range secrets {
+			names[i] = s.Name
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%d secrets match `%s` in namespace %s, expected exactly one: %v",
+			len(secrets), sel, namespace, names)
+	}
+}
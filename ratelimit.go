@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// IssuerRateLimitEnv is the sustained number of Present and CleanUp calls
+	// per second allowed for each issuer. Rate limiting is disabled when unset
+	// or zero.
+	IssuerRateLimitEnv = "ISSUER_RATE_LIMIT"
+	// IssuerRateBurstEnv is the number of calls an issuer may make at once
+	// above the sustained rate, defaults to DefaultIssuerRateBurst.
+	IssuerRateBurstEnv = "ISSUER_RATE_BURST"
+	// DefaultIssuerRateBurst lets an issuer solve a few challenges at once,
+	// e.g. for a certificate with several names.
+	DefaultIssuerRateBurst = 10
+)
+
+// errRateLimited is returned by Present and CleanUp when the issuer exceeded
+// its rate. cert-manager retries the call later.
+var errRateLimited = errors.New("too many challenge operations for this issuer, try again later")
+
+// issuerLimiters holds a token bucket per issuer, as told apart by
+// batchScope. Its zero value allows every call.
+type issuerLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// issuerLimitersFromEnv reads the rate and burst of the limiters.
+func issuerLimitersFromEnv() (limit rate.Limit, burst int, err error) {
+	perSecond, err := envFloat(IssuerRateLimitEnv, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if perSecond < 0 {
+		return 0, 0, fmt.Errorf("%s must not be negative, got %v", IssuerRateLimitEnv, perSecond)
+	}
+	burst, err = envInt(IssuerRateBurstEnv, DefaultIssuerRateBurst)
+	if err != nil {
+		return 0, 0, err
+	}
+	if burst <= 0 {
+		return 0, 0, fmt.Errorf("%s must be positive, got %d", IssuerRateBurstEnv, burst)
+	}
+	return rate.Limit(perSecond), burst, nil
+}
+
+// Allow takes a token from the bucket of scope, reporting whether one was
+// left.
+func (l *issuerLimiters) Allow(scope string) bool {
+	if l.limit == 0 {
+		return true
+	}
+	l.mu.Lock()
+	limiter, ok := l.limiters[scope]
+	if !ok {
+		if l.limiters == nil {
+			l.limiters = map[string]*rate.Limiter{}
+		}
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[scope] = limiter
+	}
+	l.mu.Unlock()
+	return limiter.Allow()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIssuerRateLimit(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	// No refill within the test: only the burst is allowed.
+	solver.limiters.limit, solver.limiters.burst = 1e-9, 2
+	before := counterValue(t, rateLimitedTotal)
+
+	for i := 0; i < 2; i++ {
+		if err := solver.Present(newTestChallenge("key")); err != nil {
+			t.Fatalf("call %d within the burst: %v", i, err)
+		}
+	}
+	err := solver.CleanUp(newTestChallenge("key"))
+	if !errors.Is(err, errRateLimited) {
+		t.Fatalf("expected the issuer to be rate limited, got %v", err)
+	}
+	if !isRetriable(err) {
+		t.Error("a rate limited call should be retriable")
+	}
+	if got := counterValue(t, rateLimitedTotal) - before; got != 1 {
+		t.Errorf("expected one rate limited call to be counted, got %v", got)
+	}
+
+	// Another issuer has its own bucket.
+	other := newTestChallenge("key")
+	other.ResourceNamespace = "other"
+	if !solver.limiters.Allow(batchScope(other)) {
+		t.Error("another issuer should not be rate limited")
+	}
+}
+
+func TestIssuerRateLimitDisabledByDefault(t *testing.T) {
+	var l issuerLimiters
+	for i := 0; i < 100; i++ {
+		if !l.Allow("scope") {
+			t.Fatal("the zero limiters should allow every call")
+		}
+	}
+	limit, burst, err := issuerLimitersFromEnv()
+	if err != nil || limit != 0 || burst != DefaultIssuerRateBurst {
+		t.Errorf("unexpected defaults %v, %d, %v", limit, burst, err)
+	}
+	t.Setenv(IssuerRateBurstEnv, "0")
+	if _, _, err := issuerLimitersFromEnv(); err == nil {
+		t.Errorf("a zero %s should be rejected", IssuerRateBurstEnv)
+	}
+}
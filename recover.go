@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// recoveringLiveDNS turns a panic of the wrapped client, e.g. on a malformed
+// response, into an error of the call instead of a crash of the webhook.
+type recoveringLiveDNS struct {
+	liveDNSClient
+	// ctx is the context of the call, whose logger reports the panics.
+	ctx context.Context
+}
+
+// recoverCall converts a recovered panic of call into *err, logging its stack
+// with the logger of ctx. It must be deferred.
+func recoverCall(ctx context.Context, call string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	klog.FromContext(ctx).Error(fmt.Errorf("%v", r), "gandi: recovered from a panic", "call", call, "stack", string(debug.Stack()))
+	// Repeating the call would most likely panic again.
+	*err = &gandiError{err: fmt.Errorf("go-gandi panicked in %s: %v", call, r)}
+}
+
+func (r recoveringLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (record livedns.DomainRecord, err error) {
+	defer recoverCall(r.ctx, "GetDomainRecordByNameAndType", &err)
+	return r.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+}
+
+func (r recoveringLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	defer recoverCall(r.ctx, "CreateDomainRecord", &err)
+	return r.liveDNSClient.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+}
+
+func (r recoveringLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	defer recoverCall(r.ctx, "UpdateDomainRecordByNameAndType", &err)
+	return r.liveDNSClient.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+}
+
+func (r recoveringLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) (err error) {
+	defer recoverCall(r.ctx, "DeleteDomainRecord", &err)
+	return r.liveDNSClient.DeleteDomainRecord(fqdn, name, recordtype)
+}
+
+func (r recoveringLiveDNS) GetDomain(fqdn string) (domain livedns.Domain, err error) {
+	defer recoverCall(r.ctx, "GetDomain", &err)
+	return r.liveDNSClient.GetDomain(fqdn)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// panickingLiveDNS panics on every write.
+type panickingLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (panickingLiveDNS) CreateDomainRecord(string, string, string, int, []string) (types.StandardResponse, error) {
+	var resp *types.StandardResponse
+	return *resp, nil
+}
+
+func (panickingLiveDNS) DeleteDomainRecord(string, string, string) error {
+	panic("malformed response")
+}
+
+func TestRecoverFromGandiPanic(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient { return panickingLiveDNS{gandiClient} }
+
+	err := solver.Present(newTestChallenge("key"))
+	if err == nil || !strings.Contains(err.Error(), "panicked in CreateDomainRecord") {
+		t.Errorf("expected the panic to be returned as an error, got %v", err)
+	}
+	if isRetriable(err) {
+		t.Error("a panic should not be retried")
+	}
+
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"key"})
+	if err := solver.CleanUp(newTestChallenge("key")); err == nil || !strings.Contains(err.Error(), "malformed response") {
+		t.Errorf("expected the panic to be returned as an error, got %v", err)
+	}
+}
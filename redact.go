@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// redactedPlaceholder replaces secrets in messages.
+const redactedPlaceholder = "[REDACTED]"
+
+// redact returns s with every occurrence of each of secrets replaced.
+func redact(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+		}
+	}
+	return s
+}
+
+// redactedError hides secrets from the message of err while keeping err in
+// the chain, so that classifyGandiError still sees the go-gandi error.
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+func (e *redactedError) Error() string {
+	return redact(e.err.Error(), e.secrets...)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError returns err with secrets hidden from its message, nil for nil.
+func redactError(err error, secrets []string) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err, secrets: secrets}
+}
+
+// redactingLiveDNS hides the Personal Access Token from the errors of the
+// wrapped client, as those end up in logs and in the Challenge status.
+// apiLiveDNS never logs requests, and LOG_API_REQUESTS leaves out headers.
+type redactingLiveDNS struct {
+	liveDNSClient
+	// secrets are the token and, with decodeBase64, the Secret value it was
+	// decoded from.
+	secrets []string
+}
+
+func (r redactingLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := r.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	return record, redactError(err, r.secrets)
+}
+
+func (r redactingLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	resp, err := r.liveDNSClient.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	resp.Message = redact(resp.Message, r.secrets...)
+	return resp, redactError(err, r.secrets)
+}
+
+func (r redactingLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	resp, err := r.liveDNSClient.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+	resp.Message = redact(resp.Message, r.secrets...)
+	return resp, redactError(err, r.secrets)
+}
+
+func (r redactingLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	return redactError(r.liveDNSClient.DeleteDomainRecord(fqdn, name, recordtype), r.secrets)
+}
+
+func (r redactingLiveDNS) GetDomain(fqdn string) (livedns.Domain, error) {
+	domain, err := r.liveDNSClient.GetDomain(fqdn)
+	return domain, redactError(err, r.secrets)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRedact(t *testing.T) {
+	if got := redact("token abc in abc", "abc"); got != "token [REDACTED] in [REDACTED]" {
+		t.Errorf("unexpected redaction %q", got)
+	}
+	if got := redact("nothing to hide", ""); got != "nothing to hide" {
+		t.Errorf("an empty secret should leave the message untouched, got %q", got)
+	}
+}
+
+func TestErrorsDoNotLeakToken(t *testing.T) {
+	leaky := func(code int) error {
+		return &types.RequestError{StatusCode: code, Err: fmt.Errorf("%d: Authorization: Bearer %s", code, testToken)}
+	}
+
+	for _, call := range []string{"get", "create", "update", "delete"} {
+		for _, code := range []int{0, 401, 403, 500} {
+			gandiClient := newFakeLiveDNS()
+			solver := newTestSolver(gandiClient)
+			switch call {
+			case "update":
+				gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"other", "key"})
+			case "delete":
+				gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"key"})
+			}
+			err := leaky(code)
+			if code == 0 {
+				err = fmt.Errorf("dial tcp: token %s", testToken)
+			}
+			gandiClient.failWith(call, err)
+
+			errs := []error{
+				solver.Present(newTestChallenge("key")),
+				solver.CleanUp(newTestChallenge("key")),
+			}
+			failed := false
+			for _, err := range errs {
+				if err == nil {
+					continue
+				}
+				failed = true
+				if strings.Contains(err.Error(), testToken) {
+					t.Errorf("%s failing with %d leaked the token: %v", call, code, err)
+				}
+			}
+			if !failed {
+				t.Errorf("%s failing with %d: expected an error", call, code)
+			}
+		}
+	}
+
+	// Redaction keeps the error classifiable.
+	gandiClient := newFakeLiveDNS()
+	solver := newTestSolver(gandiClient)
+	gandiClient.failWith("get", leaky(403))
+	err := solver.Present(newTestChallenge("key"))
+	var reqErr *types.RequestError
+	if !errors.As(err, &reqErr) || !strings.Contains(err.Error(), "LiveDNS permissions") {
+		t.Errorf("expected a classified 403 error, got %v", err)
+	}
+}
+
+func TestErrorsDoNotLeakEncodedToken(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testToken))
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "encoded-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": []byte(encoded + "\n")},
+	}
+	gandiClient := newFakeLiveDNS()
+	gandiClient.failWith("get", fmt.Errorf("Authorization: Bearer %s, from %s", testToken, encoded))
+	solver := newTestSolver(gandiClient, secret)
+
+	cfg := gandiDNSProviderConfig{DecodeBase64: true}
+	cfg.PATSecretRef.Name = "encoded-credentials"
+	client, err := solver.getGandiClient(context.Background(), cfg, testNamespace, "example.com", config.Timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT")
+	if err == nil || strings.Contains(err.Error(), testToken) || strings.Contains(err.Error(), encoded) {
+		t.Errorf("expected both forms of the token to be redacted, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/spf13/pflag"
+)
+
+// ReportChallengesFlag switches the binary to printing the challenge records
+// of a domain instead of serving the webhook.
+const ReportChallengesFlag = "report-challenges"
+
+// hasFlag reports whether args hold the long flag name, with or without a
+// value.
+func hasFlag(args []string, name string) bool {
+	for _, a := range args {
+		if a == "--"+name || strings.HasPrefix(a, "--"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// queryChallengeRecords returns the current state of the TXT rrsets names of
+// domain. An rrset Gandi does not know is reported as absent.
+func queryChallengeRecords(gandiClient liveDNSClient, domain string, names []string) ([]rrsetState, error) {
+	states := make([]rrsetState, 0, len(names))
+	for _, name := range names {
+		state := newRrsetState(domain, name)
+		record, found, err := getChallengeRrset(context.Background(), gandiClient, domain, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TXT record %s of %s: %w", name, domain, classifyGandiError(err))
+		}
+		if found {
+			state.TTL = record.RrsetTTL
+			state.Values = record.RrsetValues
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// runReportChallenges prints the values and TTL of the challenge records of
+// a domain, read with the token from SelfTestPATEnv, so that operators can
+// compare them with the challenges cert-manager has in flight.
+func runReportChallenges(args []string, out io.Writer, newClient func(config.Config) liveDNSClient) error {
+	fs := pflag.NewFlagSet(ReportChallengesFlag, pflag.ContinueOnError)
+	fs.Bool(ReportChallengesFlag, false, "print the challenge TXT records of a domain, then exit")
+	domain := fs.String("domain", "", "Gandi LiveDNS domain to report on")
+	sharingID := fs.String("sharing-id", "", "Gandi organization ID owning the domain")
+	names := fs.StringSlice("name", []string{DefaultChallengePrefix}, "record names, relative to the domain, to report on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	*domain = strings.TrimSuffix(*domain, ".")
+	if *domain == "" {
+		return fmt.Errorf("--domain is required")
+	}
+	pat := os.Getenv(SelfTestPATEnv)
+	if pat == "" {
+		return fmt.Errorf("%s must hold the Personal Access Token to use", SelfTestPATEnv)
+	}
+	apiURL, err := apiURLFromEnv()
+	if err != nil {
+		return err
+	}
+
+	gandiClient := redactingLiveDNS{
+		liveDNSClient: newClient(config.Config{PersonalAccessToken: pat, SharingID: *sharingID, APIURL: apiURL}),
+		secrets:       []string{pat},
+	}
+	states, err := queryChallengeRecords(gandiClient, *domain, *names)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if !state.Exists() {
+			fmt.Fprintf(out, "%s.%s: no TXT record\n", state.Name, state.Domain)
+			continue
+		}
+		fmt.Fprintf(out, "%s.%s: %d values, ttl=%d\n", state.Name, state.Domain, len(state.Values), state.TTL)
+		for _, v := range state.Values {
+			fmt.Fprintf(out, "  %s\n", v)
+		}
+		// The marker, when the issuer enabled it, tells who wrote the values.
+		marker, found, err := getChallengeRrset(context.Background(), gandiClient, state.Domain, metaMarkerName(state.Name))
+		if err != nil {
+			return fmt.Errorf("unable to read marker of %s.%s: %w", state.Name, state.Domain, classifyGandiError(err))
+		}
+		if found {
+			fmt.Fprintf(out, "  marker: %s\n", strings.Join(marker.RrsetValues, " "))
+		}
+	}
+	return nil
+}
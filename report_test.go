@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+)
+
+func TestQueryChallengeRecords(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"key-1", "key-2"})
+
+	states, err := queryChallengeRecords(gandiClient, "example.com", []string{"_acme-challenge", "_acme-challenge.www"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || strings.Join(states[0].Values, ",") != "key-1,key-2" || states[0].TTL != GandiMinTtl {
+		t.Errorf("unexpected states %+v", states)
+	}
+	if states[1].Exists() {
+		t.Errorf("a missing record should be reported as absent, got %+v", states[1])
+	}
+
+	gandiClient.failWith("get", requestError(500))
+	if _, err := queryChallengeRecords(gandiClient, "example.com", []string{"_acme-challenge"}); err == nil {
+		t.Error("expected an error for a failing read")
+	}
+}
+
+func TestRunReportChallenges(t *testing.T) {
+	t.Setenv(SelfTestPATEnv, testToken)
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"key-1"})
+	newClient := func(config.Config) liveDNSClient { return gandiClient }
+
+	if !hasFlag([]string{"--report-challenges", "--domain=example.com"}, ReportChallengesFlag) {
+		t.Error("expected --report-challenges to be detected")
+	}
+
+	var out bytes.Buffer
+	args := []string{"--report-challenges", "--domain", "example.com.", "--name", "_acme-challenge,_acme-challenge.www"}
+	if err := runReportChallenges(args, &out, newClient); err != nil {
+		t.Fatalf("runReportChallenges: %v", err)
+	}
+	want := "_acme-challenge.example.com: 1 values, ttl=300\n  key-1\n_acme-challenge.www.example.com: no TXT record\n"
+	if out.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if err := runReportChallenges([]string{"--report-challenges"}, &out, newClient); err == nil {
+		t.Error("expected an error without --domain")
+	}
+}
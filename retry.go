@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// RetriesEnv is the default number of retries of a Gandi API call that
+	// failed with a retriable error, see isRetriable.
+	RetriesEnv = "GANDI_API_RETRIES"
+	// RetryInitialIntervalEnv is the default delay before the first retry.
+	RetryInitialIntervalEnv = "GANDI_API_RETRY_INITIAL_INTERVAL"
+	// RetryMaxIntervalEnv is the default cap on the delay between retries.
+	RetryMaxIntervalEnv = "GANDI_API_RETRY_MAX_INTERVAL"
+	// RetryReadOnlyIntervalEnv is the default minimum delay before retrying
+	// a call refused because the zone is read-only.
+	RetryReadOnlyIntervalEnv = "GANDI_API_RETRY_READ_ONLY_INTERVAL"
+
+	// MaxRetries bounds the configurable number of retries.
+	MaxRetries = 10
+	// DefaultRetryInitialInterval and DefaultRetryMaxInterval shape the
+	// delay between retries, which doubles after each one.
+	DefaultRetryInitialInterval = time.Second
+	DefaultRetryMaxInterval     = 10 * time.Second
+	// DefaultRetryReadOnlyInterval is long enough for the usual operations
+	// locking a zone not to be hammered while they run.
+	DefaultRetryReadOnlyInterval = 30 * time.Second
+)
+
+// retryPolicy is how many times and how fast a failed call is retried.
+type retryPolicy struct {
+	MaxRetries int
+	Backoff    backoffSchedule
+	// ReadOnlyInterval is the minimum delay before retrying a call failing
+	// with errZoneReadOnly, which lasts longer than other transient errors.
+	ReadOnlyInterval time.Duration
+}
+
+// validate rejects policies out of the supported ranges.
+func (p retryPolicy) validate() error {
+	if p.MaxRetries < 0 || p.MaxRetries > MaxRetries {
+		return fmt.Errorf("invalid number of retries %d: must be between 0 and %d", p.MaxRetries, MaxRetries)
+	}
+	if p.Backoff.Initial <= 0 {
+		return fmt.Errorf("invalid retry initialInterval %v: must be positive", p.Backoff.Initial)
+	}
+	if p.Backoff.Max < p.Backoff.Initial {
+		return fmt.Errorf("invalid retry maxInterval %v: lower than initialInterval %v", p.Backoff.Max, p.Backoff.Initial)
+	}
+	if p.ReadOnlyInterval <= 0 {
+		return fmt.Errorf("invalid retry readOnlyInterval %v: must be positive", p.ReadOnlyInterval)
+	}
+	return nil
+}
+
+// retryPolicyFromEnv returns the global retry policy. Calls are not retried
+// unless RetriesEnv is set.
+func retryPolicyFromEnv() (retryPolicy, error) {
+	retries, err := envInt(RetriesEnv, 0)
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	initial, err := envDuration(RetryInitialIntervalEnv, DefaultRetryInitialInterval)
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	maxInterval, err := envDuration(RetryMaxIntervalEnv, DefaultRetryMaxInterval)
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	readOnly, err := envDuration(RetryReadOnlyIntervalEnv, DefaultRetryReadOnlyInterval)
+	if err != nil {
+		return retryPolicy{}, err
+	}
+	p := retryPolicy{
+		MaxRetries:       retries,
+		Backoff:          backoffSchedule{Initial: initial, Multiplier: 2, Max: maxInterval},
+		ReadOnlyInterval: readOnly,
+	}
+	return p, p.validate()
+}
+
+// defaultRetryPolicy is the policy of solvers not initialized from the
+// environment: no retry.
+var defaultRetryPolicy = retryPolicy{
+	Backoff:          backoffSchedule{Initial: DefaultRetryInitialInterval, Multiplier: 2, Max: DefaultRetryMaxInterval},
+	ReadOnlyInterval: DefaultRetryReadOnlyInterval,
+}
+
+// retryConfig is the `retry` block of the solver config, overriding the
+// global policy field by field.
+type retryConfig struct {
+	MaxRetries      *int             `json:"maxRetries,omitempty"`
+	InitialInterval *metav1.Duration `json:"initialInterval,omitempty"`
+	MaxInterval     *metav1.Duration `json:"maxInterval,omitempty"`
+	// ReadOnlyInterval overrides RetryReadOnlyIntervalEnv.
+	ReadOnlyInterval *metav1.Duration `json:"readOnlyInterval,omitempty"`
+}
+
+// validate rejects the fields of r that are invalid whatever the global
+// policy; the merged policy is validated once built.
+func (r retryConfig) validate() error {
+	if r.MaxRetries != nil && (*r.MaxRetries < 0 || *r.MaxRetries > MaxRetries) {
+		return fmt.Errorf("invalid retry maxRetries %d: must be between 0 and %d", *r.MaxRetries, MaxRetries)
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"initialInterval":  r.InitialInterval,
+		"maxInterval":      r.MaxInterval,
+		"readOnlyInterval": r.ReadOnlyInterval,
+	} {
+		if d != nil && d.Duration <= 0 {
+			return fmt.Errorf("invalid retry %s %v: must be positive", name, d.Duration)
+		}
+	}
+	return nil
+}
+
+// policy returns defaults overridden by the fields set in r.
+func (r retryConfig) policy(defaults retryPolicy) retryPolicy {
+	p := defaults
+	if r.MaxRetries != nil {
+		p.MaxRetries = *r.MaxRetries
+	}
+	if r.InitialInterval != nil {
+		p.Backoff.Initial = r.InitialInterval.Duration
+	}
+	if r.MaxInterval != nil {
+		p.Backoff.Max = r.MaxInterval.Duration
+	}
+	if r.ReadOnlyInterval != nil {
+		p.ReadOnlyInterval = r.ReadOnlyInterval.Duration
+	}
+	return p
+}
+
+// retryingLiveDNS retries the calls of the wrapped client failing with a
+// retriable error, as dictated by policy. Waits are cut short when stop is
+// closed.
+//
+// It is the only retry layer of the Gandi calls: apiLiveDNS sends each call
+// as a single request, with no retry of its own, so a
+// call is sent at most 1+MaxRetries times. net/http only replays a GET whose
+// reused connection broke before any response, which Gandi never saw.
+type retryingLiveDNS struct {
+	liveDNSClient
+	policy retryPolicy
+	stop   <-chan struct{}
+	// ctx is the operation context: no attempt starts once it is done, nor
+	// any wait ending past its deadline. It may be nil.
+	ctx context.Context
+}
+
+// callContext returns r.ctx, or the background context when nil.
+func (r retryingLiveDNS) callContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// do runs call until it succeeds, fails with a non-retriable error or the
+// retries are exhausted.
+func (r retryingLiveDNS) do(name string, call func() error) error {
+	ctx := r.callContext()
+	var delay time.Duration
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			return &gandiError{kind: errOperationTimeout, msg: "gave up on " + name, err: ctx.Err()}
+		}
+		err := call()
+		readOnly := errors.Is(classifyGandiError(err), errZoneReadOnly)
+		if readOnly && attempt > 0 && attempt >= r.policy.MaxRetries {
+			return &gandiError{Retriable: true, kind: errZoneReadOnly, err: err,
+				msg: fmt.Sprintf("the zone was still read-only at Gandi after %d attempts of %s, an operation on the domain may be stuck", attempt+1, name)}
+		}
+		if err == nil || attempt >= r.policy.MaxRetries || !isRetriable(err) {
+			return err
+		}
+		delay = r.policy.Backoff.next(delay)
+		if readOnly {
+			delay = max(delay, r.policy.ReadOnlyInterval)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return &gandiError{kind: errOperationTimeout, msg: fmt.Sprintf("gave up retrying %s, the next attempt would be past the deadline", name), err: err}
+		}
+		klog.FromContext(ctx).V(6).Info("gandi: call failed, retrying", "call", name, "attempt", attempt+1, "delay", delay, "err", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return err
+		case <-ctx.Done():
+			timer.Stop()
+			return &gandiError{kind: errOperationTimeout, msg: "gave up retrying " + name, err: err}
+		case <-timer.C:
+		}
+	}
+}
+
+func (r retryingLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (record livedns.DomainRecord, err error) {
+	err = r.do("GetDomainRecordByNameAndType", func() error {
+		record, err = r.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+		return err
+	})
+	return record, err
+}
+
+// CreateDomainRecord re-reads the rrset before each retry: a create is not
+// idempotent, and one that failed with a timeout or a 5xx may still have
+// been applied, retrying it would then fail with a conflict.
+func (r retryingLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	attempt := 0
+	err = r.do("CreateDomainRecord", func() error {
+		attempt++
+		if attempt > 1 {
+			record, getErr := r.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+			if getErr == nil && sameValues(record.RrsetValues, values) {
+				klog.FromContext(r.callContext()).V(6).Info("gandi: the failed CreateDomainRecord was applied", "name", name, "type", recordtype)
+				resp = types.StandardResponse{}
+				return nil
+			}
+			if getErr != nil && !errors.Is(classifyGandiError(getErr), errRecordNotFound) {
+				return getErr
+			}
+		}
+		resp, err = r.liveDNSClient.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return resp, err
+}
+
+func (r retryingLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (resp types.StandardResponse, err error) {
+	err = r.do("UpdateDomainRecordByNameAndType", func() error {
+		resp, err = r.liveDNSClient.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return resp, err
+}
+
+func (r retryingLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	return r.do("DeleteDomainRecord", func() error {
+		return r.liveDNSClient.DeleteDomainRecord(fqdn, name, recordtype)
+	})
+}
+
+func (r retryingLiveDNS) GetDomain(fqdn string) (domain livedns.Domain, err error) {
+	err = r.do("GetDomain", func() error {
+		domain, err = r.liveDNSClient.GetDomain(fqdn)
+		return err
+	})
+	return domain, err
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// flakyLiveDNS fails its first failures reads with err.
+type flakyLiveDNS struct {
+	*fakeLiveDNS
+	mu       *sync.Mutex
+	failures *int
+	err      error
+}
+
+func (f flakyLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	f.mu.Lock()
+	if *f.failures > 0 {
+		*f.failures--
+		f.mu.Unlock()
+		return livedns.DomainRecord{}, f.err
+	}
+	f.mu.Unlock()
+	return f.fakeLiveDNS.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+}
+
+func TestRetryPolicyPrecedence(t *testing.T) {
+	t.Setenv(RetriesEnv, "3")
+	t.Setenv(RetryInitialIntervalEnv, "2s")
+	t.Setenv(RetryMaxIntervalEnv, "")
+	global, err := retryPolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.MaxRetries != 3 || global.Backoff.Initial != 2*time.Second || global.Backoff.Max != DefaultRetryMaxInterval ||
+		global.ReadOnlyInterval != DefaultRetryReadOnlyInterval {
+		t.Errorf("unexpected global policy %+v", global)
+	}
+
+	// Fields set on the issuer win, the others come from the global policy.
+	zero := 0
+	p := retryConfig{MaxRetries: &zero, MaxInterval: &metav1.Duration{Duration: 5 * time.Second}}.policy(global)
+	if p.MaxRetries != 0 || p.Backoff.Initial != 2*time.Second || p.Backoff.Max != 5*time.Second {
+		t.Errorf("unexpected issuer policy %+v", p)
+	}
+
+	for _, bad := range []retryPolicy{
+		{MaxRetries: -1, Backoff: global.Backoff, ReadOnlyInterval: time.Second},
+		{MaxRetries: MaxRetries + 1, Backoff: global.Backoff, ReadOnlyInterval: time.Second},
+		{Backoff: backoffSchedule{Initial: 0, Max: time.Second}, ReadOnlyInterval: time.Second},
+		{Backoff: backoffSchedule{Initial: 2 * time.Second, Max: time.Second}, ReadOnlyInterval: time.Second},
+		{Backoff: global.Backoff},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", bad)
+		}
+	}
+
+	t.Setenv(RetriesEnv, "100")
+	if _, err := retryPolicyFromEnv(); err == nil {
+		t.Error("expected an error for too many retries")
+	}
+}
+
+func TestPresentRetries(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	failures := 2
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return flakyLiveDNS{fakeLiveDNS: gandiClient, mu: &sync.Mutex{}, failures: &failures, err: requestError(503)}
+	}
+
+	// Without retries, the first failure is returned.
+	if err := solver.Present(newTestChallenge("key")); err == nil {
+		t.Fatal("expected Present to fail without retries")
+	}
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "retry": {"maxRetries": 2, "initialInterval": "1ms"}}`)
+	failures = 2
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("expected Present to succeed after retries: %v", err)
+	}
+
+	// Errors that are not retriable are returned right away.
+	failures = 1
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return flakyLiveDNS{fakeLiveDNS: gandiClient, mu: &sync.Mutex{}, failures: &failures, err: requestError(403)}
+	}
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected a 403 not to be retried")
+	}
+
+	if _, err := loadConfig(&extapi.JSON{Raw: []byte(`{"retry": {"maxRetries": 2}}`)}); err != nil {
+		t.Errorf("loadConfig: %v", err)
+	}
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "retry": {"maxRetries": 50}}`)
+	if err := solver.Present(ch); err == nil {
+		t.Error("expected an error for an invalid retry policy")
+	}
+}
+
+// TestRetryAttempts checks, with the real Gandi client, that a call
+// failing with a transient error reaches Gandi exactly 1+MaxRetries times:
+// apiLiveDNS must not retry on its own.
+func TestRetryAttempts(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"code": 503, "message": "Service Unavailable"}`)
+	}))
+	defer server.Close()
+
+	for _, retries := range []int{0, 1, 3} {
+		client := retryingLiveDNS{
+			liveDNSClient: newGandiLiveDNSClient(config.Config{PersonalAccessToken: testToken, APIURL: server.URL}),
+			policy: retryPolicy{
+				MaxRetries:       retries,
+				Backoff:          backoffSchedule{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond},
+				ReadOnlyInterval: time.Millisecond,
+			},
+		}
+		for _, call := range []struct {
+			name string
+			do   func() error
+		}{
+			{"get", func() error {
+				_, err := client.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT")
+				return err
+			}},
+			{"update", func() error {
+				_, err := client.UpdateDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"key"})
+				return err
+			}},
+			{"delete", func() error {
+				return client.DeleteDomainRecord("example.com", "_acme-challenge", "TXT")
+			}},
+		} {
+			attempts.Store(0)
+			if err := call.do(); err == nil {
+				t.Errorf("%s with %d retries: expected the 503 to be returned", call.name, retries)
+			}
+			if got := int(attempts.Load()); got != 1+retries {
+				t.Errorf("%s with %d retries: expected %d attempts, got %d", call.name, retries, 1+retries, got)
+			}
+		}
+	}
+}
+
+// lostCreateLiveDNS applies its first lost creates but fails them with err,
+// as when the answer of Gandi does not make it back.
+type lostCreateLiveDNS struct {
+	*fakeLiveDNS
+	lost *atomic.Int32
+	err  error
+}
+
+func (l lostCreateLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	resp, err := l.fakeLiveDNS.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	if err == nil && l.lost.Add(-1) >= 0 {
+		return types.StandardResponse{}, l.err
+	}
+	return resp, err
+}
+
+func TestRetryCreateRereadsRrset(t *testing.T) {
+	policy := retryPolicy{
+		MaxRetries:       2,
+		Backoff:          backoffSchedule{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond},
+		ReadOnlyInterval: time.Millisecond,
+	}
+
+	// A create applied despite its error is not sent again.
+	gandiClient := newFakeLiveDNS()
+	var lost atomic.Int32
+	lost.Store(1)
+	client := retryingLiveDNS{liveDNSClient: lostCreateLiveDNS{fakeLiveDNS: gandiClient, lost: &lost, err: requestError(503)}, policy: policy}
+	if _, err := client.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"key"}); err != nil {
+		t.Fatalf("expected the applied create to succeed, got %v", err)
+	}
+	if got := gandiClient.countCalls("create"); got != 1 {
+		t.Errorf("expected a single create, got %d", got)
+	}
+
+	// One that was not applied is sent again.
+	gandiClient = newFakeLiveDNS()
+	gandiClient.failWith("create", requestError(503))
+	client.liveDNSClient = gandiClient
+	if _, err := client.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"key"}); err == nil {
+		t.Fatal("expected the 503 to be returned")
+	}
+	if got, want := gandiClient.calls, []string{"create", "get", "create", "get", "create"}; !slices.Equal(got, want) {
+		t.Errorf("expected calls %v, got %v", want, got)
+	}
+}
+
+func TestPresentRetriesReadOnlyZone(t *testing.T) {
+	readOnly := &types.RequestError{StatusCode: 423, Err: fmt.Errorf("423: the zone is locked")}
+	var ge *gandiError
+	if err := classifyGandiError(readOnly); !errors.Is(err, errZoneReadOnly) || !isRetriable(err) || !errors.As(err, &ge) || ge.msg == "" {
+		t.Fatalf("expected a retriable read-only error with an explanation, got %v", err)
+	}
+	for _, code := range []int{409, 503} {
+		err := &types.RequestError{StatusCode: code, Err: fmt.Errorf("%d: the zone is read-only", code)}
+		if errors.Is(classifyGandiError(err), errZoneReadOnly) {
+			t.Errorf("expected a %d mentioning read-only not to match", code)
+		}
+	}
+
+	gandiClient := newFakeLiveDNS()
+	failures := 2
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return flakyLiveDNS{fakeLiveDNS: gandiClient, mu: &sync.Mutex{}, failures: &failures, err: readOnly}
+	}
+
+	// The read-only interval applies instead of the shorter backoff.
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"},
+		"retry": {"maxRetries": 2, "initialInterval": "1ms", "maxInterval": "1ms", "readOnlyInterval": "50ms"}}`)
+	start := time.Now()
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("expected Present to succeed once the zone is writable: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected two waits of the read-only interval, Present took %v", elapsed)
+	}
+
+	// A zone staying read-only is reported as such.
+	failures = 10
+	err := solver.Present(ch)
+	if !errors.Is(err, errZoneReadOnly) || !strings.Contains(err.Error(), "still read-only") {
+		t.Errorf("expected a persisting read-only error, got %v", err)
+	}
+}
+
+func TestOperationTimeoutSpansRetries(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	failures := 100
+	solver := newTestSolver(gandiClient)
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient {
+		return flakyLiveDNS{fakeLiveDNS: gandiClient, mu: &sync.Mutex{}, failures: &failures, err: requestError(503)}
+	}
+
+	// The retries alone would take about 10s.
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "operationTimeout": "150ms",
+		"retry": {"maxRetries": 10, "initialInterval": "50ms", "maxInterval": "1s"}}`)
+	start := time.Now()
+	err := solver.Present(ch)
+	if !errors.Is(err, errOperationTimeout) {
+		t.Fatalf("expected the operation deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Present took %v despite the operation deadline", elapsed)
+	}
+	if isRetriable(err) {
+		t.Error("an exceeded operation deadline should not be retried")
+	}
+
+	// The deadline also bounds the verification.
+	failures = 0
+	solver.resolver = &fakeResolver{visibleAfter: 1000}
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"}, "operationTimeout": "100ms",
+		"verification": {"enabled": true, "initialInterval": "10ms", "maxInterval": "10ms"}}`)
+	if err := solver.Present(ch); !errors.Is(err, errOperationTimeout) || !errors.Is(err, errNotPropagated) {
+		t.Errorf("expected the verification to stop at the operation deadline, got %v", err)
+	}
+
+	if _, err := loadConfig(&extapi.JSON{Raw: []byte(`{"operationTimeout": "-1s"}`)}); err == nil {
+		t.Error("expected a negative operationTimeout to be rejected")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// CheckAPISchemaEnv, set to "true", compares the responses of the Gandi API
+// with the fields go-gandi decodes, see schemaCheckTransport.
+const CheckAPISchemaEnv = "CHECK_API_SCHEMA"
+
+// maxSchemaCheckBody bounds the responses inspected; record responses are a
+// few kilobytes at most.
+const maxSchemaCheckBody = 1 << 20
+
+// responseSchema is the shape go-gandi expects from a LiveDNS response.
+type responseSchema struct {
+	name string
+	// known are the fields go-gandi decodes, required those the webhook
+	// relies on.
+	known    map[string]bool
+	required []string
+}
+
+var (
+	recordSchema = responseSchema{
+		name:     "record",
+		known:    jsonFields(livedns.DomainRecord{}),
+		required: []string{"rrset_name", "rrset_type", "rrset_values"},
+	}
+	standardSchema = responseSchema{
+		name:  "standard response",
+		known: jsonFields(types.StandardResponse{}),
+	}
+)
+
+// jsonFields returns the JSON field names of the struct v.
+func jsonFields(v any) map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// schemaCheckTransport warns about the LiveDNS record responses from host
+// that hold fields go-gandi ignores or lack fields the webhook needs, so
+// that a change of the API shows in the logs before it breaks challenges.
+// Each difference is only reported once. Values are never logged.
+type schemaCheckTransport struct {
+	next  http.RoundTripper
+	host  string
+	warnf func(format string, args ...interface{})
+	// reported holds the differences already reported.
+	reported *sync.Map
+}
+
+func (t schemaCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !strings.EqualFold(req.URL.Host, t.host) || !isRecordPath(req.URL.Path) ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return resp, err
+	}
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxSchemaCheckBody))
+	// The rest of the body, if any, is still handed to go-gandi.
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if readErr != nil {
+		return resp, err
+	}
+
+	schema := standardSchema
+	if req.Method == http.MethodGet && resp.StatusCode < http.StatusBadRequest {
+		schema = recordSchema
+	}
+	t.check(req.Method, schema, body)
+	return resp, err
+}
+
+// check reports the differences between the JSON object body and schema.
+func (t schemaCheckTransport) check(method string, schema responseSchema, body []byte) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.report(method+" "+schema.name+" is not a JSON object", "gandi api: %s %s response is not a JSON object: %v", method, schema.name, err)
+		return
+	}
+	var unexpected []string
+	for name := range fields {
+		if !schema.known[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	sort.Strings(unexpected)
+	for _, name := range unexpected {
+		t.report(method+" "+schema.name+" +"+name, "gandi api: %s %s response holds field %q unknown to go-gandi, the API may have changed", method, schema.name, name)
+	}
+	for _, name := range schema.required {
+		if _, ok := fields[name]; !ok {
+			t.report(method+" "+schema.name+" -"+name, "gandi api: %s %s response lacks field %q, the API may have changed", method, schema.name, name)
+		}
+	}
+}
+
+func (t schemaCheckTransport) report(key, format string, args ...interface{}) {
+	if _, seen := t.reported.LoadOrStore(key, true); !seen {
+		t.warnf(format, args...)
+	}
+}
+
+// isRecordPath reports whether path is that of a LiveDNS rrset,
+// `.../livedns/domains/<fqdn>/records/<name>/<type>`.
+func isRecordPath(path string) bool {
+	_, rest, ok := strings.Cut(path, "/livedns/domains/")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	return len(parts) == 4 && parts[1] == "records"
+}
+
+// installAPISchemaCheck wraps http.DefaultTransport with a
+// schemaCheckTransport for the host of apiURL, as installAPIRequestLogging
+// does.
+func installAPISchemaCheck(apiURL string) error {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = schemaCheckTransport{next: http.DefaultTransport, host: u.Host, warnf: klog.Warningf, reported: &sync.Map{}}
+	return nil
+}
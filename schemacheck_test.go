@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+func TestSchemaCheckTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, `{"rrset_name": "_acme-challenge", "rrset_type": "TXT", "rrset_ttl": 300, "rrset_values": ["secret"], "rrset_origin": "api"}`)
+		default:
+			fmt.Fprint(w, `{"message": "DNS Record Created"}`)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	var logs []string
+	client := &http.Client{Transport: schemaCheckTransport{
+		next:     http.DefaultTransport,
+		host:     u.Host,
+		warnf:    func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+		reported: &sync.Map{},
+	}}
+	path := server.URL + "/v5/livedns/domains/example.com/records/_acme-challenge/TXT"
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record livedns.DomainRecord
+		if err := json.NewDecoder(resp.Body).Decode(&record); err != nil || len(record.RrsetValues) != 1 {
+			t.Errorf("the body should still be readable, got %+v, %v", record, err)
+		}
+		resp.Body.Close()
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], `"rrset_origin"`) {
+		t.Fatalf("expected a single warning about the unknown field, got %q", logs)
+	}
+	if strings.Contains(logs[0], "secret") {
+		t.Errorf("warning %q leaks a value", logs[0])
+	}
+
+	// Known write responses are quiet, a record missing values is not.
+	resp, err := client.Post(path, "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(logs) != 1 {
+		t.Errorf("expected no warning for a known response, got %q", logs[1:])
+	}
+	schemaCheckTransport{warnf: client.Transport.(schemaCheckTransport).warnf, reported: &sync.Map{}}.
+		check(http.MethodGet, recordSchema, []byte(`{"rrset_name": "_acme-challenge", "rrset_type": "TXT"}`))
+	if len(logs) != 2 || !strings.Contains(logs[1], `lacks field "rrset_values"`) {
+		t.Errorf("expected a warning about the missing values, got %q", logs)
+	}
+
+	for path, want := range map[string]bool{
+		"/v5/livedns/domains/example.com/records/_acme-challenge/TXT":         true,
+		"/gateway/v5/livedns/domains/example.com/records/_acme-challenge/TXT": true,
+		"/v5/livedns/domains/example.com/records":                             false,
+		"/v5/livedns/domains/example.com":                                     false,
+	} {
+		if got := isRecordPath(path); got != want {
+			t.Errorf("isRecordPath(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// SecretInformerEnv enables serving credential Secrets from an informer
+	// cache instead of reading them from the API on every challenge. It needs
+	// list and watch permissions on Secrets, hence it is opt-in.
+	SecretInformerEnv = "SECRET_INFORMER"
+	// SecretLiveFallbackEnv disables, when set to false, reading a Secret
+	// again from the API when Gandi rejects the token found in the cache.
+	SecretLiveFallbackEnv = "SECRET_INFORMER_LIVE_FALLBACK"
+	// WatchNamespacesEnv restricts the informer to a comma-separated list of
+	// namespaces. All namespaces are watched when it is unset.
+	WatchNamespacesEnv = "WATCH_NAMESPACES"
+
+	secretInformerResync = 10 * time.Minute
+)
+
+// secretCache serves Secrets from informers, one per watched namespace or a
+// single one for all namespaces.
+type secretCache struct {
+	// all is set when every namespace is watched.
+	all corelisters.SecretLister
+	// namespaces holds one lister per watched namespace otherwise.
+	namespaces map[string]corelisters.SecretNamespaceLister
+	// liveFallback reads the Secret from the API when the token of the
+	// cached one is rejected, as right after a rotation the cache may still
+	// hold the previous token.
+	liveFallback bool
+}
+
+// parseWatchNamespaces validates the value of WatchNamespacesEnv and returns
+// the namespaces it lists, nil meaning all namespaces.
+func parseWatchNamespaces(v string) ([]string, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	seen := map[string]struct{}{}
+	var namespaces []string
+	for _, ns := range strings.Split(v, ",") {
+		ns = strings.TrimSpace(ns)
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q in %s: %s", ns, WatchNamespacesEnv, strings.Join(errs, ", "))
+		}
+		if _, ok := seen[ns]; ok {
+			return nil, fmt.Errorf("namespace %q is listed twice in %s", ns, WatchNamespacesEnv)
+		}
+		seen[ns] = struct{}{}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
+
+// newSecretCacheFromEnv starts the Secret informers when SecretInformerEnv is
+// set and waits for them to sync. It returns nil when the cache is disabled.
+func newSecretCacheFromEnv(client kubernetes.Interface, stopCh <-chan struct{}) (*secretCache, error) {
+	if os.Getenv(SecretInformerEnv) != "true" {
+		return nil, nil
+	}
+	namespaces, err := parseWatchNamespaces(os.Getenv(WatchNamespacesEnv))
+	if err != nil {
+		return nil, err
+	}
+	liveFallback, err := envBool(SecretLiveFallbackEnv, true)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := newSecretCache(client, namespaces, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	sc.liveFallback = liveFallback
+	return sc, nil
+}
+
+// newSecretCache starts informers for namespaces, or for all namespaces when
+// empty, and waits for them to sync.
+func newSecretCache(client kubernetes.Interface, namespaces []string, stopCh <-chan struct{}) (*secretCache, error) {
+	sc := &secretCache{}
+	var synced []cache.InformerSynced
+
+	if len(namespaces) == 0 {
+		factory := informers.NewSharedInformerFactory(client, secretInformerResync)
+		informer := factory.Core().V1().Secrets()
+		sc.all = informer.Lister()
+		synced = append(synced, informer.Informer().HasSynced)
+		factory.Start(stopCh)
+		klog.Infof("secret informer watching all namespaces")
+	} else {
+		sc.namespaces = map[string]corelisters.SecretNamespaceLister{}
+		for _, ns := range namespaces {
+			factory := informers.NewSharedInformerFactoryWithOptions(client, secretInformerResync, informers.WithNamespace(ns))
+			informer := factory.Core().V1().Secrets()
+			sc.namespaces[ns] = informer.Lister().Secrets(ns)
+			synced = append(synced, informer.Informer().HasSynced)
+			factory.Start(stopCh)
+		}
+		klog.Infof("secret informer watching namespaces %v", namespaces)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return nil, fmt.Errorf("unable to sync the secret informer cache")
+	}
+	return sc, nil
+}
+
+// lister returns the lister serving namespace, or nil if it is not watched.
+func (sc *secretCache) lister(namespace string) corelisters.SecretNamespaceLister {
+	if sc.all != nil {
+		return sc.all.Secrets(namespace)
+	}
+	return sc.namespaces[namespace]
+}
+
+// liveSecretsKey marks a context whose Secret reads bypass the informer
+// cache.
+type liveSecretsKey struct{}
+
+// withLiveSecrets returns a copy of ctx whose Secret reads bypass the
+// informer cache.
+func withLiveSecrets(ctx context.Context) context.Context {
+	return context.WithValue(ctx, liveSecretsKey{}, true)
+}
+
+// liveSecrets reports whether the Secret reads of ctx bypass the cache.
+func liveSecrets(ctx context.Context) bool {
+	live, _ := ctx.Value(liveSecretsKey{}).(bool)
+	return live
+}
+
+// getSecret returns the named Secret, from the informer cache when the
+// namespace is watched and from the API otherwise, or when ctx asks for it.
+func (c *gandiDNSProviderSolver) getSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	if c.secrets != nil && !liveSecrets(ctx) {
+		if l := c.secrets.lister(namespace); l != nil {
+			return l.Get(name)
+		}
+		klog.FromContext(ctx).V(6).Info("namespace is not watched, reading the secret from the API", "namespace", namespace, "name", name)
+	}
+	return c.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// listSecrets returns the Secrets of namespace matching selector, from the
+// informer cache when the namespace is watched and from the API otherwise,
+// or when ctx asks for it.
+func (c *gandiDNSProviderSolver) listSecrets(ctx context.Context, namespace string, selector labels.Selector) ([]*corev1.Secret, error) {
+	if c.secrets != nil && !liveSecrets(ctx) {
+		if l := c.secrets.lister(namespace); l != nil {
+			return l.List(selector)
+		}
+		klog.FromContext(ctx).V(6).Info("namespace is not watched, listing secrets from the API", "namespace", namespace)
+	}
+	list, err := c.client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]*corev1.Secret, len(list.Items))
+	for i := range list.Items {
+		secrets[i] = &list.Items[i]
+	}
+	return secrets, nil
+}
+
+// staleSecretLiveDNS retries, once, a call rejected as unauthenticated with
+// a client built from the Secret read from the API instead of the informer
+// cache, which may still hold the previous token after a rotation. The
+// refreshed client then serves the following calls.
+type staleSecretLiveDNS struct {
+	liveDNSClient
+	pat string
+	// refresh builds the client from the live Secret, with its token.
+	refresh func() (liveDNSClient, string, error)
+	state   *staleSecretState
+	// ctx is the context of the call, whose logger reports the refresh.
+	ctx context.Context
+}
+
+type staleSecretState struct {
+	mu sync.Mutex
+	// client is the refreshed client, nil until the first rejection.
+	client liveDNSClient
+	tried  bool
+}
+
+// current returns the client to use, the refreshed one if any.
+func (s staleSecretLiveDNS) current() liveDNSClient {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	if s.state.client != nil {
+		return s.state.client
+	}
+	return s.liveDNSClient
+}
+
+// fallback returns the client built from the live Secret when err rejects
+// the cached token and the live Secret holds another one, nil otherwise.
+func (s staleSecretLiveDNS) fallback(err error) liveDNSClient {
+	var ge *gandiError
+	if !errors.As(classifyGandiError(err), &ge) || ge.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	if s.state.tried {
+		return nil
+	}
+	s.state.tried = true
+	logger := klog.FromContext(s.ctx)
+	client, pat, refreshErr := s.refresh()
+	if refreshErr != nil {
+		logger.Info("the cached token was rejected and the Secret could not be read from the API", "err", refreshErr)
+		return nil
+	}
+	if pat == s.pat {
+		logger.V(6).Info("the cached token was rejected and the Secret read from the API holds the same one")
+		return nil
+	}
+	logger.Info("the cached token was rejected, using the one of the Secret read from the API")
+	s.state.client = client
+	return client
+}
+
+func (s staleSecretLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := s.current().GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	if client := s.fallback(err); client != nil {
+		return client.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	}
+	return record, err
+}
+
+func (s staleSecretLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	resp, err := s.current().CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	if client := s.fallback(err); client != nil {
+		return client.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	}
+	return resp, err
+}
+
+func (s staleSecretLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	resp, err := s.current().UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+	if client := s.fallback(err); client != nil {
+		return client.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+	}
+	return resp, err
+}
+
+func (s staleSecretLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	err := s.current().DeleteDomainRecord(fqdn, name, recordtype)
+	if client := s.fallback(err); client != nil {
+		return client.DeleteDomainRecord(fqdn, name, recordtype)
+	}
+	return err
+}
+
+func (s staleSecretLiveDNS) GetDomain(fqdn string) (livedns.Domain, error) {
+	domain, err := s.current().GetDomain(fqdn)
+	if client := s.fallback(err); client != nil {
+		return client.GetDomain(fqdn)
+	}
+	return domain, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestParseWatchNamespaces(t *testing.T) {
+	if ns, err := parseWatchNamespaces(""); err != nil || ns != nil {
+		t.Errorf("empty value should watch all namespaces, got %v, %v", ns, err)
+	}
+	ns, err := parseWatchNamespaces("cert-manager, tenant-a")
+	if err != nil || strings.Join(ns, ",") != "cert-manager,tenant-a" {
+		t.Errorf("unexpected result %v, %v", ns, err)
+	}
+	for _, v := range []string{"cert-manager,", "Cert-Manager", "a,a", "a/b"} {
+		if _, err := parseWatchNamespaces(v); err == nil {
+			t.Errorf("expected an error for %q", v)
+		}
+	}
+}
+
+func TestSecretCacheScopedNamespaces(t *testing.T) {
+	other := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-credentials", Namespace: "tenant-a"},
+		Data:       map[string][]byte{"api-token": []byte("tenant-a-token")},
+	}
+	solver := newTestSolver(newFakeLiveDNS(), other)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	secrets, err := newSecretCache(solver.client, []string{testNamespace}, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	solver.secrets = secrets
+
+	if secrets.lister(testNamespace) == nil || secrets.lister("tenant-a") != nil {
+		t.Fatal("only the configured namespace should be watched")
+	}
+
+	sec, err := solver.getSecret(context.Background(), testNamespace, "gandi-credentials")
+	if err != nil || string(sec.Data["api-token"]) != testToken {
+		t.Errorf("unexpected cached secret %v, %v", sec, err)
+	}
+	// unwatched namespaces are read from the API
+	sec, err = solver.getSecret(context.Background(), "tenant-a", "gandi-credentials")
+	if err != nil || string(sec.Data["api-token"]) != "tenant-a-token" {
+		t.Errorf("unexpected live secret %v, %v", sec, err)
+	}
+}
+
+// rejectingLiveDNS rejects every call as unauthenticated.
+type rejectingLiveDNS struct {
+	*fakeLiveDNS
+}
+
+func (f rejectingLiveDNS) GetDomainRecordByNameAndType(string, string, string) (livedns.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "rejected")
+	return livedns.DomainRecord{}, requestError(401)
+}
+
+func TestStaleCachedSecretFallsBackToAPI(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	// The API holds the rotated token while the cache still has the old one.
+	solver := newTestSolver(gandiClient)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": []byte("rotated-out-token")},
+	})
+	solver.secrets = &secretCache{all: corelisters.NewSecretLister(indexer), liveFallback: true}
+	var tokens []string
+	solver.newLiveDNSClient = func(cfg config.Config) liveDNSClient {
+		tokens = append(tokens, cfg.PersonalAccessToken)
+		if cfg.PersonalAccessToken != testToken {
+			return rejectingLiveDNS{fakeLiveDNS: gandiClient}
+		}
+		return gandiClient
+	}
+
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("expected Present to pick up the rotated token: %v", err)
+	}
+	if strings.Join(tokens, ",") != "rotated-out-token,"+testToken {
+		t.Errorf("expected the cached token then the live one, got %v", tokens)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("expected the record to be written, got %v", gandiClient.records)
+	}
+
+	// Without the fallback, the rejection is returned.
+	solver.secrets.liveFallback = false
+	tokens = nil
+	if err := solver.CleanUp(newTestChallenge("key")); err == nil {
+		t.Error("expected the stale token to be rejected without the fallback")
+	}
+	if len(tokens) != 1 {
+		t.Errorf("expected a single client without the fallback, got %v", tokens)
+	}
+
+	// A live Secret holding the same token is not retried.
+	solver.secrets.liveFallback = true
+	indexer.Update(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-token": []byte(testToken)},
+	})
+	rejected := gandiClient.countCalls("rejected")
+	solver.newLiveDNSClient = func(config.Config) liveDNSClient { return rejectingLiveDNS{fakeLiveDNS: gandiClient} }
+	if err := solver.CleanUp(newTestChallenge("key")); err == nil {
+		t.Error("expected a revoked token to be rejected")
+	}
+	if n := gandiClient.countCalls("rejected") - rejected; n != 1 {
+		t.Errorf("expected the call not to be repeated with the same token, got %d", n)
+	}
+}
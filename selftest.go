@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// SelfTestFlag switches the binary to the self-test mode instead of
+	// serving the webhook.
+	SelfTestFlag = "self-test"
+	// SelfTestPATEnv holds the Personal Access Token used by the self-test.
+	// It is not a flag so that the token does not show up in process lists.
+	SelfTestPATEnv = "GANDI_PAT"
+	// selfTestRecordName is the harmless record created by the self-test.
+	selfTestRecordName = "_cert-manager-webhook-gandi-self-test"
+)
+
+// isSelfTest reports whether args request the self-test mode.
+func isSelfTest(args []string) bool {
+	return hasFlag(args, SelfTestFlag)
+}
+
+// runSelfTest presents a TXT record in the given domain with the token from
+// SelfTestPATEnv, checks that Gandi returns it and cleans it up, printing each
+// step to out. This exercises credentials, zone access and permissions the
+// same way Present and CleanUp do, without cert-manager.
+func runSelfTest(args []string, out io.Writer, newClient func(config.Config) liveDNSClient) error {
+	fs := pflag.NewFlagSet(SelfTestFlag, pflag.ContinueOnError)
+	fs.Bool(SelfTestFlag, false, "present, verify and clean up a dummy TXT record, then exit")
+	domain := fs.String("domain", "", "Gandi LiveDNS domain to run the self-test against")
+	sharingID := fs.String("sharing-id", "", "Gandi organization ID owning the domain")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	*domain = strings.TrimSuffix(*domain, ".")
+	if *domain == "" {
+		return fmt.Errorf("--domain is required")
+	}
+	pat := os.Getenv(SelfTestPATEnv)
+	if pat == "" {
+		return fmt.Errorf("%s must hold the Personal Access Token to test", SelfTestPATEnv)
+	}
+
+	apiURL, err := apiURLFromEnv()
+	if err != nil {
+		return err
+	}
+
+	value, err := selfTestValue()
+	if err != nil {
+		return err
+	}
+	cfg := gandiDNSProviderConfig{}
+	gandiClient := redactingLiveDNS{
+		liveDNSClient: newClient(config.Config{PersonalAccessToken: pat, SharingID: *sharingID, APIURL: apiURL}),
+		secrets:       []string{pat},
+	}
+
+	fmt.Fprintf(out, "present: creating TXT %s.%s\n", selfTestRecordName, *domain)
+	if _, err := applyChallenge(context.Background(), gandiClient, cfg, *domain, selfTestRecordName, value); err != nil {
+		return fmt.Errorf("self-test failed: %v", err)
+	}
+
+	fmt.Fprintf(out, "verify: reading TXT %s.%s back\n", selfTestRecordName, *domain)
+	record, err := gandiClient.GetDomainRecordByNameAndType(*domain, selfTestRecordName, ChallengeRecordType)
+	if err != nil {
+		err = fmt.Errorf("self-test failed: unable to read TXT record: %w", classifyGandiError(err))
+	} else if _, found := removeValue(record.RrsetValues, value); !found {
+		err = fmt.Errorf("self-test failed: TXT record does not hold the presented value, got %v", record.RrsetValues)
+	}
+
+	// Always try to clean up, even when the verification failed.
+	fmt.Fprintf(out, "cleanup: removing TXT %s.%s\n", selfTestRecordName, *domain)
+	if _, cleanupErr := removeChallenge(context.Background(), gandiClient, cfg, *domain, selfTestRecordName, value); cleanupErr != nil {
+		if err != nil {
+			return fmt.Errorf("%v; cleanup also failed: %v", err, cleanupErr)
+		}
+		return fmt.Errorf("self-test failed: %v", cleanupErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "ok: credentials, zone access and permissions work for %s\n", *domain)
+	return nil
+}
+
+// selfTestValue returns a random value for the self-test record.
+func selfTestValue() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate self-test value: %v", err)
+	}
+	return "self-test-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-gandi/go-gandi/config"
+)
+
+func TestIsSelfTest(t *testing.T) {
+	if !isSelfTest([]string{"--self-test", "--domain", "example.com"}) {
+		t.Error("expected --self-test to be detected")
+	}
+	if isSelfTest([]string{"--tls-cert-file=/tls/tls.crt"}) {
+		t.Error("unexpected self-test detection")
+	}
+}
+
+func TestRunSelfTest(t *testing.T) {
+	t.Setenv(SelfTestPATEnv, testToken)
+	gandiClient := newFakeLiveDNS()
+	var used config.Config
+	newClient := captureConfig(&used, gandiClient)
+
+	var out bytes.Buffer
+	if err := runSelfTest([]string{"--self-test", "--domain", "example.com."}, &out, newClient); err != nil {
+		t.Fatalf("runSelfTest: %v\n%s", err, out.String())
+	}
+	if used.PersonalAccessToken != testToken {
+		t.Errorf("expected the token from %s, got %q", SelfTestPATEnv, used.PersonalAccessToken)
+	}
+	for _, step := range []string{"present:", "verify:", "cleanup:", "ok:"} {
+		if !strings.Contains(out.String(), step) {
+			t.Errorf("output misses step %q:\n%s", step, out.String())
+		}
+	}
+	if got := gandiClient.values("example.com", selfTestRecordName, "TXT"); got != nil {
+		t.Errorf("self-test record was not cleaned up: %v", got)
+	}
+}
+
+func TestRunSelfTestCleansUpOnFailure(t *testing.T) {
+	t.Setenv(SelfTestPATEnv, testToken)
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", selfTestRecordName, "TXT", []string{"leftover"})
+	gandiClient.failWith("update", requestError(403))
+	newClient := func(config.Config) liveDNSClient { return gandiClient }
+
+	var out bytes.Buffer
+	err := runSelfTest([]string{"--self-test", "--domain", "example.com"}, &out, newClient)
+	if err == nil || !strings.Contains(err.Error(), "LiveDNS permissions") {
+		t.Fatalf("expected a permission error, got %v", err)
+	}
+
+	if err := runSelfTest([]string{"--self-test"}, &out, newClient); err == nil {
+		t.Error("expected an error without --domain")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/pflag"
+)
+
+// validateServingArgs checks the serving flags understood by the cert-manager
+// webhook library (--bind-address, --secure-port, --tls-cert-file and
+// --tls-private-key-file) before the server starts, so that a bad value is
+// reported up front instead of after the apiserver has been set up.
+// Any other flag is left for the library to parse.
+//
+// There is no need to handle serving certificate rotation here: when
+// --tls-cert-file and --tls-private-key-file are set, the generic apiserver
+// serves them through a dynamic certificate provider that watches both files
+// and reloads them on change, without restarting the process.
+func validateServingArgs(args []string) error {
+	fs := pflag.NewFlagSet("serving", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.Usage = func() {}
+	bindAddress := fs.String("bind-address", "", "")
+	securePort := fs.Int("secure-port", 443, "")
+	certFile := fs.String("tls-cert-file", "", "")
+	keyFile := fs.String("tls-private-key-file", "", "")
+	// Those are parsed by the library, we only need to skip their values.
+	fs.BoolP("help", "h", false, "")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("invalid serving flags: %v", err)
+	}
+
+	if *bindAddress != "" && net.ParseIP(*bindAddress) == nil {
+		return fmt.Errorf("invalid --bind-address %q: not an IP address", *bindAddress)
+	}
+	if *securePort < 1 || *securePort > 65535 {
+		return fmt.Errorf("invalid --secure-port %d: must be between 1 and 65535", *securePort)
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		return fmt.Errorf("--tls-cert-file and --tls-private-key-file must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestValidateServingArgs(t *testing.T) {
+	valid := [][]string{
+		nil,
+		{"--tls-cert-file=/tls/tls.crt", "--tls-private-key-file=/tls/tls.key", "--v=6"},
+		{"--bind-address=0.0.0.0", "--secure-port", "8443", "--unknown-flag=1"},
+		{"--bind-address=::"},
+	}
+	for _, args := range valid {
+		if err := validateServingArgs(args); err != nil {
+			t.Errorf("validateServingArgs(%q): unexpected error %v", args, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"--bind-address=localhost"},
+		{"--secure-port=0"},
+		{"--secure-port=70000"},
+		{"--secure-port=https"},
+		{"--tls-cert-file=/tls/tls.crt"},
+		{"--tls-private-key-file=/tls/tls.key"},
+	}
+	for _, args := range invalid {
+		if err := validateServingArgs(args); err == nil {
+			t.Errorf("validateServingArgs(%q): expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// SkipNamespaceLabelEnv names a namespace label which, set to "true" on the
+// namespace of a challenge, makes Present and CleanUp succeed without
+// touching Gandi. It lets operators stop solving for some namespaces, e.g.
+// during an incident, without editing their issuers.
+const SkipNamespaceLabelEnv = "SKIP_NAMESPACE_LABEL"
+
+// skipSolving reports whether the namespace carries c.skipLabel set to
+// "true". A namespace that cannot be read is solved as usual, with a
+// warning, so that the label can only turn solving off.
+func (c *gandiDNSProviderSolver) skipSolving(ctx context.Context, namespace string, timeout time.Duration) bool {
+	if c.skipLabel == "" || c.client == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		klog.FromContext(ctx).Info("unable to read the namespace to check its label, solving anyway", "namespace", namespace, "label", c.skipLabel, "err", err)
+		return false
+	}
+	return ns.Labels[c.skipLabel] == "true"
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// ConfigStatusConfigMapEnv is the `<namespace>/<name>` of a ConfigMap the
+// webhook writes its effective settings to on startup, so that they can be
+// inspected with kubectl. It needs create and update permissions on that
+// ConfigMap and is disabled when unset.
+const ConfigStatusConfigMapEnv = "CONFIG_STATUS_CONFIGMAP"
+
+// effectiveSettings returns the settings c runs with, keyed by the
+// environment variable setting each and with defaults applied. Credentials
+// never appear in them.
+func (c *gandiDNSProviderSolver) effectiveSettings() map[string]string {
+	apiURL := c.apiURL
+	if u, err := url.Parse(apiURL); err == nil {
+		apiURL = u.Redacted()
+	}
+	retry := c.retryDefaults()
+	return map[string]string{
+		APIURLEnv:                apiURL,
+		CredentialsConfigMapEnv:  c.credentialsConfigMap,
+		SecretInformerEnv:        strconv.FormatBool(c.secrets != nil),
+		DebugFQDNEnv:             strconv.FormatBool(c.debugFQDN),
+		MaxSecretSizeEnv:         strconv.Itoa(c.secretSizeLimit()),
+		MaxFQDNDepthEnv:          strconv.Itoa(c.fqdnDepthLimit()),
+		MaxCachedZonesEnv:        strconv.Itoa(c.cachedZonesLimit()),
+		BatchWindowEnv:           c.batchWindow.String(),
+		CoalesceCleanupsEnv:      strconv.FormatBool(c.coalesceCleanups),
+		MetricsZoneLabelEnv:      strconv.FormatBool(c.metricsZoneLabel),
+		AuditLogEnv:              strconv.FormatBool(c.auditLog),
+		ZoneConcurrencyEnv:       strconv.Itoa(c.zoneSlots.limit),
+		IssuerRateLimitEnv:       strconv.FormatFloat(float64(c.limiters.limit), 'g', -1, 64),
+		IssuerRateBurstEnv:       strconv.Itoa(c.limiters.burst),
+		RetriesEnv:               strconv.Itoa(retry.MaxRetries),
+		RetryInitialIntervalEnv:  retry.Backoff.Initial.String(),
+		RetryMaxIntervalEnv:      retry.Backoff.Max.String(),
+		RetryReadOnlyIntervalEnv: retry.ReadOnlyInterval.String(),
+	}
+}
+
+// publishSettings writes data to the ConfigMap name of namespace, creating
+// it if needed and replacing its previous data.
+func publishSettings(ctx context.Context, client kubernetes.Interface, namespace, name string, data map[string]string) error {
+	configMaps := client.CoreV1().ConfigMaps(namespace)
+	cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Data: data}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm.Data = data
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// publishEffectiveSettings writes the effective settings of c to the
+// ConfigMap name of namespace, only logging failures: the status is informational and
+// must not keep the webhook from serving.
+func (c *gandiDNSProviderSolver) publishEffectiveSettings(ctx context.Context, namespace, name string) {
+	if err := publishSettings(ctx, c.client, namespace, name, c.effectiveSettings()); err != nil {
+		klog.Warningf("unable to write the effective settings to configmap `%s/%s`: %v", namespace, name, err)
+		return
+	}
+	klog.V(6).Infof("wrote the effective settings to configmap `%s/%s`", namespace, name)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// tracer creates the spans of the webhook. It is a no-op until setupTracing
+// installs an exporting tracer provider.
+var tracer = otel.Tracer("github.com/fsvm88/cert-manager-webhook-gandi")
+
+// tracingConfigured reports whether an OTLP endpoint is set through the
+// standard OTEL_EXPORTER_OTLP_* environment variables.
+func tracingConfigured() bool {
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// setupTracing installs a tracer provider exporting spans over OTLP/gRPC
+// when tracingConfigured, configured by the standard OTEL_* environment
+// variables. The returned function flushes and stops the exporter; it is a
+// no-op when tracing is not configured.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !tracingConfigured() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	klog.V(6).Infof("tracing: exporting spans over OTLP")
+	return provider.Shutdown, nil
+}
+
+// recordRrsetAttributes sets the domain and record name on span.
+func recordRrsetAttributes(span trace.Span, domain, name string) {
+	span.SetAttributes(
+		attribute.String("gandi.domain", domain),
+		attribute.String("gandi.record.name", name),
+	)
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingLiveDNS creates a child span of the span in ctx for each call of
+// the wrapped client.
+type tracingLiveDNS struct {
+	liveDNSClient
+	ctx context.Context
+}
+
+// start starts the span of the call named call on the rrset name of fqdn.
+func (t tracingLiveDNS) start(call, fqdn, name, recordtype string) trace.Span {
+	_, span := tracer.Start(t.ctx, "gandi."+call, trace.WithSpanKind(trace.SpanKindClient))
+	recordRrsetAttributes(span, fqdn, name)
+	span.SetAttributes(attribute.String("gandi.record.type", recordtype))
+	return span
+}
+
+func (t tracingLiveDNS) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	span := t.start("GetDomainRecordByNameAndType", fqdn, name, recordtype)
+	record, err := t.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	endSpan(span, err)
+	return record, err
+}
+
+func (t tracingLiveDNS) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	span := t.start("CreateDomainRecord", fqdn, name, recordtype)
+	resp, err := t.liveDNSClient.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t tracingLiveDNS) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	span := t.start("UpdateDomainRecordByNameAndType", fqdn, name, recordtype)
+	resp, err := t.liveDNSClient.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+	endSpan(span, err)
+	return resp, err
+}
+
+func (t tracingLiveDNS) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	span := t.start("DeleteDomainRecord", fqdn, name, recordtype)
+	err := t.liveDNSClient.DeleteDomainRecord(fqdn, name, recordtype)
+	endSpan(span, err)
+	return err
+}
+
+func (t tracingLiveDNS) GetDomain(fqdn string) (livedns.Domain, error) {
+	_, span := tracer.Start(t.ctx, "gandi.GetDomain", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(attribute.String("gandi.domain", fqdn))
+	domain, err := t.liveDNSClient.GetDomain(fqdn)
+	endSpan(span, err)
+	return domain, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingConfigured(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if tracingConfigured() {
+		t.Error("tracing should be off without an endpoint")
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	if !tracingConfigured() {
+		t.Error("tracing should be on with an endpoint")
+	}
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if tracingConfigured() {
+		t.Error("OTEL_TRACES_EXPORTER=none should turn tracing off")
+	}
+}
+
+func TestPresentSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	solver := newTestSolver(newFakeLiveDNS())
+	if err := solver.Present(newTestChallenge("key")); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+
+	spans := recorder.Ended()
+	var names []string
+	var present sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		names = append(names, span.Name())
+		if span.Name() == "Present" {
+			present = span
+		}
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "Present,gandi.CreateDomainRecord,gandi.GetDomainRecordByNameAndType,getSecret" {
+		t.Fatalf("unexpected spans %s", got)
+	}
+
+	for _, span := range spans {
+		if span != present && span.Parent().SpanID() != present.SpanContext().SpanID() {
+			t.Errorf("span %s should be a child of Present", span.Name())
+		}
+	}
+	attrs := map[attribute.Key]string{}
+	for _, kv := range present.Attributes() {
+		attrs[kv.Key] = kv.Value.AsString()
+	}
+	if attrs["gandi.domain"] != "example.com" || attrs["gandi.record.name"] != "_acme-challenge" {
+		t.Errorf("unexpected Present attributes %v", attrs)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// APINetworkEnv forces the network of the connections to the Gandi API:
+	// "tcp4" or "tcp6". The default, "tcp", tries both as usual.
+	APINetworkEnv = "GANDI_API_NETWORK"
+	// APIMaxIdleConnsEnv and APIMaxIdleConnsPerHostEnv size the pool of
+	// idle connections kept open, by default those of http.DefaultTransport.
+	APIMaxIdleConnsEnv        = "GANDI_API_MAX_IDLE_CONNS"
+	APIMaxIdleConnsPerHostEnv = "GANDI_API_MAX_IDLE_CONNS_PER_HOST"
+)
+
+// apiTransportConfig holds the settings of the transport of the Gandi API
+// calls, zero values keeping those of the base transport.
+type apiTransportConfig struct {
+	Network             string
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+}
+
+// apiTransportConfigFromEnv reads the transport settings.
+func apiTransportConfigFromEnv() (apiTransportConfig, error) {
+	cfg := apiTransportConfig{Network: os.Getenv(APINetworkEnv)}
+	var err error
+	if cfg.MaxIdleConns, err = envInt(APIMaxIdleConnsEnv, 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIdleConnsPerHost, err = envInt(APIMaxIdleConnsPerHostEnv, 0); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// newAPITransport returns a copy of base configured with cfg.
+func newAPITransport(base *http.Transport, cfg apiTransportConfig) (*http.Transport, error) {
+	switch cfg.Network {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("invalid %s %q: must be tcp, tcp4 or tcp6", APINetworkEnv, cfg.Network)
+	}
+	if cfg.MaxIdleConns < 0 {
+		return nil, fmt.Errorf("%s must not be negative, got %d", APIMaxIdleConnsEnv, cfg.MaxIdleConns)
+	}
+	if cfg.MaxIdleConnsPerHost < 0 {
+		return nil, fmt.Errorf("%s must not be negative, got %d", APIMaxIdleConnsPerHostEnv, cfg.MaxIdleConnsPerHost)
+	}
+
+	t := base.Clone()
+	if network := cfg.Network; network != "" && network != "tcp" {
+		// Same settings as the dialer of http.DefaultTransport.
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	return t, nil
+}
+
+// installAPITransport replaces http.DefaultTransport with one configured from
+// the environment. apiHTTPClient relies on the default transport, so the
+// settings apply to every client relying on it. The Kubernetes clients have
+// their own transports.
+func installAPITransport() error {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unexpected default transport %T", http.DefaultTransport)
+	}
+	cfg, err := apiTransportConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	t, err := newAPITransport(base, cfg)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = t
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPITransportNetwork(t *testing.T) {
+	// httptest listens on 127.0.0.1 only.
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	base := http.DefaultTransport.(*http.Transport)
+
+	for network, reachable := range map[string]bool{"": true, "tcp": true, "tcp4": true, "tcp6": false} {
+		transport, err := newAPITransport(base, apiTransportConfig{Network: network})
+		if err != nil {
+			t.Fatalf("%q: %v", network, err)
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != reachable {
+			t.Errorf("%q: expected reachable=%v, got %v", network, reachable, err)
+		}
+		transport.CloseIdleConnections()
+	}
+
+	if _, err := newAPITransport(base, apiTransportConfig{Network: "udp"}); err == nil {
+		t.Error("expected an error for an unsupported network")
+	}
+}
+
+func TestAPITransportPool(t *testing.T) {
+	base := http.DefaultTransport.(*http.Transport)
+	transport, err := newAPITransport(base, apiTransportConfig{MaxIdleConns: 200, MaxIdleConnsPerHost: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.MaxIdleConns != 200 || transport.MaxIdleConnsPerHost != 50 {
+		t.Errorf("unexpected pool size %d, %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+	if transport, _ := newAPITransport(base, apiTransportConfig{}); transport.MaxIdleConns != base.MaxIdleConns {
+		t.Errorf("unset values should keep the defaults, got %d", transport.MaxIdleConns)
+	}
+
+	t.Setenv(APIMaxIdleConnsPerHostEnv, "-1")
+	cfg, err := apiTransportConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAPITransport(base, cfg); err == nil {
+		t.Error("expected an error for a negative pool size")
+	}
+}
+
+// BenchmarkAPITransportPool sends bursts of concurrent requests, as when
+// many challenges are solved at once, through pools of different sizes.
+// With the default of 2 idle connections per host, most requests of each
+// burst open a new connection: compare the new connections per burst.
+func BenchmarkAPITransportPool(b *testing.B) {
+	const burst = 16
+	var conns atomic.Int64
+	// The API takes some time to answer, so that requests overlap.
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	base := http.DefaultTransport.(*http.Transport)
+
+	for _, perHost := range []int{0, burst} {
+		b.Run(fmt.Sprintf("maxIdleConnsPerHost=%d", perHost), func(b *testing.B) {
+			transport, err := newAPITransport(base, apiTransportConfig{MaxIdleConnsPerHost: perHost})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer transport.CloseIdleConnections()
+			client := &http.Client{Transport: transport}
+			conns.Store(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < burst; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := client.Get(server.URL)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/burst")
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultVerifyTimeout bounds the whole propagation verification.
+	DefaultVerifyTimeout = 2 * time.Minute
+	// DefaultVerifyInitialInterval is the delay before the second lookup.
+	DefaultVerifyInitialInterval = 2 * time.Second
+	// DefaultVerifyMultiplier grows the delay between two lookups.
+	DefaultVerifyMultiplier = 2.0
+	// DefaultVerifyMaxInterval caps the delay between two lookups.
+	DefaultVerifyMaxInterval = 30 * time.Second
+	// DefaultVerifyMaxServfails is the number of consecutive SERVFAIL answers
+	// tolerated before giving up.
+	DefaultVerifyMaxServfails = 5
+)
+
+// Quorums of the nameservers of a zone confirming a change.
+const (
+	// QuorumOne settles with the first nameserver answering.
+	QuorumOne = "one"
+	// QuorumMajority needs more than half of the nameservers.
+	QuorumMajority = "majority"
+	// QuorumAll needs every nameserver.
+	QuorumAll = "all"
+)
+
+// verificationConfig is the `verification` block of the solver config.
+// When enabled, Present only returns once the challenge value can be
+// resolved, instead of leaving it all to cert-manager's self check.
+type verificationConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Timeout bounds the whole verification, defaults to DefaultVerifyTimeout.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// InitialInterval, Multiplier and MaxInterval shape the delay between
+	// two lookups, see backoffSchedule.
+	InitialInterval *metav1.Duration `json:"initialInterval,omitempty"`
+	Multiplier      float64          `json:"multiplier,omitempty"`
+	MaxInterval     *metav1.Duration `json:"maxInterval,omitempty"`
+	// MaxServfails is the number of consecutive SERVFAIL answers tolerated
+	// before the verification fails, defaults to DefaultVerifyMaxServfails.
+	// Unlike an empty answer, a SERVFAIL may point to a DNSSEC or delegation
+	// problem that waiting does not fix.
+	MaxServfails int `json:"maxServfails,omitempty"`
+	// Authoritative queries the nameservers of the zone directly instead of
+	// the system resolver, whose cache may hold a stale answer.
+	Authoritative bool `json:"authoritative,omitempty"`
+	// SystemFallback uses the system resolver when the nameservers of the
+	// zone cannot be discovered, defaults to true.
+	SystemFallback *bool `json:"systemFallback,omitempty"`
+	// WarmUp resolves the nameservers discovered by Authoritative once and
+	// reuses them, their resolvers and sockets, for the following lookups and
+	// challenges of the zone for up to nameserverCacheTTL.
+	WarmUp bool `json:"warmUp,omitempty"`
+	// Quorum is the number of nameservers that must confirm the change with
+	// Authoritative: QuorumOne, the default, QuorumMajority or QuorumAll.
+	Quorum string `json:"quorum,omitempty"`
+	// Resolver sends the lookups to a DNS-over-TLS or DNS-over-HTTPS
+	// endpoint instead of the system resolver, including when falling back
+	// from Authoritative.
+	Resolver *resolverConfig `json:"resolver,omitempty"`
+}
+
+// systemFallback returns SystemFallback or its default.
+func (v verificationConfig) systemFallback() bool {
+	return v.SystemFallback == nil || *v.SystemFallback
+}
+
+// timeout returns the configured verification timeout or its default.
+func (v verificationConfig) timeout() time.Duration {
+	if v.Timeout != nil && v.Timeout.Duration > 0 {
+		return v.Timeout.Duration
+	}
+	return DefaultVerifyTimeout
+}
+
+// quorumSize returns the number of nameservers out of n that must confirm
+// a change.
+func (v verificationConfig) quorumSize(n int) int {
+	switch v.Quorum {
+	case QuorumAll:
+		return n
+	case QuorumMajority:
+		return n/2 + 1
+	}
+	return 1
+}
+
+// maxServfails returns MaxServfails or its default.
+func (v verificationConfig) maxServfails() int {
+	if v.MaxServfails > 0 {
+		return v.MaxServfails
+	}
+	return DefaultVerifyMaxServfails
+}
+
+// schedule returns the backoff schedule with defaults applied.
+func (v verificationConfig) schedule() backoffSchedule {
+	b := backoffSchedule{
+		Initial:    DefaultVerifyInitialInterval,
+		Multiplier: DefaultVerifyMultiplier,
+		Max:        DefaultVerifyMaxInterval,
+	}
+	if v.InitialInterval != nil && v.InitialInterval.Duration > 0 {
+		b.Initial = v.InitialInterval.Duration
+	}
+	if v.Multiplier > 0 {
+		b.Multiplier = v.Multiplier
+	}
+	if v.MaxInterval != nil && v.MaxInterval.Duration > 0 {
+		b.Max = v.MaxInterval.Duration
+	}
+	return b
+}
+
+// validate rejects values the schedule cannot work with.
+func (v verificationConfig) validate() error {
+	if v.MaxServfails < 0 {
+		return fmt.Errorf("invalid verification maxServfails %d: must not be negative", v.MaxServfails)
+	}
+	if v.Multiplier != 0 && v.Multiplier < 1 {
+		return fmt.Errorf("invalid verification multiplier %v: must be at least 1", v.Multiplier)
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"timeout":         v.Timeout,
+		"initialInterval": v.InitialInterval,
+		"maxInterval":     v.MaxInterval,
+	} {
+		if d != nil && d.Duration < 0 {
+			return fmt.Errorf("invalid verification %s %v: must not be negative", name, d.Duration)
+		}
+	}
+	switch v.Quorum {
+	case "", QuorumOne, QuorumMajority, QuorumAll:
+	default:
+		return fmt.Errorf("invalid verification quorum %q: must be %q, %q or %q", v.Quorum, QuorumOne, QuorumMajority, QuorumAll)
+	}
+	if v.Resolver != nil {
+		if err := v.Resolver.validate(); err != nil {
+			return err
+		}
+	}
+	b := v.schedule()
+	if b.Max < b.Initial {
+		return fmt.Errorf("invalid verification maxInterval %v: lower than initialInterval %v", b.Max, b.Initial)
+	}
+	return nil
+}
+
+// backoffSchedule is an exponential backoff: the first delay is Initial and
+// each following one is Multiplier times the previous, capped at Max.
+type backoffSchedule struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+}
+
+// next returns the delay following prev; a zero prev yields Initial.
+func (b backoffSchedule) next(prev time.Duration) time.Duration {
+	if prev <= 0 {
+		return min(b.Initial, b.Max)
+	}
+	d := time.Duration(float64(prev) * b.Multiplier)
+	if d > b.Max || d < prev {
+		return b.Max
+	}
+	return d
+}
+
+// txtResolver looks up the TXT values of an FQDN.
+type txtResolver interface {
+	LookupTXT(ctx context.Context, fqdn string) ([]string, error)
+}
+
+// errNotPropagated wraps every verification failure. The record has been
+// written by then: a retry of Present by cert-manager only waits again.
+var errNotPropagated = errors.New("the TXT record was written to Gandi but is not visible yet")
+
+// errNotRemoved wraps every failure of the cleanup verification.
+var errNotRemoved = errors.New("the TXT value was removed from Gandi but is still visible")
+
+// errPropagationTimeout is returned when the value cannot be resolved before
+// the verification timeout.
+var errPropagationTimeout = errors.New("timed out waiting for the TXT record change to propagate")
+
+// errServfail is returned when the resolver keeps answering SERVFAIL.
+var errServfail = errors.New("the resolver keeps failing (SERVFAIL)")
+
+// isServfail reports whether err is a resolver failure, as opposed to a
+// clean NXDOMAIN or empty answer, or a timeout.
+func isServfail(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && !dnsErr.IsNotFound && !dnsErr.IsTimeout && dnsErr.IsTemporary
+}
+
+// waitForTXT polls resolver until fqdn resolves to a set holding value,
+// sleeping between lookups as dictated by schedule. Lookup failures are
+// retried, as they are expected until the record has propagated, but more
+// than maxServfails consecutive SERVFAIL answers fail with errServfail;
+// maxServfails <= 0 tolerates any number. It returns errPropagationTimeout
+// once the deadline of ctx is exceeded and the error of ctx as soon as it is
+// canceled otherwise.
+func waitForTXT(ctx context.Context, resolver txtResolver, fqdn, value string, schedule backoffSchedule, maxServfails int) error {
+	return pollTXT(ctx, resolver, fqdn, value, true, schedule, maxServfails)
+}
+
+// waitForTXTGone is waitForTXT the other way around: it returns once fqdn
+// resolves to a set without value, or does not resolve at all.
+func waitForTXTGone(ctx context.Context, resolver txtResolver, fqdn, value string, schedule backoffSchedule, maxServfails int) error {
+	return pollTXT(ctx, resolver, fqdn, value, false, schedule, maxServfails)
+}
+
+// pollTXT polls resolver until value is in the TXT set of fqdn when present
+// is set, or out of it otherwise, see waitForTXT.
+func pollTXT(ctx context.Context, resolver txtResolver, fqdn, value string, present bool, schedule backoffSchedule, maxServfails int) error {
+	var delay time.Duration
+	servfails := 0
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			return waitError(ctx, fqdn, attempt-1)
+		}
+		values, err := resolver.LookupTXT(ctx, fqdn)
+		switch {
+		case err == nil:
+			if _, found := removeValue(values, value); found == present {
+				klog.FromContext(ctx).V(6).Info("verify: settled", "fqdn", fqdn, "lookups", attempt)
+				return nil
+			}
+			servfails = 0
+		case !present && isNXDomain(err):
+			klog.FromContext(ctx).V(6).Info("verify: gone", "fqdn", fqdn, "lookups", attempt)
+			return nil
+		case isServfail(err):
+			servfails++
+			if maxServfails > 0 && servfails > maxServfails {
+				return fmt.Errorf("%w: %s after %d consecutive SERVFAIL answers: %v", errServfail, fqdn, servfails, err)
+			}
+		default:
+			servfails = 0
+		}
+		klog.FromContext(ctx).V(6).Info("verify: not settled yet", "fqdn", fqdn, "lookup", attempt, "values", values, "err", err)
+
+		delay = schedule.next(delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waitError(ctx, fqdn, attempt)
+		case <-timer.C:
+		}
+	}
+}
+
+// isNXDomain reports whether err tells that the name does not exist or has
+// no TXT record.
+func isNXDomain(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// waitError explains why waitForTXT stopped before fqdn propagated.
+func waitError(ctx context.Context, fqdn string, lookups int) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s after %d lookups", errPropagationTimeout, fqdn, lookups)
+	}
+	return fmt.Errorf("stopped waiting for %s after %d lookups: %w", fqdn, lookups, ctx.Err())
+}
+
+// systemResolver resolves through the resolver configured on the host.
+type systemResolver struct{}
+
+func (systemResolver) LookupTXT(ctx context.Context, fqdn string) ([]string, error) {
+	return net.DefaultResolver.LookupTXT(ctx, fqdn)
+}
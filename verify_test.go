@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeResolver answers values once it has been looked up visibleAfter times.
+type fakeResolver struct {
+	mu      sync.Mutex
+	lookups int
+	// visibleAfter is the number of lookups returning nothing.
+	visibleAfter int
+	values       []string
+}
+
+func (r *fakeResolver) LookupTXT(_ context.Context, _ string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if r.lookups <= r.visibleAfter {
+		return nil, errors.New("no such host")
+	}
+	return r.values, nil
+}
+
+func TestBackoffSchedule(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule backoffSchedule
+		want     []time.Duration
+	}{
+		{
+			name:     "defaults",
+			schedule: verificationConfig{}.schedule(),
+			want:     []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name: "configured",
+			schedule: verificationConfig{
+				InitialInterval: &metav1.Duration{Duration: time.Second},
+				Multiplier:      1.5,
+				MaxInterval:     &metav1.Duration{Duration: 3 * time.Second},
+			}.schedule(),
+			want: []time.Duration{time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:     "constant",
+			schedule: backoffSchedule{Initial: time.Second, Multiplier: 1, Max: time.Minute},
+			want:     []time.Duration{time.Second, time.Second, time.Second},
+		},
+	}
+	for _, tc := range cases {
+		var d time.Duration
+		for i, want := range tc.want {
+			d = tc.schedule.next(d)
+			if d != want {
+				t.Errorf("%s: delay %d = %v, want %v", tc.name, i, d, want)
+			}
+		}
+	}
+}
+
+func TestVerificationConfigValidate(t *testing.T) {
+	if err := (verificationConfig{}).validate(); err != nil {
+		t.Errorf("defaults should be valid: %v", err)
+	}
+	invalid := []verificationConfig{
+		{Multiplier: 0.5},
+		{Timeout: &metav1.Duration{Duration: -time.Second}},
+		{InitialInterval: &metav1.Duration{Duration: time.Minute}, MaxInterval: &metav1.Duration{Duration: time.Second}},
+	}
+	for _, v := range invalid {
+		if err := v.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", v)
+		}
+	}
+}
+
+func TestWaitForTXT(t *testing.T) {
+	schedule := backoffSchedule{Initial: time.Millisecond, Multiplier: 2, Max: 4 * time.Millisecond}
+
+	resolver := &fakeResolver{visibleAfter: 3, values: []string{"other", "key"}}
+	if err := waitForTXT(context.Background(), resolver, testFQDN, "key", schedule, 0); err != nil {
+		t.Fatalf("waitForTXT: %v", err)
+	}
+	if resolver.lookups != 4 {
+		t.Errorf("expected 4 lookups, got %d", resolver.lookups)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	resolver = &fakeResolver{values: []string{"other"}}
+	if err := waitForTXT(ctx, resolver, testFQDN, "key", schedule, 0); !errors.Is(err, errPropagationTimeout) {
+		t.Errorf("expected a propagation timeout, got %v", err)
+	}
+}
+
+// errorResolver fails its lookups with errs, in order, then succeeds.
+type errorResolver struct {
+	errs    []error
+	values  []string
+	lookups int
+}
+
+func (r *errorResolver) LookupTXT(context.Context, string) ([]string, error) {
+	r.lookups++
+	if r.lookups <= len(r.errs) {
+		return nil, r.errs[r.lookups-1]
+	}
+	return r.values, nil
+}
+
+func TestWaitForTXTServfail(t *testing.T) {
+	schedule := backoffSchedule{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond}
+	servfail := &net.DNSError{Err: "server misbehaving", Name: testFQDN, IsTemporary: true}
+	nxdomain := &net.DNSError{Err: "no such host", Name: testFQDN, IsNotFound: true}
+
+	if isServfail(nxdomain) || !isServfail(servfail) || isServfail(errors.New("other")) {
+		t.Fatal("unexpected isServfail classification")
+	}
+
+	// SERVFAIL answers up to the limit are retried.
+	resolver := &errorResolver{errs: []error{servfail, servfail, nxdomain, servfail, servfail}, values: []string{"key"}}
+	if err := waitForTXT(context.Background(), resolver, testFQDN, "key", schedule, 2); err != nil {
+		t.Errorf("expected the record to be found, got %v", err)
+	}
+
+	// More consecutive SERVFAIL answers fail without waiting for the timeout.
+	resolver = &errorResolver{errs: []error{servfail, servfail, servfail}, values: []string{"key"}}
+	if err := waitForTXT(context.Background(), resolver, testFQDN, "key", schedule, 2); !errors.Is(err, errServfail) {
+		t.Errorf("expected a SERVFAIL error, got %v", err)
+	}
+	if resolver.lookups != 3 {
+		t.Errorf("expected 3 lookups, got %d", resolver.lookups)
+	}
+
+	// NXDOMAIN answers are retried until the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	resolver = &errorResolver{errs: make([]error, 1000)}
+	for i := range resolver.errs {
+		resolver.errs[i] = nxdomain
+	}
+	if err := waitForTXT(ctx, resolver, testFQDN, "key", schedule, 2); !errors.Is(err, errPropagationTimeout) {
+		t.Errorf("expected a propagation timeout, got %v", err)
+	}
+}
+
+func TestWaitForTXTCanceledMidPoll(t *testing.T) {
+	schedule := backoffSchedule{Initial: time.Hour, Multiplier: 2, Max: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := waitForTXT(ctx, &fakeResolver{values: []string{"other"}}, testFQDN, "key", schedule, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitForTXT took %v to return after cancellation", elapsed)
+	}
+
+	// An already canceled context makes no lookup at all.
+	resolver := &fakeResolver{values: []string{"key"}}
+	if err := waitForTXT(ctx, resolver, testFQDN, "key", schedule, 0); !errors.Is(err, context.Canceled) || resolver.lookups != 0 {
+		t.Errorf("expected no lookup after cancellation, got %d lookups and %v", resolver.lookups, err)
+	}
+}
+
+func TestVerifyPropagationStopsOnShutdown(t *testing.T) {
+	stopCh := make(chan struct{})
+	solver := &gandiDNSProviderSolver{resolver: &fakeResolver{values: []string{"other"}}, stopCh: stopCh}
+	time.AfterFunc(20*time.Millisecond, func() { close(stopCh) })
+
+	v := verificationConfig{Enabled: true, InitialInterval: &metav1.Duration{Duration: time.Hour}, MaxInterval: &metav1.Duration{Duration: time.Hour}}
+	start := time.Now()
+	if err := solver.verifyPropagation(context.Background(), v, testZone, testFQDN, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("verifyPropagation took %v to return after shutdown", elapsed)
+	}
+}
+
+func TestPresentVerifiesPropagation(t *testing.T) {
+	solver := newTestSolver(newFakeLiveDNS())
+	solver.resolver = &fakeResolver{visibleAfter: 1, values: []string{"key"}}
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"},
+		"verification": {"enabled": true, "initialInterval": "1ms", "timeout": "1s"}}`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+
+	// A failed verification tells that the record was written all the same.
+	gandiClient := newFakeLiveDNS()
+	solver = newTestSolver(gandiClient)
+	solver.resolver = &fakeResolver{values: []string{"other"}}
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"},
+		"verification": {"enabled": true, "initialInterval": "1ms", "timeout": "20ms"}}`)
+	err := solver.Present(ch)
+	if !errors.Is(err, errNotPropagated) || !errors.Is(err, errPropagationTimeout) {
+		t.Errorf("expected a not propagated error, got %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("the record should have been written, got %v", got)
+	}
+}
+
+func TestWaitForTXTGone(t *testing.T) {
+	schedule := backoffSchedule{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond}
+	nxdomain := &net.DNSError{Err: "no such host", Name: testFQDN, IsNotFound: true}
+
+	// Still visible twice, then only the other values are left.
+	stale := &sequenceResolver{answers: [][]string{{"key", "other"}, {"key"}}, then: &fakeResolver{values: []string{"other"}}}
+	if err := waitForTXTGone(context.Background(), stale, testFQDN, "key", schedule, 0); err != nil {
+		t.Fatal(err)
+	}
+	if stale.lookups != 3 {
+		t.Errorf("expected 3 lookups, got %d", stale.lookups)
+	}
+
+	// The rrset is gone altogether.
+	if err := waitForTXTGone(context.Background(), &errorResolver{errs: []error{nxdomain}}, testFQDN, "key", schedule, 0); err != nil {
+		t.Errorf("NXDOMAIN should count as removed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := waitForTXTGone(ctx, &fakeResolver{values: []string{"key"}}, testFQDN, "key", schedule, 0); !errors.Is(err, errPropagationTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+// sequenceResolver returns answers in turn, then defers to then.
+type sequenceResolver struct {
+	answers [][]string
+	then    txtResolver
+	lookups int
+}
+
+func (r *sequenceResolver) LookupTXT(ctx context.Context, fqdn string) ([]string, error) {
+	r.lookups++
+	if r.lookups <= len(r.answers) {
+		return r.answers[r.lookups-1], nil
+	}
+	return r.then.LookupTXT(ctx, fqdn)
+}
+
+func TestCleanUpVerifiesRemoval(t *testing.T) {
+	gandiClient := newFakeLiveDNS()
+	gandiClient.set("example.com", "_acme-challenge", "TXT", []string{"key"})
+	solver := newTestSolver(gandiClient)
+	solver.resolver = &fakeResolver{values: []string{"key"}}
+
+	ch := newTestChallenge("key")
+	ch.Config.Raw = []byte(`{"PATSecretRef": {"name": "gandi-credentials"},
+		"cleanupVerification": {"enabled": true, "initialInterval": "1ms", "timeout": "20ms"}}`)
+	err := solver.CleanUp(ch)
+	if !errors.Is(err, errNotRemoved) || !errors.Is(err, errPropagationTimeout) {
+		t.Errorf("expected a not removed error, got %v", err)
+	}
+	if got := gandiClient.values("example.com", "_acme-challenge", "TXT"); len(got) != 0 {
+		t.Errorf("the value should have been removed from Gandi, got %v", got)
+	}
+
+	solver.resolver = &fakeResolver{values: []string{"other"}}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Errorf("CleanUp: %v", err)
+	}
+
+	// Off by default.
+	solver.resolver = &fakeResolver{values: []string{"key"}}
+	if err := solver.CleanUp(newTestChallenge("key")); err != nil {
+		t.Errorf("CleanUp without verification: %v", err)
+	}
+}
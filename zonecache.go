@@ -0,0 +1,82 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+)
+
+const (
+	// MaxCachedZonesEnv overrides DefaultMaxCachedZones.
+	MaxCachedZonesEnv = "MAX_CACHED_ZONES"
+	// DefaultMaxCachedZones is the default number of zones each per-zone
+	// cache holds, enough for the zones of most accounts while keeping the
+	// memory of the largest ones bounded.
+	DefaultMaxCachedZones = 1000
+)
+
+// zoneLRU maps zone names, compared case-insensitively, to values. Beyond
+// its limit it evicts the least recently used zone. Its zero value holds up
+// to DefaultMaxCachedZones zones. It is not safe for concurrent use, callers
+// hold their own lock.
+type zoneLRU[V any] struct {
+	limit int
+	// order holds the *zoneLRUEntry of the zones, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type zoneLRUEntry[V any] struct {
+	zone  string
+	value V
+}
+
+// get returns the value of zone and marks it as used.
+func (z *zoneLRU[V]) get(zone string) (V, bool) {
+	e, ok := z.entries[strings.ToLower(zone)]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	z.order.MoveToFront(e)
+	return e.Value.(*zoneLRUEntry[V]).value, true
+}
+
+// put sets the value of zone, evicting the least recently used zones beyond
+// the limit.
+func (z *zoneLRU[V]) put(zone string, value V) {
+	zone = strings.ToLower(zone)
+	if e, ok := z.entries[zone]; ok {
+		e.Value.(*zoneLRUEntry[V]).value = value
+		z.order.MoveToFront(e)
+		return
+	}
+	if z.entries == nil {
+		z.entries = map[string]*list.Element{}
+		z.order = list.New()
+	}
+	z.entries[zone] = z.order.PushFront(&zoneLRUEntry[V]{zone: zone, value: value})
+	z.evict()
+}
+
+// setLimit changes the limit, zero restoring DefaultMaxCachedZones, and
+// evicts the zones beyond it.
+func (z *zoneLRU[V]) setLimit(limit int) {
+	z.limit = limit
+	z.evict()
+}
+
+func (z *zoneLRU[V]) len() int {
+	return len(z.entries)
+}
+
+func (z *zoneLRU[V]) evict() {
+	limit := z.limit
+	if limit <= 0 {
+		limit = DefaultMaxCachedZones
+	}
+	for len(z.entries) > limit {
+		oldest := z.order.Back()
+		z.order.Remove(oldest)
+		delete(z.entries, oldest.Value.(*zoneLRUEntry[V]).zone)
+	}
+}
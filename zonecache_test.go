@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestZoneLRUEviction(t *testing.T) {
+	var cache zoneLRU[int]
+	cache.setLimit(3)
+	for i, zone := range []string{"a.example", "b.example", "c.example"} {
+		cache.put(zone, i)
+	}
+	// Using a.example makes b.example the least recently used zone.
+	if v, ok := cache.get("A.example"); !ok || v != 0 {
+		t.Fatalf("expected a.example to be cached, got %d, %v", v, ok)
+	}
+	cache.put("d.example", 3)
+	if cache.len() != 3 {
+		t.Errorf("expected 3 zones at the limit, got %d", cache.len())
+	}
+	if _, ok := cache.get("b.example"); ok {
+		t.Error("expected b.example to be evicted")
+	}
+	for _, zone := range []string{"a.example", "c.example", "d.example"} {
+		if _, ok := cache.get(zone); !ok {
+			t.Errorf("expected %s to be kept", zone)
+		}
+	}
+
+	// Updating a zone does not grow the cache.
+	cache.put("c.example", 20)
+	if v, _ := cache.get("c.example"); v != 20 || cache.len() != 3 {
+		t.Errorf("expected c.example updated in place, got %d with %d zones", v, cache.len())
+	}
+
+	// Lowering the limit evicts the oldest zones right away.
+	cache.setLimit(1)
+	if cache.len() != 1 {
+		t.Fatalf("expected 1 zone, got %d", cache.len())
+	}
+	if _, ok := cache.get("c.example"); !ok {
+		t.Error("expected the most recently used zone to be kept")
+	}
+
+	var unbounded zoneLRU[bool]
+	for i := 0; i < DefaultMaxCachedZones+10; i++ {
+		unbounded.put(fmt.Sprintf("z%d.example", i), true)
+	}
+	if unbounded.len() != DefaultMaxCachedZones {
+		t.Errorf("expected the zero value to hold %d zones, got %d", DefaultMaxCachedZones, unbounded.len())
+	}
+}
+
+func TestInitializeMaxCachedZones(t *testing.T) {
+	kubeConfig := &rest.Config{Host: "http://127.0.0.1:9", Timeout: time.Second}
+	t.Setenv(MaxCachedZonesEnv, "2")
+	solver := &gandiDNSProviderSolver{}
+	if err := solver.Initialize(kubeConfig, make(chan struct{})); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	for _, zone := range []string{"a.example", "b.example", "c.example"} {
+		solver.nameForms.put(context.Background(), "scope", zone, RecordNameQualified)
+	}
+	if got := solver.nameForms.get("scope", "a.example"); got != "" {
+		t.Errorf("expected a.example to be evicted, got %q", got)
+	}
+	if got := solver.nameForms.get("scope", "c.example"); got != RecordNameQualified {
+		t.Errorf("expected c.example to be kept, got %q", got)
+	}
+
+	t.Setenv(MaxCachedZonesEnv, "0")
+	if err := (&gandiDNSProviderSolver{}).Initialize(kubeConfig, make(chan struct{})); err == nil {
+		t.Errorf("expected %s=0 to be rejected", MaxCachedZonesEnv)
+	}
+}